@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// session is the game the player is in, if any. Subscriptions can't be
+// cancelled, so a game's handlers stay subscribed after the player leaves
+// it and ignore its messages until they join it again.
+type session struct {
+	username  string
+	transport pubsub.Transport
+	subOpts   []pubsub.SubscribeOption
+
+	mu         *sync.Mutex
+	gs         *gamelogic.GameState
+	subscribed map[string]bool
+	stores     map[string]gamelogic.EventStore
+}
+
+func newSession(username string, transport pubsub.Transport, subOpts []pubsub.SubscribeOption) *session {
+	return &session{
+		username:   username,
+		transport:  transport,
+		subOpts:    subOpts,
+		mu:         &sync.Mutex{},
+		subscribed: map[string]bool{},
+		stores:     map[string]gamelogic.EventStore{},
+	}
+}
+
+// current returns the state of the player's game, or nil in the lobby
+func (s *session) current() *gamelogic.GameState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.gs
+}
+
+// in returns the state of game if the player is in it, or nil
+func (s *session) in(game string) *gamelogic.GameState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.gs == nil || s.gs.Game != game {
+		return nil
+	}
+	return s.gs
+}
+
+// enter rebuilds the player's state in game from its event log, for the
+// server's sync delta to bring up to date, or returns to the lobby if game
+// is ""
+func (s *session) enter(game string, paused bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if game == "" {
+		s.gs = nil
+		return
+	}
+	if s.gs == nil || s.gs.Game != game {
+		gs, err := gamelogic.RebuildGameState(s.username, game, s.store(game))
+		if err != nil {
+			fmt.Printf("Failed to rebuild %s from its event log, starting over: %v\n", game, err)
+			gs = gamelogic.NewGameState(s.username, game)
+		}
+		s.gs = gs
+	}
+	err := s.gs.SetPaused(paused)
+	if err != nil {
+		fmt.Printf("Failed to record pause: %v\n", err)
+	}
+}
+
+// store returns the log of the player's events in game. s.mu must be held.
+func (s *session) store(game string) gamelogic.EventStore {
+	store, ok := s.stores[game]
+	if !ok {
+		store = gamelogic.NewFileEventStore(gamelogic.EventFileName(s.username, game))
+		s.stores[game] = store
+	}
+	return store
+}
+
+// load restores a save on top of its game's event log
+func (s *session) load(save gamelogic.SaveFile) (*gamelogic.GameState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return gamelogic.RestoreGameState(save, s.store(save.Game))
+}
+
+// restore puts a loaded state in place until the server confirms the
+// player is back in its game
+func (s *session) restore(gs *gamelogic.GameState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gs = gs
+}
+
+// subscribe binds the player's queues for game's deltas and pauses. It
+// happens before asking to join, so the server's sync delta isn't
+// published before anyone is listening.
+func (s *session) subscribe(game string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.subscribed[game] {
+		return nil
+	}
+
+	deltaQueue, err := routing.DeltaKey(game, s.username)
+	if err != nil {
+		return err
+	}
+	deltaPattern, err := routing.GameDeltasPattern(game)
+	if err != nil {
+		return err
+	}
+	err = routing.SubscribeTo(
+		s.transport,
+		deltaQueue,
+		deltaPattern,
+		pubsub.Transient,
+		handlerDelta(s, game),
+		s.subOpts...,
+	)
+	if err != nil {
+		return fmt.Errorf("Failed to subscribe to %s queue: %v", deltaPattern, err)
+	}
+
+	pauseQueue, err := routing.PauseQueue(game, s.username)
+	if err != nil {
+		return err
+	}
+	pauseKey, err := routing.GamePauseKey(game)
+	if err != nil {
+		return err
+	}
+	err = routing.SubscribeTo(
+		s.transport,
+		pauseQueue,
+		pauseKey,
+		pubsub.Transient,
+		handlerPause(s, game),
+		s.subOpts...,
+	)
+	if err != nil {
+		return fmt.Errorf("Failed to subscribe to %s queue: %v", pauseKey, err)
+	}
+
+	s.subscribed[game] = true
+	return nil
+}
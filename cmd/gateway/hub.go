@@ -0,0 +1,238 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing/topic"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// messages queued for a connection before it counts as slow
+	sendBufferSize = 64
+	// a slow connection is dropped after missing this many messages in a row
+	maxDropped = 256
+
+	writeWait  = 10 * time.Second
+	pongWait   = 60 * time.Second
+	pingPeriod = pongWait * 9 / 10
+)
+
+// Event is what spectators receive for every message seen on the broker
+type Event struct {
+	Type string `json:"type"`
+	Key  string `json:"key"`
+	Data any    `json:"data"`
+}
+
+// Authenticator decides whether a WebSocket request may connect, and
+// returns a name for the spectator if so
+type Authenticator func(r *http.Request) (string, error)
+
+var errUnauthorized = errors.New("unauthorized")
+
+// tokenAuthenticator accepts requests carrying token either as a bearer
+// token or in the token query parameter. An empty token lets everyone in.
+func tokenAuthenticator(token string) Authenticator {
+	return func(r *http.Request) (string, error) {
+		if token == "" {
+			return r.RemoteAddr, nil
+		}
+		got := r.URL.Query().Get("token")
+		if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			got = bearer
+		}
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			return "", errUnauthorized
+		}
+		return r.RemoteAddr, nil
+	}
+}
+
+type spectator struct {
+	name    string
+	conn    *websocket.Conn
+	send    chan []byte
+	filters []string
+	dropped int
+}
+
+// hub fans events out to every connected spectator whose filters match
+type hub struct {
+	auth       Authenticator
+	upgrader   websocket.Upgrader
+	mu         *sync.Mutex
+	spectators map[*spectator]struct{}
+	filters    *topic.Trie[*spectator]
+}
+
+// checkOrigin lets browsers connect from pages on the gateway's own host
+// or one of allowed, so other sites can't open streams from their
+// visitors' browsers. "*" allows every origin. Requests without an Origin
+// don't come from a browser page and still need the token.
+func checkOrigin(allowed []string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		for _, a := range allowed {
+			if a == "*" || strings.EqualFold(a, origin) {
+				return true
+			}
+		}
+		u, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(u.Host, r.Host)
+	}
+}
+
+// newHub accepts spectators auth lets in, from browser pages on the
+// origins checkOrigin allows
+func newHub(auth Authenticator, origins []string) *hub {
+	return &hub{
+		auth: auth,
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  1024,
+			WriteBufferSize: 1024,
+			CheckOrigin:     checkOrigin(origins),
+		},
+		mu:         &sync.Mutex{},
+		spectators: map[*spectator]struct{}{},
+		filters:    topic.NewTrie[*spectator](),
+	}
+}
+
+func (h *hub) broadcast(ev Event) {
+	msg, err := json.Marshal(ev)
+	if err != nil {
+		log.Printf("could not encode %s event: %v", ev.Type, err)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, s := range h.filters.Match(ev.Key) {
+		select {
+		case s.send <- msg:
+			s.dropped = 0
+		default:
+			// don't let a slow browser hold up everyone else
+			s.dropped++
+			if s.dropped >= maxDropped {
+				log.Printf("disconnecting slow spectator %s", s.name)
+				h.remove(s)
+			}
+		}
+	}
+}
+
+// ServeHTTP upgrades the request to a WebSocket. The topics query
+// parameter takes comma separated routing key patterns like deltas.*.*
+func (h *hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name, err := h.auth(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	filters := []string{"#"}
+	if topics := r.URL.Query().Get("topics"); topics != "" {
+		filters = strings.Split(topics, ",")
+	}
+
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	s := &spectator{
+		name:    name,
+		conn:    conn,
+		send:    make(chan []byte, sendBufferSize),
+		filters: filters,
+	}
+	h.mu.Lock()
+	h.spectators[s] = struct{}{}
+	for _, filter := range filters {
+		h.filters.Add(filter, s)
+	}
+	h.mu.Unlock()
+	fmt.Printf("Spectator %s connected, watching %s\n", name, strings.Join(filters, ", "))
+
+	go h.writeLoop(s)
+	h.readLoop(s)
+}
+
+// remove must be called with h.mu held
+func (h *hub) remove(s *spectator) {
+	if _, ok := h.spectators[s]; !ok {
+		return
+	}
+	delete(h.spectators, s)
+	for _, filter := range s.filters {
+		h.filters.Remove(filter, s)
+	}
+	close(s.send)
+}
+
+// readLoop discards anything the browser sends, and notices when it leaves
+func (h *hub) readLoop(s *spectator) {
+	defer func() {
+		h.mu.Lock()
+		h.remove(s)
+		h.mu.Unlock()
+		s.conn.Close()
+		fmt.Printf("Spectator %s disconnected\n", s.name)
+	}()
+
+	s.conn.SetReadLimit(512)
+	s.conn.SetReadDeadline(time.Now().Add(pongWait))
+	s.conn.SetPongHandler(func(string) error {
+		return s.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+	for {
+		_, _, err := s.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (h *hub) writeLoop(s *spectator) {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		s.conn.Close()
+	}()
+
+	for {
+		select {
+		case msg, ok := <-s.send:
+			s.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				s.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			err := s.conn.WriteMessage(websocket.TextMessage, msg)
+			if err != nil {
+				return
+			}
+
+		case <-ticker.C:
+			s.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			err := s.conn.WriteMessage(websocket.PingMessage, nil)
+			if err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTokenAuthenticator(t *testing.T) {
+	tests := []struct {
+		name   string
+		token  string
+		target string
+		header string
+		want   bool
+	}{
+		{"bearer token", "secret", "/", "Bearer secret", true},
+		{"query token", "secret", "/?token=secret", "", true},
+		{"no token", "secret", "/", "", false},
+		{"wrong bearer token", "secret", "/", "Bearer wrong", false},
+		{"wrong query token", "secret", "/?token=wrong", "", false},
+		{"token prefix", "secret", "/?token=sec", "", false},
+		{"bearer token wins", "secret", "/?token=secret", "Bearer wrong", false},
+		{"open gateway", "", "/", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			_, err := tokenAuthenticator(tt.token)(r)
+			if (err == nil) != tt.want {
+				t.Errorf("authenticated = %v, want %v", err == nil, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckOrigin(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		origin  string
+		want    bool
+	}{
+		{"no origin", nil, "", true},
+		{"same host", nil, "http://gateway.example:8080", true},
+		{"other site", nil, "https://evil.example", false},
+		{"other port", nil, "http://gateway.example:9090", false},
+		{"allowed", []string{"https://peril.example"}, "https://peril.example", true},
+		{"allowed in another case", []string{"https://peril.example"}, "https://PERIL.example", true},
+		{"not allowed", []string{"https://peril.example"}, "https://evil.example", false},
+		{"other scheme", []string{"https://peril.example"}, "http://peril.example", false},
+		{"any", []string{"*"}, "https://evil.example", true},
+		{"not a URL", nil, "%zz", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://gateway.example:8080/", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if got := checkOrigin(tt.allowed)(r); got != tt.want {
+				t.Errorf("checkOrigin(%v) with origin %q = %v, want %v", tt.allowed, tt.origin, got, tt.want)
+			}
+		})
+	}
+}
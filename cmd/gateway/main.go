@@ -0,0 +1,185 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// handlerDeltaEvent streams what the server did to a player's units, so
+// spectators only see moves and spawns the server accepted
+func handlerDeltaEvent(h *hub) func(gamelogic.StateDelta) pubsub.Acktype {
+	return func(delta gamelogic.StateDelta) pubsub.Acktype {
+		key, err := routing.DeltaKey(delta.Game, delta.Username)
+		if err != nil {
+			return pubsub.NackDiscard
+		}
+		h.broadcast(Event{
+			Type: "delta",
+			Key:  key,
+			Data: delta,
+		})
+		return pubsub.Ack
+	}
+}
+
+func handlerWarEvent(h *hub) func(gamelogic.RecognitionOfWar) pubsub.Acktype {
+	return func(rw gamelogic.RecognitionOfWar) pubsub.Acktype {
+		key, err := routing.WarKey(rw.Game, rw.Defender.Username)
+		if err != nil {
+			return pubsub.NackDiscard
+		}
+		h.broadcast(Event{
+			Type: "war",
+			Key:  key,
+			Data: rw,
+		})
+		return pubsub.Ack
+	}
+}
+
+func handlerLogEvent(h *hub) func(routing.GameLog) pubsub.Acktype {
+	return func(gamelog routing.GameLog) pubsub.Acktype {
+		key, err := routing.GameLogKey(gamelog.Game, gamelog.Username)
+		if err != nil {
+			return pubsub.NackDiscard
+		}
+		h.broadcast(Event{
+			Type: "game_log",
+			Key:  key,
+			Data: gamelog,
+		})
+		return pubsub.Ack
+	}
+}
+
+func handlerPauseEvent(h *hub) func(routing.PlayingState) pubsub.Acktype {
+	return func(ps routing.PlayingState) pubsub.Acktype {
+		key, err := routing.GamePauseKey(ps.Game)
+		if err != nil {
+			return pubsub.NackDiscard
+		}
+		h.broadcast(Event{
+			Type: "pause",
+			Key:  key,
+			Data: ps,
+		})
+		return pubsub.Ack
+	}
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// loadVerification reads the server's public key from PERIL_AUTHORITY, so
+// only messages the server or a certified player signed are streamed. If
+// it's unset, messages are streamed unverified.
+func loadVerification() ([]pubsub.SubscribeOption, error) {
+	authorityHex := os.Getenv("PERIL_AUTHORITY")
+	if authorityHex == "" {
+		return nil, nil
+	}
+	authority, err := hex.DecodeString(authorityHex)
+	if err != nil || len(authority) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("PERIL_AUTHORITY must be %d hex encoded bytes", ed25519.PublicKeySize)
+	}
+	return []pubsub.SubscribeOption{pubsub.WithVerification(ed25519.PublicKey(authority))}, nil
+}
+
+func main() {
+	addr := flag.String("addr", ":8080", "address to serve WebSockets on")
+	origins := flag.String("origins", "", "comma separated origins browser pages may connect from besides the gateway's own, or * for any")
+	flag.Parse()
+
+	transport, err := pubsub.Dial(envOr("PERIL_BROKER_URL", pubsub.DefaultBrokerURL))
+	if err != nil {
+		log.Fatalf("Could not connect to broker: %v", err)
+	} else {
+		fmt.Println("Connection to broker successful")
+	}
+	defer transport.Close()
+
+	subOpts, err := loadVerification()
+	if err != nil {
+		log.Fatalf("Failed to load authority key: %v", err)
+	}
+
+	allowed := []string{}
+	for _, origin := range strings.Split(*origins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			allowed = append(allowed, origin)
+		}
+	}
+	h := newHub(tokenAuthenticator(os.Getenv("PERIL_GATEWAY_TOKEN")), allowed)
+
+	// every gateway gets its own transient queues, so it sees every message
+	// without taking any away from the game
+	queuePrefix := fmt.Sprintf("gateway_%d", os.Getpid())
+
+	// deltas, wars and pauses only ever come from the server
+	serverOpts := subOpts
+	if len(subOpts) > 0 {
+		serverOpts = append(serverOpts, pubsub.WithSignedBy(routing.ServerUsername))
+	}
+
+	err = routing.SubscribeAs(
+		transport,
+		fmt.Sprintf("%s.%s", queuePrefix, routing.DeltasPrefix),
+		pubsub.Transient,
+		handlerDeltaEvent(h),
+		serverOpts...,
+	)
+	if err != nil {
+		log.Fatalf("Failed to subscribe to deltas.* queue: %v", err)
+	}
+
+	err = routing.SubscribeAs(
+		transport,
+		fmt.Sprintf("%s.%s", queuePrefix, routing.WarRecognitionsPrefix),
+		pubsub.Transient,
+		handlerWarEvent(h),
+		serverOpts...,
+	)
+	if err != nil {
+		log.Fatalf("Failed to subscribe to war.* queue: %v", err)
+	}
+
+	err = routing.SubscribeAs(
+		transport,
+		fmt.Sprintf("%s.%s", queuePrefix, routing.GameLogSlug),
+		pubsub.Transient,
+		handlerLogEvent(h),
+		subOpts...,
+	)
+	if err != nil {
+		log.Fatalf("Failed to subscribe to game_logs.* queue: %v", err)
+	}
+
+	err = routing.SubscribeAs(
+		transport,
+		fmt.Sprintf("%s.%s", queuePrefix, routing.PauseKey),
+		pubsub.Transient,
+		handlerPauseEvent(h),
+		serverOpts...,
+	)
+	if err != nil {
+		log.Fatalf("Failed to subscribe to pause queue: %v", err)
+	}
+
+	http.Handle("/ws", h)
+	fmt.Printf("Serving spectators on %s/ws\n", *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
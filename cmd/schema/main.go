@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+
+	// registers the move and war message types
+	_ "github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing/schema"
+)
+
+// schema prints the JSON Schema of every message type in the routing
+// catalog, so other teams know what to expect on peril_direct and
+// peril_topic
+func main() {
+	typeName := flag.String("type", "", "only emit the schema of this message type, e.g. ArmyMove")
+	out := flag.String("out", "", "write one <Type>.json file per message type into this directory instead of printing")
+	flag.Parse()
+
+	schemas := map[string]*schema.Schema{}
+	for _, r := range routing.Routes() {
+		if *typeName != "" && r.Type.Name() != *typeName {
+			continue
+		}
+		s, err := r.Schema()
+		if err != nil {
+			log.Fatalf("Failed to generate schema for %s: %v", r.Type, err)
+		}
+		schemas[r.Type.Name()] = s
+	}
+	if len(schemas) == 0 {
+		log.Fatalf("No message type is called %s", *typeName)
+	}
+
+	if *out == "" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		var err error
+		if *typeName != "" {
+			err = enc.Encode(schemas[*typeName])
+		} else {
+			err = enc.Encode(schemas)
+		}
+		if err != nil {
+			log.Fatalf("Failed to write schemas: %v", err)
+		}
+		return
+	}
+
+	err := os.MkdirAll(*out, 0o755)
+	if err != nil {
+		log.Fatalf("Failed to create %s: %v", *out, err)
+	}
+	names := make([]string, 0, len(schemas))
+	for name := range schemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		b, err := json.MarshalIndent(schemas[name], "", "  ")
+		if err != nil {
+			log.Fatalf("Failed to encode schema for %s: %v", name, err)
+		}
+		path := filepath.Join(*out, name+".json")
+		err = os.WriteFile(path, append(b, '\n'), 0o644)
+		if err != nil {
+			log.Fatalf("Failed to write %s: %v", path, err)
+		}
+		fmt.Println(path)
+	}
+}
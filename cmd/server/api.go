@@ -0,0 +1,115 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// defaultLogLines is how many log lines GET /logs returns without ?n=
+const defaultLogLines = 100
+
+// newAPI serves the admin HTTP API. Every request needs the admin token as
+// a bearer token.
+func newAPI(s *server, token string) http.Handler {
+	mux := http.NewServeMux()
+	// ?game= pauses or resumes one game instead of every game
+	mux.HandleFunc("POST /pause", func(w http.ResponseWriter, r *http.Request) {
+		setPaused(w, r, s, true)
+	})
+
+	mux.HandleFunc("POST /resume", func(w http.ResponseWriter, r *http.Request) {
+		setPaused(w, r, s, false)
+	})
+
+	mux.HandleFunc("GET /games", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.games())
+	})
+
+	mux.HandleFunc("POST /broadcast", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Message string `json:"message"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil || strings.TrimSpace(req.Message) == "" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "message is required"})
+			return
+		}
+
+		err = s.broadcast(req.Message)
+		if err != nil {
+			writeError(w, http.StatusBadGateway, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.status())
+	})
+
+	mux.HandleFunc("GET /players", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.players())
+	})
+
+	mux.HandleFunc("GET /logs", func(w http.ResponseWriter, r *http.Request) {
+		n := defaultLogLines
+		if nParam := r.URL.Query().Get("n"); nParam != "" {
+			var err error
+			n, err = strconv.Atoi(nParam)
+			if err != nil || n < 0 {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "n must be a non-negative integer"})
+				return
+			}
+		}
+
+		lines, err := s.logs(n)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, lines)
+	})
+
+	return requireToken(token, mux)
+}
+
+func setPaused(w http.ResponseWriter, r *http.Request, s *server, paused bool) {
+	game := r.URL.Query().Get("game")
+	if game != "" {
+		if _, ok := s.lobby.World(game); !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "no such game"})
+			return
+		}
+	}
+
+	err := s.setPaused(game, paused)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.status())
+}
+
+func requireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
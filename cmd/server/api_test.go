@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// callAPI sends an authorized request to the admin API
+func callAPI(t *testing.T, s *server, method, target, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	rec := httptest.NewRecorder()
+	newAPI(s, testToken).ServeHTTP(rec, req)
+	return rec
+}
+
+// chdirTemp runs the rest of the test in a temporary directory, for the
+// files the server reads and writes relative to where it's run
+func chdirTemp(t *testing.T) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestAPIRequiresToken(t *testing.T) {
+	s, pub := newTestServer(t)
+
+	tests := []struct {
+		name   string
+		header string
+	}{
+		{"no header", ""},
+		{"wrong token", "Bearer wrong"},
+		{"not a bearer token", "Basic " + testToken},
+		{"token prefix", "Bearer " + testToken[:3]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/pause", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			newAPI(s, testToken).ServeHTTP(rec, req)
+			if rec.Code != http.StatusUnauthorized {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusUnauthorized)
+			}
+		})
+	}
+	if keys := pub.keys(); len(keys) != 0 {
+		t.Errorf("published %v without the token", keys)
+	}
+}
+
+func TestAPIPause(t *testing.T) {
+	s, pub := newTestServer(t)
+	for _, game := range []string{"africa", "europe"} {
+		if err := s.lobby.Create(game); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rec := callAPI(t, s, http.MethodPost, "/pause?game=europe", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST /pause?game=europe status = %d: %s", rec.Code, rec.Body)
+	}
+	var st Status
+	if err := json.NewDecoder(rec.Body).Decode(&st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Paused || st.Games != 2 {
+		t.Errorf("status = %+v, want 2 games not all paused", st)
+	}
+	wantKey, _ := routing.GamePauseKey("europe")
+	if got := pub.keys(); !reflect.DeepEqual(got, []string{wantKey}) {
+		t.Errorf("published %v, want %v", got, []string{wantKey})
+	}
+
+	rec = callAPI(t, s, http.MethodPost, "/pause", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST /pause status = %d: %s", rec.Code, rec.Body)
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&st); err != nil {
+		t.Fatal(err)
+	}
+	if !st.Paused {
+		t.Error("status doesn't say every game is paused after pausing them all")
+	}
+
+	rec = callAPI(t, s, http.MethodPost, "/resume?game=africa", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST /resume?game=africa status = %d: %s", rec.Code, rec.Body)
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Paused {
+		t.Error("status says every game is paused after resuming one")
+	}
+
+	rec = callAPI(t, s, http.MethodGet, "/games", "")
+	var games []gamelogic.GameInfo
+	if err := json.NewDecoder(rec.Body).Decode(&games); err != nil {
+		t.Fatal(err)
+	}
+	paused := map[string]bool{}
+	for _, g := range games {
+		paused[g.ID] = g.Paused
+	}
+	if !reflect.DeepEqual(paused, map[string]bool{"africa": false, "europe": true}) {
+		t.Errorf("games paused = %v", paused)
+	}
+}
+
+func TestAPIPauseUnknownGame(t *testing.T) {
+	s, pub := newTestServer(t)
+	for _, target := range []string{"/pause?game=atlantis", "/resume?game=atlantis"} {
+		rec := callAPI(t, s, http.MethodPost, target, "")
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("POST %s status = %d, want %d", target, rec.Code, http.StatusNotFound)
+		}
+	}
+	if keys := pub.keys(); len(keys) != 0 {
+		t.Errorf("published %v for an unknown game", keys)
+	}
+}
+
+func TestAPIBroadcast(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"message", `{"message":"server restarting"}`, http.StatusNoContent},
+		{"blank message", `{"message":"  "}`, http.StatusBadRequest},
+		{"no message", `{}`, http.StatusBadRequest},
+		{"not JSON", `server restarting`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, pub := newTestServer(t)
+			rec := callAPI(t, s, http.MethodPost, "/broadcast", tt.body)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+
+			keys := pub.keys()
+			if tt.want != http.StatusNoContent {
+				if len(keys) != 0 {
+					t.Errorf("published %v for a bad request", keys)
+				}
+				return
+			}
+			if !reflect.DeepEqual(keys, []string{routing.BroadcastKey}) {
+				t.Errorf("published %v, want %v", keys, []string{routing.BroadcastKey})
+			}
+		})
+	}
+}
+
+func TestAPIStatusAndPlayers(t *testing.T) {
+	s, _ := newTestGame(t)
+	s.seen("bob")
+
+	rec := callAPI(t, s, http.MethodGet, "/status", "")
+	var st Status
+	if err := json.NewDecoder(rec.Body).Decode(&st); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || st.Players != 1 || st.Online != 1 || st.Games != 1 {
+		t.Errorf("GET /status = %d %+v, want one player online in one game", rec.Code, st)
+	}
+
+	rec = callAPI(t, s, http.MethodGet, "/players", "")
+	var players []PlayerInfo
+	if err := json.NewDecoder(rec.Body).Decode(&players); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || len(players) != 1 || players[0].Username != "bob" || players[0].Game != "europe" {
+		t.Errorf("GET /players = %d %+v, want bob in europe", rec.Code, players)
+	}
+}
+
+func TestAPILogs(t *testing.T) {
+	chdirTemp(t)
+	err := os.WriteFile("game.log", []byte("one\ntwo\nthree\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := newTestServer(t)
+
+	tests := []struct {
+		target string
+		status int
+		want   []string
+	}{
+		{"/logs", http.StatusOK, []string{"one", "two", "three"}},
+		{"/logs?n=2", http.StatusOK, []string{"two", "three"}},
+		{"/logs?n=0", http.StatusOK, []string{}},
+		{"/logs?n=-1", http.StatusBadRequest, nil},
+		{"/logs?n=lots", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		rec := callAPI(t, s, http.MethodGet, tt.target, "")
+		if rec.Code != tt.status {
+			t.Errorf("GET %s status = %d, want %d", tt.target, rec.Code, tt.status)
+			continue
+		}
+		if tt.want == nil {
+			continue
+		}
+		var lines []string
+		if err := json.NewDecoder(rec.Body).Decode(&lines); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(lines, tt.want) {
+			t.Errorf("GET %s = %v, want %v", tt.target, lines, tt.want)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"strings"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/controlpb"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// controlServer implements the gRPC control plane on top of the same server
+// the REPL and admin API use
+type controlServer struct {
+	controlpb.UnimplementedGameControlServer
+	s *server
+}
+
+func newGRPCServer(s *server, token string) *grpc.Server {
+	gs := grpc.NewServer(
+		grpc.UnaryInterceptor(func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			err := checkToken(ctx, token)
+			if err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			err := checkToken(ss.Context(), token)
+			if err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		}),
+	)
+	controlpb.RegisterGameControlServer(gs, &controlServer{s: s})
+	return gs
+}
+
+func checkToken(ctx context.Context, token string) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get("authorization") {
+		got, ok := strings.CutPrefix(v, "Bearer ")
+		if ok && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "unauthorized")
+}
+
+// Pause and Resume apply to every game unless the request names one
+func (c *controlServer) Pause(ctx context.Context, req *controlpb.PauseRequest) (*controlpb.Status, error) {
+	return c.setPaused(req.GetGame(), true)
+}
+
+func (c *controlServer) Resume(ctx context.Context, req *controlpb.ResumeRequest) (*controlpb.Status, error) {
+	return c.setPaused(req.GetGame(), false)
+}
+
+func (c *controlServer) setPaused(game string, paused bool) (*controlpb.Status, error) {
+	if game != "" {
+		if _, ok := c.s.lobby.World(game); !ok {
+			return nil, status.Errorf(codes.NotFound, "no such game %s", game)
+		}
+	}
+
+	err := c.s.setPaused(game, paused)
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "could not change pause: %v", err)
+	}
+	return statusToProto(c.s.status()), nil
+}
+
+func (c *controlServer) ListPlayers(ctx context.Context, _ *controlpb.ListPlayersRequest) (*controlpb.ListPlayersResponse, error) {
+	resp := &controlpb.ListPlayersResponse{}
+	for _, p := range c.s.players() {
+		resp.Players = append(resp.Players, &controlpb.Player{
+			Username: p.Username,
+			LastSeen: timestamppb.New(p.LastSeen),
+			Online:   p.Online,
+			Game:     p.Game,
+		})
+	}
+	return resp, nil
+}
+
+func (c *controlServer) ListGames(ctx context.Context, _ *controlpb.ListGamesRequest) (*controlpb.ListGamesResponse, error) {
+	resp := &controlpb.ListGamesResponse{}
+	for _, g := range c.s.games() {
+		resp.Games = append(resp.Games, &controlpb.Game{
+			Id:      g.ID,
+			Players: g.Players,
+			Paused:  g.Paused,
+		})
+	}
+	return resp, nil
+}
+
+func (c *controlServer) StreamEvents(_ *controlpb.StreamEventsRequest, stream grpc.ServerStreamingServer[controlpb.Event]) error {
+	events, stop := c.s.watch()
+	defer stop()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case ev := <-events:
+			err := stream.Send(eventToProto(ev))
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func statusToProto(st Status) *controlpb.Status {
+	return &controlpb.Status{
+		Paused:  st.Paused,
+		Started: timestamppb.New(st.Started),
+		Players: int32(st.Players),
+		Online:  int32(st.Online),
+		Games:   int32(st.Games),
+	}
+}
+
+func eventToProto(ev Event) *controlpb.Event {
+	switch {
+	case ev.Move != nil:
+		return &controlpb.Event{Event: &controlpb.Event_Move{Move: &controlpb.Move{
+			Player:     ev.Move.Player.Username,
+			ToLocation: string(ev.Move.ToLocation),
+			Units:      unitsToProto(ev.Move.Units),
+			Game:       ev.Move.Game,
+		}}}
+
+	case ev.War != nil:
+		return &controlpb.Event{Event: &controlpb.Event_War{War: &controlpb.War{
+			Attacker: ev.War.Attacker.Username,
+			Defender: ev.War.Defender.Username,
+			Game:     ev.War.Game,
+		}}}
+
+	case ev.Log != nil:
+		return &controlpb.Event{Event: &controlpb.Event_Log{Log: &controlpb.GameLog{
+			Time:     timestamppb.New(ev.Log.CurrentTime),
+			Username: ev.Log.Username,
+			Message:  ev.Log.Message,
+			Game:     ev.Log.Game,
+		}}}
+	}
+	return &controlpb.Event{}
+}
+
+func unitsToProto(units []gamelogic.Unit) []*controlpb.Unit {
+	out := make([]*controlpb.Unit, 0, len(units))
+	for _, u := range units {
+		out = append(out, &controlpb.Unit{
+			Id:       int32(u.ID),
+			Rank:     string(u.Rank),
+			Location: string(u.Location),
+		})
+	}
+	return out
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/controlpb"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const testToken = "secret"
+
+// dialControl serves the control plane for s over an in-memory connection
+func dialControl(t *testing.T, s *server) controlpb.GameControlClient {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	gs := newGRPCServer(s, testToken)
+	go gs.Serve(lis)
+	t.Cleanup(gs.Stop)
+
+	conn, err := grpc.NewClient(
+		"passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("could not dial control plane: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return controlpb.NewGameControlClient(conn)
+}
+
+func authorized(token string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+}
+
+func TestGRPCRequiresToken(t *testing.T) {
+	s, _ := newTestServer(t)
+	client := dialControl(t, s)
+
+	for _, ctx := range []context.Context{context.Background(), authorized("wrong")} {
+		_, err := client.ListPlayers(ctx, &controlpb.ListPlayersRequest{})
+		if status.Code(err) != codes.Unauthenticated {
+			t.Errorf("ListPlayers error = %v, want Unauthenticated", err)
+		}
+	}
+}
+
+func TestGRPCPauseOneGame(t *testing.T) {
+	s, pub := newTestServer(t)
+	client := dialControl(t, s)
+	for _, game := range []string{"africa", "europe"} {
+		if err := s.lobby.Create(game); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	st, err := client.Pause(authorized(testToken), &controlpb.PauseRequest{Game: "europe"})
+	if err != nil {
+		t.Fatalf("Pause: %v", err)
+	}
+	if st.Paused {
+		t.Error("status says every game is paused after pausing one")
+	}
+	if st.Games != 2 {
+		t.Errorf("status has %d games, want 2", st.Games)
+	}
+	wantKey, _ := routing.GamePauseKey("europe")
+	if got := pub.keys(); !reflect.DeepEqual(got, []string{wantKey}) {
+		t.Errorf("published %v, want %v", got, []string{wantKey})
+	}
+
+	games, err := client.ListGames(authorized(testToken), &controlpb.ListGamesRequest{})
+	if err != nil {
+		t.Fatalf("ListGames: %v", err)
+	}
+	paused := map[string]bool{}
+	for _, g := range games.Games {
+		paused[g.Id] = g.Paused
+	}
+	if !reflect.DeepEqual(paused, map[string]bool{"africa": false, "europe": true}) {
+		t.Errorf("games paused = %v", paused)
+	}
+
+	st, err = client.Pause(authorized(testToken), &controlpb.PauseRequest{})
+	if err != nil {
+		t.Fatalf("Pause every game: %v", err)
+	}
+	if !st.Paused {
+		t.Error("status doesn't say every game is paused after pausing them all")
+	}
+
+	st, err = client.Resume(authorized(testToken), &controlpb.ResumeRequest{Game: "africa"})
+	if err != nil {
+		t.Fatalf("Resume: %v", err)
+	}
+	if st.Paused {
+		t.Error("status says every game is paused after resuming one")
+	}
+}
+
+func TestGRPCPauseUnknownGame(t *testing.T) {
+	s, pub := newTestServer(t)
+	client := dialControl(t, s)
+
+	_, err := client.Pause(authorized(testToken), &controlpb.PauseRequest{Game: "atlantis"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Pause error = %v, want NotFound", err)
+	}
+	_, err = client.Resume(authorized(testToken), &controlpb.ResumeRequest{Game: "atlantis"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Resume error = %v, want NotFound", err)
+	}
+	if keys := pub.keys(); len(keys) != 0 {
+		t.Errorf("published %v for an unknown game", keys)
+	}
+}
+
+func TestGRPCListPlayers(t *testing.T) {
+	s, _ := newTestServer(t)
+	client := dialControl(t, s)
+	if err := s.lobby.Create("europe"); err != nil {
+		t.Fatal(err)
+	}
+	s.seen("alice")
+	if err := s.lobby.Join("europe", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	s.seen("bob")
+	s.left("bob")
+
+	resp, err := client.ListPlayers(authorized(testToken), &controlpb.ListPlayersRequest{})
+	if err != nil {
+		t.Fatalf("ListPlayers: %v", err)
+	}
+	type player struct {
+		online bool
+		game   string
+	}
+	got := map[string]player{}
+	for _, p := range resp.Players {
+		got[p.Username] = player{p.Online, p.Game}
+	}
+	want := map[string]player{
+		"alice": {true, "europe"},
+		"bob":   {false, ""},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("players = %+v, want %+v", got, want)
+	}
+
+	st, err := client.Pause(authorized(testToken), &controlpb.PauseRequest{Game: "europe"})
+	if err != nil {
+		t.Fatalf("Pause: %v", err)
+	}
+	if st.Players != 2 || st.Online != 1 {
+		t.Errorf("status has %d players, %d online, want 2 and 1", st.Players, st.Online)
+	}
+}
+
+func TestGRPCStreamEvents(t *testing.T) {
+	s, _ := newTestServer(t)
+	client := dialControl(t, s)
+
+	ctx, cancel := context.WithCancel(authorized(testToken))
+	defer cancel()
+	stream, err := client.StreamEvents(ctx, &controlpb.StreamEventsRequest{})
+	if err != nil {
+		t.Fatalf("StreamEvents: %v", err)
+	}
+
+	// the stream only sees events emitted after it starts watching, so keep
+	// emitting until one arrives
+	gamelog := routing.GameLog{CurrentTime: time.Now(), Username: "alice", Message: "hello", Game: "europe"}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(10 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				s.emit(Event{Log: &gamelog})
+			}
+		}
+	}()
+
+	ev, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("Recv: %v", err)
+	}
+	got := ev.GetLog()
+	if got == nil || got.Username != "alice" || got.Message != "hello" || got.Game != "europe" {
+		t.Errorf("streamed %v, want alice's log in europe", ev)
+	}
+}
+
+func TestGRPCMoveEventsComeFromWorld(t *testing.T) {
+	s, _ := newTestGame(t)
+	_, err := s.lobby.Spawn(gamelogic.SpawnIntent{Game: "europe", Username: "bob", Location: "americas", Rank: gamelogic.RankInfantry})
+	if err != nil {
+		t.Fatalf("Spawn: %v", err)
+	}
+	events, unwatch := s.watch()
+	defer unwatch()
+
+	// bob claims the unit is somewhere else and that there are others
+	move := gamelogic.ArmyMove{
+		Game: "europe",
+		Player: gamelogic.Player{Username: "bob", Units: map[int]gamelogic.Unit{
+			9: {ID: 9, Rank: gamelogic.RankArtillery, Location: "asia"},
+		}},
+		Units:      []gamelogic.Unit{{ID: 1, Rank: gamelogic.RankInfantry, Location: "asia"}},
+		ToLocation: "europe",
+	}
+	got := handlerMove(s)(routing.Keyed[gamelogic.ArmyMove]{Key: "army_moves.europe.bob", Val: move})
+	if got != pubsub.Ack {
+		t.Fatalf("handlerMove = %v, want Ack", got)
+	}
+
+	var ev Event
+	select {
+	case ev = <-events:
+	case <-time.After(time.Second):
+		t.Fatal("the move was never emitted")
+	}
+	if ev.Move == nil {
+		t.Fatalf("emitted %+v, want a move", ev)
+	}
+	if _, ok := ev.Move.Player.Units[1]; !ok || len(ev.Move.Player.Units) != 1 {
+		t.Errorf("emitted bob with units %+v, want unit 1 only", ev.Move.Player.Units)
+	}
+	m := eventToProto(ev).GetMove()
+	if m == nil || m.Player != "bob" || m.ToLocation != "europe" || m.Game != "europe" {
+		t.Fatalf("streamed %v, want bob's move to europe", m)
+	}
+	if len(m.Units) != 1 || m.Units[0].Id != 1 || m.Units[0].Rank != string(gamelogic.RankInfantry) || m.Units[0].Location != "europe" {
+		t.Errorf("streamed units %v, want infantry 1 in europe", m.Units)
+	}
+}
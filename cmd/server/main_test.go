@@ -0,0 +1,165 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// newTestGame starts a server hosting europe with bob playing it
+func newTestGame(t *testing.T) (*server, *recordingPublisher) {
+	t.Helper()
+	s, pub := newTestServer(t)
+	reply := s.lobby.Handle(gamelogic.LobbyRequest{Username: "bob", Action: gamelogic.LobbyCreate, Game: "europe"})
+	if reply.Error != "" {
+		t.Fatalf("could not create game: %s", reply.Error)
+	}
+	return s, pub
+}
+
+func TestHandlerSpawnChecksRoutingKey(t *testing.T) {
+	intent := gamelogic.SpawnIntent{
+		Game:     "europe",
+		Username: "bob",
+		Location: "americas",
+		Rank:     gamelogic.RankInfantry,
+	}
+	tests := []struct {
+		name     string
+		key      string
+		want     pubsub.Acktype
+		wantKeys []string
+	}{
+		{"matching key", "spawns.europe.bob", pubsub.Ack, []string{"deltas.europe.bob"}},
+		{"another game", "spawns.asia.bob", pubsub.NackDiscard, []string{}},
+		{"another player", "spawns.europe.alice", pubsub.NackDiscard, []string{}},
+		{"another prefix", "army_moves.europe.bob", pubsub.NackDiscard, []string{}},
+		{"no game", "spawns.bob", pubsub.NackDiscard, []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, pub := newTestGame(t)
+			got := handlerSpawn(s)(routing.Keyed[gamelogic.SpawnIntent]{Key: tt.key, Val: intent})
+			if got != tt.want {
+				t.Errorf("handlerSpawn = %v, want %v", got, tt.want)
+			}
+			if keys := pub.keys(); !reflect.DeepEqual(keys, tt.wantKeys) {
+				t.Errorf("published %v, want %v", keys, tt.wantKeys)
+			}
+		})
+	}
+}
+
+func TestHandlerMoveChecksRoutingKey(t *testing.T) {
+	move := gamelogic.ArmyMove{
+		Game:       "europe",
+		Player:     gamelogic.Player{Username: "alice"},
+		ToLocation: "americas",
+	}
+	tests := []struct {
+		name     string
+		key      string
+		wantKeys []string
+	}{
+		// alice isn't playing europe, so the world refuses the move and
+		// tells her why
+		{"matching key", "army_moves.europe.alice", []string{"rejections.europe.alice"}},
+		// a move published to another player's key is nobody's to answer
+		{"another player", "army_moves.europe.bob", []string{}},
+		{"another game", "army_moves.asia.alice", []string{}},
+		{"wildcard", "army_moves.*.alice", []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, pub := newTestGame(t)
+			got := handlerMove(s)(routing.Keyed[gamelogic.ArmyMove]{Key: tt.key, Val: move})
+			if got != pubsub.NackDiscard {
+				t.Errorf("handlerMove = %v, want NackDiscard", got)
+			}
+			if keys := pub.keys(); !reflect.DeepEqual(keys, tt.wantKeys) {
+				t.Errorf("published %v, want %v", keys, tt.wantKeys)
+			}
+		})
+	}
+}
+
+func TestHandlerLobbyChecksRoutingKey(t *testing.T) {
+	req := gamelogic.LobbyRequest{Username: "bob", Action: gamelogic.LobbyList}
+	tests := []struct {
+		name     string
+		key      string
+		want     pubsub.Acktype
+		wantKeys []string
+	}{
+		{"matching key", "lobby.bob", pubsub.Ack, []string{"lobby_replies.bob"}},
+		{"another player", "lobby.alice", pubsub.NackDiscard, []string{}},
+		{"no player", "lobby", pubsub.NackDiscard, []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, pub := newTestServer(t)
+			got := handlerLobby(s)(routing.Keyed[gamelogic.LobbyRequest]{Key: tt.key, Val: req})
+			if got != tt.want {
+				t.Errorf("handlerLobby = %v, want %v", got, tt.want)
+			}
+			if keys := pub.keys(); !reflect.DeepEqual(keys, tt.wantKeys) {
+				t.Errorf("published %v, want %v", keys, tt.wantKeys)
+			}
+		})
+	}
+}
+
+func TestHandlerPresenceChecksRoutingKey(t *testing.T) {
+	p := routing.Presence{Username: "bob", Status: routing.PresenceJoined}
+	tests := []struct {
+		key  string
+		want pubsub.Acktype
+	}{
+		{"presence.bob", pubsub.Ack},
+		{"presence.alice", pubsub.NackDiscard},
+		{"presence.europe.bob", pubsub.NackDiscard},
+	}
+
+	for _, tt := range tests {
+		s, _ := newTestServer(t)
+		got := handlerPresence(s)(routing.Keyed[routing.Presence]{Key: tt.key, Val: p})
+		if got != tt.want {
+			t.Errorf("handlerPresence on %s = %v, want %v", tt.key, got, tt.want)
+		}
+		if online := len(s.players()) == 1; online != (tt.want == pubsub.Ack) {
+			t.Errorf("presence on %s: bob online = %v", tt.key, online)
+		}
+	}
+}
+
+func TestHandlerLogChecksRoutingKey(t *testing.T) {
+	gamelog := routing.GameLog{Game: "europe", Username: "bob", Message: "hello"}
+	for _, key := range []string{"game_logs.asia.bob", "game_logs.europe.alice", "game_logs.bob"} {
+		s, _ := newTestServer(t)
+		got := handlerLog(s)(routing.Keyed[routing.GameLog]{Key: key, Val: gamelog})
+		if got != pubsub.NackDiscard {
+			t.Errorf("handlerLog on %s = %v, want NackDiscard", key, got)
+		}
+		if players := s.players(); len(players) != 0 {
+			t.Errorf("log on %s marked %v online", key, players)
+		}
+	}
+}
+
+// version 1 logs predate games, so their keys are game_logs.username
+func TestHandlerLogChecksV1RoutingKey(t *testing.T) {
+	gamelog := routing.GameLog{Username: "bob", Message: "hello"}
+	for _, key := range []string{"game_logs.alice", "game_logs.europe.bob", "game_logs"} {
+		s, _ := newTestServer(t)
+		got := handlerLog(s)(routing.Keyed[routing.GameLog]{Key: key, Val: gamelog})
+		if got != pubsub.NackDiscard {
+			t.Errorf("handlerLog of a v1 log on %s = %v, want NackDiscard", key, got)
+		}
+	}
+}
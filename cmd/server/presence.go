@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// presence is what the server knows about a player's client
+type presence struct {
+	lastSeen time.Time
+	online   bool
+}
+
+type PlayerInfo struct {
+	Username string    `json:"username"`
+	LastSeen time.Time `json:"last_seen"`
+	Online   bool      `json:"online"`
+	Game     string    `json:"game,omitempty"`
+}
+
+// seen records activity from a player, which means their client is running
+func (s *server) seen(username string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.roster[username]
+	if !ok {
+		p = &presence{}
+		s.roster[username] = p
+	}
+	p.lastSeen = time.Now()
+	p.online = true
+}
+
+// left records a player quitting. They leave their game, but keep their
+// units in it.
+func (s *server) left(username string) {
+	s.mu.Lock()
+	if p, ok := s.roster[username]; ok {
+		p.lastSeen = time.Now()
+		p.online = false
+	}
+	s.mu.Unlock()
+	s.lobby.Leave(username)
+}
+
+// dropped marks everyone not heard from within timeout as offline and
+// returns who they are
+func (s *server) dropped(timeout time.Duration) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dropped := []string{}
+	for username, p := range s.roster {
+		if p.online && time.Since(p.lastSeen) > timeout {
+			p.online = false
+			dropped = append(dropped, username)
+		}
+	}
+	sort.Strings(dropped)
+	return dropped
+}
+
+// drop marks everyone not heard from within timeout as offline and returns
+// a log of each one dropping. They keep their seats in their games, since
+// heartbeats skip the client's publish buffer: a client only cut off from
+// the broker for a while carries on where it was once they resume.
+func (s *server) drop(timeout time.Duration) []routing.GameLog {
+	gamelogs := []routing.GameLog{}
+	for _, username := range s.dropped(timeout) {
+		gamelogs = append(gamelogs, routing.GameLog{
+			CurrentTime: time.Now(),
+			Message:     fmt.Sprintf("%s dropped after %s without a heartbeat", username, timeout),
+			Username:    username,
+			Game:        s.lobby.GameOf(username),
+		})
+	}
+	return gamelogs
+}
+
+// watchPresence logs players whose heartbeats stop. It runs until the
+// server exits.
+func (s *server) watchPresence() {
+	ticker := time.NewTicker(routing.HeartbeatInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		for _, gamelog := range s.drop(routing.PresenceTimeout) {
+			s.emit(Event{Log: &gamelog})
+			err := gamelogic.WriteLog(gamelog)
+			if err != nil {
+				log.Printf("could not log %s dropping: %v", gamelog.Username, err)
+			}
+		}
+	}
+}
+
+// players lists everyone the server has seen activity from, online players
+// first, then most recent first
+func (s *server) players() []PlayerInfo {
+	s.mu.Lock()
+	players := []PlayerInfo{}
+	for username, p := range s.roster {
+		players = append(players, PlayerInfo{Username: username, LastSeen: p.lastSeen, Online: p.online})
+	}
+	s.mu.Unlock()
+
+	for i := range players {
+		players[i].Game = s.lobby.GameOf(players[i].Username)
+	}
+	sort.Slice(players, func(i, j int) bool {
+		if players[i].Online != players[j].Online {
+			return players[i].Online
+		}
+		return players[i].LastSeen.After(players[j].LastSeen)
+	})
+	return players
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDropKeepsSeat(t *testing.T) {
+	s, pub := newTestGame(t)
+	s.seen("bob")
+	s.seen("alice")
+
+	// bob's heartbeats stopped a while ago; alice's didn't
+	s.mu.Lock()
+	s.roster["bob"].lastSeen = time.Now().Add(-time.Minute)
+	s.mu.Unlock()
+
+	gamelogs := s.drop(30 * time.Second)
+	if len(gamelogs) != 1 || gamelogs[0].Username != "bob" || gamelogs[0].Game != "europe" {
+		t.Fatalf("drop logged %+v, want bob dropping from europe", gamelogs)
+	}
+	if again := s.drop(30 * time.Second); len(again) != 0 {
+		t.Errorf("dropped %+v again", again)
+	}
+
+	players := s.players()
+	if len(players) != 2 || players[1].Username != "bob" || players[1].Online {
+		t.Fatalf("players = %+v, want bob offline after alice", players)
+	}
+	if game := s.lobby.GameOf("bob"); game != "europe" {
+		t.Errorf("bob is in %q after dropping, want europe", game)
+	}
+	if keys := pub.keys(); len(keys) != 0 {
+		t.Errorf("published %v on a drop", keys)
+	}
+
+	// once heartbeats resume, bob carries on in the same game
+	s.seen("bob")
+	for _, p := range s.players() {
+		if !p.Online {
+			t.Errorf("%s is offline after heartbeating", p.Username)
+		}
+	}
+	if game := s.lobby.GameOf("bob"); game != "europe" {
+		t.Errorf("bob is in %q after coming back, want europe", game)
+	}
+}
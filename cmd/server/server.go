@@ -0,0 +1,245 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// server holds what the REPL and the admin API share, so both run the
+// same code for every command
+type server struct {
+	pub        pubsub.Publisher
+	authority  ed25519.PrivateKey
+	creds      pubsub.Credentials
+	logLimiter *pubsub.RateLimiter
+	started    time.Time
+	lobby      *gamelogic.Lobby
+
+	mu       *sync.Mutex
+	roster   map[string]*presence
+	watchers map[chan Event]struct{}
+}
+
+// Event is a move, war or game log the server has seen. Exactly one field
+// is set; moves are as the world carried them out, not as they were sent.
+type Event struct {
+	Move *gamelogic.ArmyMove
+	War  *gamelogic.RecognitionOfWar
+	Log  *routing.GameLog
+}
+
+// watcherBufferSize is how many events a slow watcher may fall behind by
+// before it starts missing them
+const watcherBufferSize = 64
+
+// Status is paused when every game is
+type Status struct {
+	Paused  bool      `json:"paused"`
+	Started time.Time `json:"started"`
+	Players int       `json:"players"`
+	Online  int       `json:"online"`
+	Games   int       `json:"games"`
+}
+
+func newServer(pub pubsub.Publisher, authority ed25519.PrivateKey, creds pubsub.Credentials, logLimiter *pubsub.RateLimiter) *server {
+	return &server{
+		pub:        pub,
+		authority:  authority,
+		creds:      creds,
+		logLimiter: logLimiter,
+		started:    time.Now(),
+		lobby:      gamelogic.NewLobby(),
+		mu:         &sync.Mutex{},
+		roster:     map[string]*presence{},
+		watchers:   map[chan Event]struct{}{},
+	}
+}
+
+// setPaused pauses or resumes game, or every game if game is ""
+func (s *server) setPaused(game string, paused bool) error {
+	err := s.lobby.SetPaused(game, paused)
+	if err != nil {
+		return err
+	}
+
+	games := []string{game}
+	if game == "" {
+		games = []string{}
+		for _, g := range s.lobby.Games() {
+			games = append(games, g.ID)
+		}
+	}
+	for _, g := range games {
+		err := routing.Publish(
+			s.pub,
+			routing.PlayingState{Game: g, IsPaused: paused},
+			pubsub.WithSigner(s.creds),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *server) pause(game string) error {
+	return s.setPaused(game, true)
+}
+
+func (s *server) resume(game string) error {
+	return s.setPaused(game, false)
+}
+
+func (s *server) games() []gamelogic.GameInfo {
+	return s.lobby.Games()
+}
+
+// broadcast sends a message from the server to every player
+func (s *server) broadcast(message string) error {
+	return routing.Publish(
+		s.pub,
+		routing.Broadcast{
+			CurrentTime: time.Now(),
+			Message:     message,
+		},
+		pubsub.WithSigner(s.creds),
+	)
+}
+
+// publishDeltas tells players what the world did to their units. The
+// world has already changed, so failures are only logged.
+func (s *server) publishDeltas(deltas ...gamelogic.StateDelta) {
+	for _, delta := range deltas {
+		err := routing.Publish(s.pub, delta, pubsub.WithSigner(s.creds))
+		if err != nil {
+			log.Printf("could not publish delta for %s: %v", delta.Username, err)
+		}
+	}
+}
+
+// reply answers a lobby request, and brings a player who just joined a
+// game up to date with their units in it
+func (s *server) reply(reply gamelogic.LobbyReply) {
+	err := routing.Publish(s.pub, reply, pubsub.WithSigner(s.creds))
+	if err != nil {
+		log.Printf("could not publish lobby reply for %s: %v", reply.Username, err)
+	}
+
+	joined := reply.Action == gamelogic.LobbyCreate || reply.Action == gamelogic.LobbyJoin
+	if !joined || reply.Error != "" {
+		return
+	}
+	world, ok := s.lobby.World(reply.Game)
+	if ok {
+		s.publishDeltas(world.Sync(reply.Username))
+	}
+}
+
+// reject tells a player why the world refused their request. Errors that
+// aren't rejections are only the server's problem.
+func (s *server) reject(err error) {
+	var rejection *gamelogic.Rejection
+	if !errors.As(err, &rejection) {
+		return
+	}
+	err = routing.Publish(s.pub, *rejection, pubsub.WithSigner(s.creds))
+	if err != nil {
+		log.Printf("could not publish rejection for %s: %v", rejection.Username, err)
+	}
+}
+
+// recordWar tells spectators and the control plane about a war the world
+// fought, and writes its outcome to the game log
+func (s *server) recordWar(war gamelogic.War) {
+	rw := war.Recognition()
+	s.emit(Event{War: &rw})
+	err := routing.Publish(s.pub, rw, pubsub.WithSigner(s.creds))
+	if err != nil {
+		log.Printf("could not publish war in %s: %v", war.Location, err)
+	}
+
+	gamelog := routing.GameLog{
+		CurrentTime: time.Now(),
+		Message:     war.String(),
+		Username:    war.Attacker.Username,
+		Game:        war.Game,
+	}
+	s.emit(Event{Log: &gamelog})
+	err = gamelogic.WriteLog(gamelog)
+	if err != nil {
+		log.Printf("could not log war in %s: %v", war.Location, err)
+	}
+}
+
+func (s *server) issue(username string) (pubsub.Credentials, error) {
+	err := routing.ValidateUsername(username)
+	if err != nil {
+		return pubsub.Credentials{}, err
+	}
+	return pubsub.IssueCredentials(s.authority, username)
+}
+
+func (s *server) status() Status {
+	games := s.lobby.Games()
+	paused := len(games) > 0
+	for _, g := range games {
+		paused = paused && g.Paused
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	online := 0
+	for _, p := range s.roster {
+		if p.online {
+			online++
+		}
+	}
+	return Status{
+		Paused:  paused,
+		Started: s.started,
+		Players: len(s.roster),
+		Online:  online,
+		Games:   len(games),
+	}
+}
+
+func (s *server) offenders() map[string]int {
+	return s.logLimiter.Offenders()
+}
+
+func (s *server) logs(n int) ([]string, error) {
+	return gamelogic.ReadLogs(n)
+}
+
+// watch returns a channel receiving every event from now on, and a function
+// to stop watching
+func (s *server) watch() (<-chan Event, func()) {
+	events := make(chan Event, watcherBufferSize)
+	s.mu.Lock()
+	s.watchers[events] = struct{}{}
+	s.mu.Unlock()
+
+	return events, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.watchers, events)
+	}
+}
+
+func (s *server) emit(ev Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for events := range s.watchers {
+		select {
+		case events <- ev:
+		default:
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// published is a message the server handed to its publisher
+type published struct {
+	exchange string
+	key      string
+	msg      amqp.Publishing
+}
+
+// recordingPublisher keeps everything published through it instead of
+// sending it anywhere
+type recordingPublisher struct {
+	mu       *sync.Mutex
+	messages []published
+}
+
+func (p *recordingPublisher) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.messages = append(p.messages, published{exchange: exchange, key: key, msg: msg})
+	return nil
+}
+
+// keys returns the routing keys published so far
+func (p *recordingPublisher) keys() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	keys := []string{}
+	for _, m := range p.messages {
+		keys = append(keys, m.key)
+	}
+	return keys
+}
+
+func newTestServer(t *testing.T) (*server, *recordingPublisher) {
+	t.Helper()
+	_, authority, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds, err := pubsub.IssueCredentials(authority, "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := &recordingPublisher{mu: &sync.Mutex{}}
+	return newServer(pub, authority, creds, pubsub.NewRateLimiter(logRate, logBurst)), pub
+}
+
+func TestIssueRefusesServerUsername(t *testing.T) {
+	s, _ := newTestServer(t)
+	_, err := s.issue(routing.ServerUsername)
+	if !errors.Is(err, routing.ErrInvalidUsername) {
+		t.Errorf("issuing credentials for %s: err = %v, want ErrInvalidUsername", routing.ServerUsername, err)
+	}
+}
@@ -0,0 +1,193 @@
+package amqptest
+
+import (
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing/topic"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+type exchange struct {
+	name     string
+	kind     string
+	durable  bool
+	bindings []binding
+}
+
+type binding struct {
+	queue string
+	key   string
+}
+
+type queue struct {
+	name       string
+	durable    bool
+	exclusive  bool
+	autoDelete bool
+	owner      *conn
+	args       amqp.Table
+	messages   []*message
+	consumers  []*consumer
+	next       int
+}
+
+type message struct {
+	exchange    string
+	key         string
+	props       []byte
+	body        []byte
+	redelivered bool
+}
+
+type consumer struct {
+	tag   string
+	ch    *channel
+	q     *queue
+	noAck bool
+}
+
+// route returns the queues a message published to ex with key reaches.
+// Must be called with s.mu held.
+func (s *Server) route(ex *exchange, key string) []*queue {
+	if ex.name == "" {
+		if q, ok := s.queues[key]; ok {
+			return []*queue{q}
+		}
+		return nil
+	}
+
+	seen := map[string]struct{}{}
+	queues := []*queue{}
+	for _, b := range ex.bindings {
+		if _, ok := seen[b.queue]; ok {
+			continue
+		}
+
+		var match bool
+		switch ex.kind {
+		case amqp.ExchangeFanout:
+			match = true
+		case amqp.ExchangeTopic:
+			match = topic.Match(b.key, key)
+		default:
+			match = b.key == key
+		}
+		if !match {
+			continue
+		}
+
+		q, ok := s.queues[b.queue]
+		if !ok {
+			continue
+		}
+		seen[b.queue] = struct{}{}
+		queues = append(queues, q)
+	}
+	return queues
+}
+
+// enqueue adds m to q and hands out whatever q's consumers have room for.
+// Must be called with s.mu held.
+func (s *Server) enqueue(q *queue, m *message) {
+	q.messages = append(q.messages, m)
+	s.dispatch(q)
+}
+
+// dispatch delivers queued messages round-robin to consumers whose channel
+// is under its prefetch limit. Must be called with s.mu held.
+func (s *Server) dispatch(q *queue) {
+	for len(q.messages) > 0 && len(q.consumers) > 0 {
+		var cons *consumer
+		for i := 0; i < len(q.consumers); i++ {
+			c := q.consumers[(q.next+i)%len(q.consumers)]
+			if c.ch.hasCapacity() {
+				cons = c
+				q.next = (q.next + i + 1) % len(q.consumers)
+				break
+			}
+		}
+		if cons == nil {
+			return
+		}
+
+		m := q.messages[0]
+		q.messages = q.messages[1:]
+		cons.ch.deliver(cons, m)
+	}
+}
+
+func (s *Server) dispatchAll() {
+	for _, q := range s.queues {
+		s.dispatch(q)
+	}
+}
+
+// deadLetter republishes a rejected message to q's dead-letter exchange, if
+// it has one. Must be called with s.mu held.
+func (s *Server) deadLetter(q *queue, m *message) {
+	dlx, ok := q.args["x-dead-letter-exchange"].(string)
+	if !ok {
+		return
+	}
+	ex, ok := s.exchanges[dlx]
+	if !ok {
+		return
+	}
+
+	key := m.key
+	if dlrk, ok := q.args["x-dead-letter-routing-key"].(string); ok {
+		key = dlrk
+	}
+
+	for _, target := range s.route(ex, key) {
+		s.enqueue(target, &message{
+			exchange: dlx,
+			key:      key,
+			props:    m.props,
+			body:     m.body,
+		})
+	}
+}
+
+// requeue puts m back at the head of q. Must be called with s.mu held.
+func (s *Server) requeue(q *queue, m *message) {
+	m.redelivered = true
+	q.messages = append([]*message{m}, q.messages...)
+}
+
+// removeConsumer detaches cons from its queue, deleting the queue if it is
+// auto-delete and that was its last consumer. Must be called with s.mu held.
+func (s *Server) removeConsumer(cons *consumer) {
+	q := cons.q
+	for i, c := range q.consumers {
+		if c == cons {
+			q.consumers = append(q.consumers[:i], q.consumers[i+1:]...)
+			break
+		}
+	}
+	if q.next >= len(q.consumers) {
+		q.next = 0
+	}
+	if q.autoDelete && len(q.consumers) == 0 {
+		s.deleteQueue(q)
+	}
+}
+
+// deleteQueue removes q and its bindings. Must be called with s.mu held.
+func (s *Server) deleteQueue(q *queue) {
+	if s.queues[q.name] != q {
+		return
+	}
+	delete(s.queues, q.name)
+	for _, ex := range s.exchanges {
+		bindings := ex.bindings[:0]
+		for _, b := range ex.bindings {
+			if b.queue != q.name {
+				bindings = append(bindings, b)
+			}
+		}
+		ex.bindings = bindings
+	}
+	for _, c := range q.consumers {
+		delete(c.ch.consumers, c.tag)
+	}
+	q.consumers = nil
+}
@@ -0,0 +1,563 @@
+package amqptest
+
+import (
+	"fmt"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+type channel struct {
+	c         *conn
+	id        uint16
+	closing   bool
+	prefetch  int
+	nextTag   uint64
+	unacked   map[uint64]unacked
+	consumers map[string]*consumer
+	confirm   bool
+	published uint64
+	pending   *pendingPublish
+}
+
+// unacked is a message delivered on the channel and not yet settled
+type unacked struct {
+	q *queue
+	m *message
+}
+
+// pendingPublish collects a basic.publish and its content frames
+type pendingPublish struct {
+	exchange  string
+	key       string
+	mandatory bool
+	size      uint64
+	props     []byte
+	body      []byte
+	gotHeader bool
+}
+
+func newChannel(c *conn, id uint16) *channel {
+	return &channel{
+		c:         c,
+		id:        id,
+		unacked:   map[uint64]unacked{},
+		consumers: map[string]*consumer{},
+	}
+}
+
+func (ch *channel) hasCapacity() bool {
+	return ch.prefetch == 0 || len(ch.unacked) < ch.prefetch
+}
+
+// deliver sends m to cons. Must be called with s.mu held.
+func (ch *channel) deliver(cons *consumer, m *message) {
+	ch.nextTag++
+	if !cons.noAck {
+		ch.unacked[ch.nextTag] = unacked{q: cons.q, m: m}
+	}
+
+	ch.c.sendMethod(ch.id, method(60, 60).
+		shortstr(cons.tag).
+		longlong(ch.nextTag).
+		bit(m.redelivered).
+		shortstr(m.exchange).
+		shortstr(m.key))
+	ch.c.sendContent(ch.id, m)
+}
+
+// release cancels the channel's consumers and requeues its unacked
+// messages. Must be called with s.mu held.
+func (ch *channel) release() {
+	s := ch.c.s
+	for _, cons := range ch.consumers {
+		s.removeConsumer(cons)
+	}
+	ch.consumers = map[string]*consumer{}
+
+	for _, tag := range sortedTags(ch.unacked) {
+		u := ch.unacked[tag]
+		if s.queues[u.q.name] == u.q {
+			s.requeue(u.q, u.m)
+		}
+	}
+	ch.unacked = map[uint64]unacked{}
+	ch.pending = nil
+	s.dispatchAll()
+}
+
+func (ch *channel) handleMethod(class, id uint16, d *decoder) error {
+	if ch.closing {
+		// after sending channel.close only close-ok matters
+		if class == 20 && id == 41 {
+			delete(ch.c.channels, ch.id)
+		}
+		return nil
+	}
+	if ch.pending != nil {
+		return connectionError(amqp.UnexpectedFrame, class, id, "expected content for basic.publish")
+	}
+
+	s := ch.c.s
+	switch {
+	case class == 20 && id == 20: // channel.flow
+		active := d.bit()
+		ch.c.sendMethod(ch.id, method(20, 21).bit(active))
+
+	case class == 20 && id == 40: // channel.close
+		ch.release()
+		delete(ch.c.channels, ch.id)
+		ch.c.sendMethod(ch.id, method(20, 41))
+
+	case class == 40 && id == 10: // exchange.declare
+		d.short()
+		name := d.shortstr()
+		kind := d.shortstr()
+		passive, durable := d.bit(), d.bit()
+		d.bit() // auto-delete
+		d.bit() // internal
+		noWait := d.bit()
+		d.table()
+		if d.err != nil {
+			return connectionError(amqp.SyntaxError, class, id, "malformed exchange.declare")
+		}
+
+		ex, ok := s.exchanges[name]
+		switch {
+		case ok && !passive && ex.kind != kind:
+			return channelError(amqp.PreconditionFailed, class, id,
+				"inequivalent arg 'type' for exchange '%s': received '%s' but current is '%s'", name, kind, ex.kind)
+		case !ok && passive:
+			return channelError(amqp.NotFound, class, id, "no exchange '%s'", name)
+		case !ok:
+			switch kind {
+			case amqp.ExchangeDirect, amqp.ExchangeFanout, amqp.ExchangeTopic:
+			default:
+				return connectionError(amqp.CommandInvalid, class, id, "exchange type '%s' is not supported", kind)
+			}
+			s.exchanges[name] = &exchange{name: name, kind: kind, durable: durable}
+		}
+		if !noWait {
+			ch.c.sendMethod(ch.id, method(40, 11))
+		}
+
+	case class == 40 && id == 20: // exchange.delete
+		d.short()
+		name := d.shortstr()
+		ifUnused := d.bit()
+		noWait := d.bit()
+
+		ex, ok := s.exchanges[name]
+		if !ok {
+			return channelError(amqp.NotFound, class, id, "no exchange '%s'", name)
+		}
+		if ifUnused && len(ex.bindings) > 0 {
+			return channelError(amqp.PreconditionFailed, class, id, "exchange '%s' in use", name)
+		}
+		delete(s.exchanges, name)
+		if !noWait {
+			ch.c.sendMethod(ch.id, method(40, 21))
+		}
+
+	case class == 50 && id == 10: // queue.declare
+		d.short()
+		name := d.shortstr()
+		passive, durable, exclusive, autoDelete, noWait := d.bit(), d.bit(), d.bit(), d.bit(), d.bit()
+		args := d.table()
+		if d.err != nil {
+			return connectionError(amqp.SyntaxError, class, id, "malformed queue.declare")
+		}
+
+		if name == "" {
+			s.nextID++
+			name = fmt.Sprintf("amq.gen-%d", s.nextID)
+		}
+
+		q, ok := s.queues[name]
+		switch {
+		case ok && q.exclusive && q.owner != ch.c:
+			return channelError(amqp.ResourceLocked, class, id, "cannot obtain exclusive access to locked queue '%s'", name)
+		case ok && !passive && (q.durable != durable || q.exclusive != exclusive || q.autoDelete != autoDelete):
+			return channelError(amqp.PreconditionFailed, class, id, "inequivalent arg for queue '%s'", name)
+		case ok && !passive && fmt.Sprint(q.args["x-dead-letter-exchange"]) != fmt.Sprint(args["x-dead-letter-exchange"]):
+			return channelError(amqp.PreconditionFailed, class, id, "inequivalent arg 'x-dead-letter-exchange' for queue '%s'", name)
+		case !ok && passive:
+			return channelError(amqp.NotFound, class, id, "no queue '%s'", name)
+		case !ok:
+			q = &queue{
+				name:       name,
+				durable:    durable,
+				exclusive:  exclusive,
+				autoDelete: autoDelete,
+				args:       args,
+			}
+			if exclusive {
+				q.owner = ch.c
+			}
+			s.queues[name] = q
+		}
+		if !noWait {
+			ch.c.sendMethod(ch.id, method(50, 11).shortstr(name).long(uint32(len(q.messages))).long(uint32(len(q.consumers))))
+		}
+
+	case class == 50 && id == 20: // queue.bind
+		d.short()
+		queueName, exchangeName, key := d.shortstr(), d.shortstr(), d.shortstr()
+		noWait := d.bit()
+		d.table()
+
+		q, ex, err := ch.lookupBinding(class, id, queueName, exchangeName)
+		if err != nil {
+			return err
+		}
+		if ex.name == "" {
+			return channelError(amqp.AccessRefused, class, id, "operation not permitted on the default exchange")
+		}
+		exists := false
+		for _, b := range ex.bindings {
+			if b.queue == q.name && b.key == key {
+				exists = true
+			}
+		}
+		if !exists {
+			ex.bindings = append(ex.bindings, binding{queue: q.name, key: key})
+		}
+		if !noWait {
+			ch.c.sendMethod(ch.id, method(50, 21))
+		}
+
+	case class == 50 && id == 50: // queue.unbind
+		d.short()
+		queueName, exchangeName, key := d.shortstr(), d.shortstr(), d.shortstr()
+		d.table()
+
+		q, ex, err := ch.lookupBinding(class, id, queueName, exchangeName)
+		if err != nil {
+			return err
+		}
+		bindings := ex.bindings[:0]
+		for _, b := range ex.bindings {
+			if b.queue != q.name || b.key != key {
+				bindings = append(bindings, b)
+			}
+		}
+		ex.bindings = bindings
+		ch.c.sendMethod(ch.id, method(50, 51))
+
+	case class == 50 && id == 30: // queue.purge
+		d.short()
+		name := d.shortstr()
+		noWait := d.bit()
+
+		q, err := ch.lookupQueue(class, id, name)
+		if err != nil {
+			return err
+		}
+		n := len(q.messages)
+		q.messages = nil
+		if !noWait {
+			ch.c.sendMethod(ch.id, method(50, 31).long(uint32(n)))
+		}
+
+	case class == 50 && id == 40: // queue.delete
+		d.short()
+		name := d.shortstr()
+		ifUnused, ifEmpty, noWait := d.bit(), d.bit(), d.bit()
+
+		q, err := ch.lookupQueue(class, id, name)
+		if err != nil {
+			return err
+		}
+		if ifUnused && len(q.consumers) > 0 {
+			return channelError(amqp.PreconditionFailed, class, id, "queue '%s' in use", name)
+		}
+		if ifEmpty && len(q.messages) > 0 {
+			return channelError(amqp.PreconditionFailed, class, id, "queue '%s' not empty", name)
+		}
+		n := len(q.messages)
+		s.deleteQueue(q)
+		if !noWait {
+			ch.c.sendMethod(ch.id, method(50, 41).long(uint32(n)))
+		}
+
+	case class == 60 && id == 10: // basic.qos
+		d.long()
+		ch.prefetch = int(d.short())
+		d.bit()
+		ch.c.sendMethod(ch.id, method(60, 11))
+		s.dispatchAll()
+
+	case class == 60 && id == 20: // basic.consume
+		d.short()
+		queueName, tag := d.shortstr(), d.shortstr()
+		d.bit() // no-local
+		noAck := d.bit()
+		d.bit() // exclusive
+		noWait := d.bit()
+		d.table()
+
+		q, err := ch.lookupQueue(class, id, queueName)
+		if err != nil {
+			return err
+		}
+		if tag == "" {
+			s.nextID++
+			tag = fmt.Sprintf("amq.ctag-%d", s.nextID)
+		}
+		if _, ok := ch.consumers[tag]; ok {
+			return connectionError(amqp.NotAllowed, class, id, "attempt to reuse consumer tag '%s'", tag)
+		}
+
+		cons := &consumer{tag: tag, ch: ch, q: q, noAck: noAck}
+		ch.consumers[tag] = cons
+		q.consumers = append(q.consumers, cons)
+		if !noWait {
+			ch.c.sendMethod(ch.id, method(60, 21).shortstr(tag))
+		}
+		s.dispatch(q)
+
+	case class == 60 && id == 30: // basic.cancel
+		tag := d.shortstr()
+		noWait := d.bit()
+		if cons, ok := ch.consumers[tag]; ok {
+			delete(ch.consumers, tag)
+			s.removeConsumer(cons)
+		}
+		if !noWait {
+			ch.c.sendMethod(ch.id, method(60, 31).shortstr(tag))
+		}
+
+	case class == 60 && id == 40: // basic.publish
+		d.short()
+		exchangeName, key := d.shortstr(), d.shortstr()
+		mandatory := d.bit()
+		d.bit() // immediate
+		if d.err != nil {
+			return connectionError(amqp.SyntaxError, class, id, "malformed basic.publish")
+		}
+		ch.pending = &pendingPublish{exchange: exchangeName, key: key, mandatory: mandatory}
+
+	case class == 60 && id == 70: // basic.get
+		d.short()
+		name := d.shortstr()
+		noAck := d.bit()
+
+		q, err := ch.lookupQueue(class, id, name)
+		if err != nil {
+			return err
+		}
+		if len(q.messages) == 0 {
+			ch.c.sendMethod(ch.id, method(60, 72).shortstr(""))
+			return nil
+		}
+
+		m := q.messages[0]
+		q.messages = q.messages[1:]
+		ch.nextTag++
+		if !noAck {
+			ch.unacked[ch.nextTag] = unacked{q: q, m: m}
+		}
+		ch.c.sendMethod(ch.id, method(60, 71).
+			longlong(ch.nextTag).
+			bit(m.redelivered).
+			shortstr(m.exchange).
+			shortstr(m.key).
+			long(uint32(len(q.messages))))
+		ch.c.sendContent(ch.id, m)
+
+	case class == 60 && id == 80: // basic.ack
+		tag, multiple := d.longlong(), d.bit()
+		_, err := ch.settle(class, id, tag, multiple)
+		if err != nil {
+			return err
+		}
+		s.dispatchAll()
+
+	case class == 60 && id == 90: // basic.reject
+		tag, requeue := d.longlong(), d.bit()
+		return ch.reject(class, id, tag, false, requeue)
+
+	case class == 60 && id == 120: // basic.nack
+		tag, multiple, requeue := d.longlong(), d.bit(), d.bit()
+		return ch.reject(class, id, tag, multiple, requeue)
+
+	case class == 60 && (id == 100 || id == 110): // basic.recover-async, basic.recover
+		d.bit()
+		for _, tag := range sortedTags(ch.unacked) {
+			u := ch.unacked[tag]
+			s.requeue(u.q, u.m)
+		}
+		ch.unacked = map[uint64]unacked{}
+		if id == 110 {
+			ch.c.sendMethod(ch.id, method(60, 111))
+		}
+		s.dispatchAll()
+
+	case class == 85 && id == 10: // confirm.select
+		noWait := d.bit()
+		ch.confirm = true
+		if !noWait {
+			ch.c.sendMethod(ch.id, method(85, 11))
+		}
+
+	default:
+		return connectionError(amqp.NotImplemented, class, id, "method %d.%d is not implemented", class, id)
+	}
+
+	return nil
+}
+
+// handleContent collects the header and body frames of a publish, and
+// routes the message once the body is complete
+func (ch *channel) handleContent(f frame) error {
+	if ch.closing {
+		return nil
+	}
+	p := ch.pending
+	if p == nil {
+		return connectionError(amqp.UnexpectedFrame, 0, 0, "content frame without basic.publish")
+	}
+
+	if f.typ == frameHeader {
+		if p.gotHeader {
+			return connectionError(amqp.UnexpectedFrame, 0, 0, "duplicate content header")
+		}
+		d := newDecoder(f.payload)
+		d.short() // class
+		d.short() // weight
+		p.size = d.longlong()
+		if d.err != nil {
+			return connectionError(amqp.FrameError, 0, 0, "malformed content header")
+		}
+		if p.size > maxBodySize {
+			return connectionError(amqp.FrameError, 0, 0, "message body of %d bytes is too large", p.size)
+		}
+		p.props = append([]byte(nil), d.b...)
+		p.gotHeader = true
+	} else {
+		if !p.gotHeader {
+			return connectionError(amqp.UnexpectedFrame, 0, 0, "content body before header")
+		}
+		p.body = append(p.body, f.payload...)
+		if uint64(len(p.body)) > p.size {
+			return connectionError(amqp.FrameError, 0, 0, "content body is larger than declared")
+		}
+	}
+
+	if uint64(len(p.body)) < p.size {
+		return nil
+	}
+
+	ch.pending = nil
+	return ch.publish(p)
+}
+
+func (ch *channel) publish(p *pendingPublish) error {
+	s := ch.c.s
+	ex, ok := s.exchanges[p.exchange]
+	if !ok {
+		return channelError(amqp.NotFound, 60, 40, "no exchange '%s'", p.exchange)
+	}
+
+	m := &message{exchange: p.exchange, key: p.key, props: p.props, body: p.body}
+	queues := s.route(ex, p.key)
+	for i, q := range queues {
+		if i > 0 {
+			copied := *m
+			m = &copied
+		}
+		s.enqueue(q, m)
+	}
+
+	if len(queues) == 0 && p.mandatory {
+		ch.c.sendMethod(ch.id, method(60, 50).short(amqp.NoRoute).shortstr("NO_ROUTE").shortstr(p.exchange).shortstr(p.key))
+		ch.c.sendContent(ch.id, m)
+	}
+	if ch.confirm {
+		ch.published++
+		ch.c.sendMethod(ch.id, method(60, 80).longlong(ch.published).bit(false))
+	}
+	return nil
+}
+
+// settle removes acknowledged deliveries and returns them
+func (ch *channel) settle(class, id uint16, tag uint64, multiple bool) ([]unacked, error) {
+	if !multiple {
+		u, ok := ch.unacked[tag]
+		if !ok {
+			return nil, channelError(amqp.PreconditionFailed, class, id, "unknown delivery tag %d", tag)
+		}
+		delete(ch.unacked, tag)
+		return []unacked{u}, nil
+	}
+
+	settled := []unacked{}
+	for _, t := range sortedTags(ch.unacked) {
+		if tag != 0 && t > tag {
+			break
+		}
+		settled = append(settled, ch.unacked[t])
+		delete(ch.unacked, t)
+	}
+	return settled, nil
+}
+
+// reject settles deliveries negatively, requeueing them or sending them to
+// their queue's dead-letter exchange
+func (ch *channel) reject(class, id uint16, tag uint64, multiple, requeue bool) error {
+	s := ch.c.s
+	settled, err := ch.settle(class, id, tag, multiple)
+	if err != nil {
+		return err
+	}
+
+	// requeue in reverse so the earliest delivery ends up at the head
+	for i := len(settled) - 1; i >= 0; i-- {
+		u := settled[i]
+		if s.queues[u.q.name] != u.q {
+			continue
+		}
+		if requeue {
+			s.requeue(u.q, u.m)
+		} else {
+			s.deadLetter(u.q, u.m)
+		}
+	}
+	s.dispatchAll()
+	return nil
+}
+
+func (ch *channel) lookupQueue(class, id uint16, name string) (*queue, error) {
+	q, ok := ch.c.s.queues[name]
+	if !ok {
+		return nil, channelError(amqp.NotFound, class, id, "no queue '%s'", name)
+	}
+	if q.exclusive && q.owner != ch.c {
+		return nil, channelError(amqp.ResourceLocked, class, id, "cannot obtain exclusive access to locked queue '%s'", name)
+	}
+	return q, nil
+}
+
+func (ch *channel) lookupBinding(class, id uint16, queueName, exchangeName string) (*queue, *exchange, error) {
+	q, err := ch.lookupQueue(class, id, queueName)
+	if err != nil {
+		return nil, nil, err
+	}
+	ex, ok := ch.c.s.exchanges[exchangeName]
+	if !ok {
+		return nil, nil, channelError(amqp.NotFound, class, id, "no exchange '%s'", exchangeName)
+	}
+	return q, ex, nil
+}
+
+func sortedTags(m map[uint64]unacked) []uint64 {
+	tags := make([]uint64, 0, len(m))
+	for t := range m {
+		tags = append(tags, t)
+	}
+	for i := 1; i < len(tags); i++ {
+		for j := i; j > 0 && tags[j] < tags[j-1]; j-- {
+			tags[j], tags[j-1] = tags[j-1], tags[j]
+		}
+	}
+	return tags
+}
@@ -0,0 +1,309 @@
+package amqptest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	frameMethod    = 1
+	frameHeader    = 2
+	frameBody      = 3
+	frameHeartbeat = 8
+	frameEnd       = 0xCE
+)
+
+var protocolHeader = []byte{'A', 'M', 'Q', 'P', 0, 0, 9, 1}
+
+type frame struct {
+	typ     byte
+	channel uint16
+	payload []byte
+}
+
+func readFrame(r *bufio.Reader, frameMax uint32) (frame, error) {
+	var head [7]byte
+	_, err := io.ReadFull(r, head[:])
+	if err != nil {
+		return frame{}, err
+	}
+
+	f := frame{
+		typ:     head[0],
+		channel: binary.BigEndian.Uint16(head[1:3]),
+	}
+	size := binary.BigEndian.Uint32(head[3:7])
+	if frameMax > 0 && size > frameMax {
+		return frame{}, fmt.Errorf("frame of %d bytes exceeds frame-max %d", size, frameMax)
+	}
+
+	f.payload = make([]byte, size+1)
+	_, err = io.ReadFull(r, f.payload)
+	if err != nil {
+		return frame{}, err
+	}
+	if f.payload[size] != frameEnd {
+		return frame{}, errors.New("frame is missing its frame-end octet")
+	}
+	f.payload = f.payload[:size]
+	return f, nil
+}
+
+func (f frame) encode() []byte {
+	b := make([]byte, 7, 8+len(f.payload))
+	b[0] = f.typ
+	binary.BigEndian.PutUint16(b[1:3], f.channel)
+	binary.BigEndian.PutUint32(b[3:7], uint32(len(f.payload)))
+	b = append(b, f.payload...)
+	return append(b, frameEnd)
+}
+
+// decoder reads method arguments. The first error sticks, so callers can
+// read every field and check err once at the end.
+type decoder struct {
+	b      []byte
+	err    error
+	bits   byte
+	bitPos int
+}
+
+func newDecoder(b []byte) *decoder {
+	return &decoder{b: b}
+}
+
+func (d *decoder) take(n int) []byte {
+	d.bitPos = 0
+	if d.err != nil {
+		return make([]byte, n)
+	}
+	if len(d.b) < n {
+		d.err = io.ErrUnexpectedEOF
+		return make([]byte, n)
+	}
+	out := d.b[:n]
+	d.b = d.b[n:]
+	return out
+}
+
+func (d *decoder) octet() byte {
+	return d.take(1)[0]
+}
+
+func (d *decoder) short() uint16 {
+	return binary.BigEndian.Uint16(d.take(2))
+}
+
+func (d *decoder) long() uint32 {
+	return binary.BigEndian.Uint32(d.take(4))
+}
+
+func (d *decoder) longlong() uint64 {
+	return binary.BigEndian.Uint64(d.take(8))
+}
+
+func (d *decoder) shortstr() string {
+	n := int(d.octet())
+	return string(d.take(n))
+}
+
+func (d *decoder) longstr() []byte {
+	n := int(d.long())
+	if n > len(d.b) {
+		d.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	return append([]byte(nil), d.take(n)...)
+}
+
+// bit reads the next of a run of packed bits
+func (d *decoder) bit() bool {
+	if d.bitPos == 0 || d.bitPos == 8 {
+		d.bits = d.take(1)[0]
+	}
+	v := d.bits&(1<<d.bitPos) != 0
+	d.bitPos++
+	return v
+}
+
+func (d *decoder) table() amqp.Table {
+	raw := d.longstr()
+	if d.err != nil {
+		return nil
+	}
+
+	inner := newDecoder(raw)
+	t := amqp.Table{}
+	for len(inner.b) > 0 && inner.err == nil {
+		name := inner.shortstr()
+		t[name] = inner.field()
+	}
+	if inner.err != nil {
+		d.err = inner.err
+	}
+	return t
+}
+
+func (d *decoder) field() any {
+	switch d.octet() {
+	case 't':
+		return d.octet() != 0
+	case 'b':
+		return int8(d.octet())
+	case 'B':
+		return d.octet()
+	case 's':
+		return int16(d.short())
+	case 'u':
+		return d.short()
+	case 'I':
+		return int32(d.long())
+	case 'i':
+		return d.long()
+	case 'l':
+		return int64(d.longlong())
+	case 'f':
+		return math.Float32frombits(d.long())
+	case 'd':
+		return math.Float64frombits(d.longlong())
+	case 'D':
+		scale := d.octet()
+		return amqp.Decimal{Scale: scale, Value: int32(d.long())}
+	case 'S':
+		return string(d.longstr())
+	case 'x':
+		return d.longstr()
+	case 'T':
+		return time.Unix(int64(d.longlong()), 0)
+	case 'F':
+		return d.table()
+	case 'A':
+		raw := d.longstr()
+		inner := newDecoder(raw)
+		arr := []any{}
+		for len(inner.b) > 0 && inner.err == nil {
+			arr = append(arr, inner.field())
+		}
+		if inner.err != nil {
+			d.err = inner.err
+		}
+		return arr
+	case 'V':
+		return nil
+	default:
+		if d.err == nil {
+			d.err = errors.New("unknown table field type")
+		}
+		return nil
+	}
+}
+
+// encoder writes method arguments, packing consecutive bits into octets
+type encoder struct {
+	buf    bytes.Buffer
+	bits   byte
+	bitPos int
+}
+
+func (e *encoder) flushBits() {
+	if e.bitPos > 0 {
+		e.buf.WriteByte(e.bits)
+		e.bits, e.bitPos = 0, 0
+	}
+}
+
+func (e *encoder) octet(v byte) *encoder {
+	e.flushBits()
+	e.buf.WriteByte(v)
+	return e
+}
+
+func (e *encoder) short(v uint16) *encoder {
+	e.flushBits()
+	binary.Write(&e.buf, binary.BigEndian, v)
+	return e
+}
+
+func (e *encoder) long(v uint32) *encoder {
+	e.flushBits()
+	binary.Write(&e.buf, binary.BigEndian, v)
+	return e
+}
+
+func (e *encoder) longlong(v uint64) *encoder {
+	e.flushBits()
+	binary.Write(&e.buf, binary.BigEndian, v)
+	return e
+}
+
+func (e *encoder) shortstr(s string) *encoder {
+	e.flushBits()
+	if len(s) > math.MaxUint8 {
+		s = s[:math.MaxUint8]
+	}
+	e.buf.WriteByte(byte(len(s)))
+	e.buf.WriteString(s)
+	return e
+}
+
+func (e *encoder) longstr(b []byte) *encoder {
+	e.flushBits()
+	binary.Write(&e.buf, binary.BigEndian, uint32(len(b)))
+	e.buf.Write(b)
+	return e
+}
+
+func (e *encoder) bit(v bool) *encoder {
+	if e.bitPos == 8 {
+		e.flushBits()
+	}
+	if v {
+		e.bits |= 1 << e.bitPos
+	}
+	e.bitPos++
+	return e
+}
+
+// table only needs to handle what the server itself sends: strings, bools
+// and nested tables
+func (e *encoder) table(t amqp.Table) *encoder {
+	inner := &encoder{}
+	for k, v := range t {
+		inner.shortstr(k)
+		switch v := v.(type) {
+		case bool:
+			inner.octet('t')
+			if v {
+				inner.octet(1)
+			} else {
+				inner.octet(0)
+			}
+		case string:
+			inner.octet('S').longstr([]byte(v))
+		case amqp.Table:
+			inner.octet('F').table(v)
+		default:
+			inner.octet('V')
+		}
+	}
+	return e.longstr(inner.bytes())
+}
+
+func (e *encoder) bytes() []byte {
+	e.flushBits()
+	return e.buf.Bytes()
+}
+
+// method builds a method frame payload for class and method
+func method(class, id uint16) *encoder {
+	e := &encoder{}
+	e.short(class).short(id)
+	return e
+}
@@ -0,0 +1,472 @@
+// Package amqptest runs a minimal AMQP 0-9-1 broker in-process, so code
+// using amqp091-go can be tested without RabbitMQ. It supports direct,
+// fanout and topic exchanges, queues and bindings, basic.publish, consume,
+// get, ack, nack, reject, QoS, publisher confirms and dead-letter exchanges.
+// Nothing is persisted, and transactions and headers exchanges are not
+// implemented.
+package amqptest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	maxFrameSize = 131072
+	maxChannels  = 2047
+	maxBodySize  = 128 << 20
+	heartbeat    = 10
+)
+
+// Server is an in-process AMQP broker listening on a local port
+type Server struct {
+	ln net.Listener
+	wg *sync.WaitGroup
+
+	mu        *sync.Mutex
+	exchanges map[string]*exchange
+	queues    map[string]*queue
+	conns     map[*conn]struct{}
+	nextID    int
+}
+
+// NewServer starts a broker on a random localhost port with the default
+// exchanges already declared
+func NewServer() (*Server, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		ln:        ln,
+		wg:        &sync.WaitGroup{},
+		mu:        &sync.Mutex{},
+		exchanges: map[string]*exchange{},
+		queues:    map[string]*queue{},
+		conns:     map[*conn]struct{}{},
+	}
+	for name, kind := range map[string]string{
+		"":            amqp.ExchangeDirect,
+		"amq.direct":  amqp.ExchangeDirect,
+		"amq.fanout":  amqp.ExchangeFanout,
+		"amq.topic":   amqp.ExchangeTopic,
+		"amq.headers": amqp.ExchangeHeaders,
+	} {
+		s.exchanges[name] = &exchange{name: name, kind: kind, durable: true}
+	}
+
+	s.wg.Add(1)
+	go s.acceptLoop()
+	return s, nil
+}
+
+// URL is what amqp.Dial should be given to reach the server
+func (s *Server) URL() string {
+	return fmt.Sprintf("amqp://guest:guest@%s/", s.ln.Addr())
+}
+
+// Close stops accepting connections and drops the ones already open
+func (s *Server) Close() error {
+	err := s.ln.Close()
+	s.mu.Lock()
+	for c := range s.conns {
+		c.nc.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+// QueueLength returns how many ready messages queue holds, which is handy
+// for asserting on dead-letter queues
+func (s *Server) QueueLength(name string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q, ok := s.queues[name]
+	if !ok {
+		return 0
+	}
+	return len(q.messages)
+}
+
+func (s *Server) acceptLoop() {
+	defer s.wg.Done()
+	for {
+		nc, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		c := &conn{
+			s:        s,
+			nc:       nc,
+			r:        bufio.NewReader(nc),
+			out:      newOutbox(nc),
+			frameMax: maxFrameSize,
+			channels: map[uint16]*channel{},
+		}
+		s.mu.Lock()
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			c.serve()
+		}()
+	}
+}
+
+// amqpError is a channel or connection exception
+type amqpError struct {
+	code       uint16
+	text       string
+	class, id  uint16
+	connection bool
+}
+
+func (e *amqpError) Error() string {
+	return fmt.Sprintf("%d %s", e.code, e.text)
+}
+
+func channelError(code uint16, class, id uint16, format string, args ...any) *amqpError {
+	return &amqpError{code: code, text: fmt.Sprintf(format, args...), class: class, id: id}
+}
+
+func connectionError(code uint16, class, id uint16, format string, args ...any) *amqpError {
+	return &amqpError{code: code, text: fmt.Sprintf(format, args...), class: class, id: id, connection: true}
+}
+
+// outbox queues frames for a connection's writer goroutine, so the broker
+// never blocks on a slow client while holding its lock
+type outbox struct {
+	w      io.Writer
+	mu     *sync.Mutex
+	cond   *sync.Cond
+	queue  [][]byte
+	closed bool
+	last   time.Time
+	done   chan struct{}
+}
+
+func newOutbox(w io.Writer) *outbox {
+	mu := &sync.Mutex{}
+	o := &outbox{w: w, mu: mu, cond: sync.NewCond(mu), last: time.Now(), done: make(chan struct{})}
+	go o.run()
+	return o
+}
+
+func (o *outbox) send(b []byte) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return
+	}
+	o.queue = append(o.queue, b)
+	o.cond.Signal()
+}
+
+// close stops the writer once everything already queued has been written
+func (o *outbox) close() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.closed = true
+	o.cond.Signal()
+}
+
+func (o *outbox) idleSince() time.Time {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.last
+}
+
+func (o *outbox) run() {
+	defer close(o.done)
+	for {
+		o.mu.Lock()
+		for len(o.queue) == 0 && !o.closed {
+			o.cond.Wait()
+		}
+		if len(o.queue) == 0 {
+			o.mu.Unlock()
+			return
+		}
+		b := o.queue[0]
+		o.queue = o.queue[1:]
+		o.last = time.Now()
+		o.mu.Unlock()
+
+		_, err := o.w.Write(b)
+		if err != nil {
+			return
+		}
+	}
+}
+
+type conn struct {
+	s        *Server
+	nc       net.Conn
+	r        *bufio.Reader
+	out      *outbox
+	frameMax uint32
+	channels map[uint16]*channel
+	closing  bool
+}
+
+func (c *conn) sendMethod(channel uint16, e *encoder) {
+	c.out.send(frame{typ: frameMethod, channel: channel, payload: e.bytes()}.encode())
+}
+
+// sendContent sends the header and body frames of a message
+func (c *conn) sendContent(channel uint16, m *message) {
+	header := &encoder{}
+	header.short(60).short(0).longlong(uint64(len(m.body)))
+	header.buf.Write(m.props)
+	c.out.send(frame{typ: frameHeader, channel: channel, payload: header.buf.Bytes()}.encode())
+
+	chunk := int(c.frameMax) - 8
+	for body := m.body; len(body) > 0; {
+		n := min(chunk, len(body))
+		c.out.send(frame{typ: frameBody, channel: channel, payload: body[:n]}.encode())
+		body = body[n:]
+	}
+}
+
+func (c *conn) serve() {
+	defer c.teardown()
+
+	err := c.handshake()
+	if err != nil {
+		return
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go c.heartbeats(stop)
+
+	for {
+		f, err := readFrame(c.r, c.frameMax)
+		if err != nil {
+			return
+		}
+
+		done, err := c.handleFrame(f)
+		if done {
+			return
+		}
+		if err == nil {
+			continue
+		}
+
+		amqpErr, ok := err.(*amqpError)
+		if !ok {
+			amqpErr = connectionError(amqp.FrameError, 0, 0, "%v", err)
+		}
+		if amqpErr.connection {
+			c.closing = true
+			c.sendMethod(0, method(10, 50).
+				short(amqpErr.code).shortstr(amqpErr.text).short(amqpErr.class).short(amqpErr.id))
+			continue
+		}
+		c.closeChannel(f.channel, amqpErr)
+	}
+}
+
+func (c *conn) handshake() error {
+	header := make([]byte, len(protocolHeader))
+	_, err := io.ReadFull(c.r, header)
+	if err != nil {
+		return err
+	}
+	if string(header) != string(protocolHeader) {
+		c.nc.Write(protocolHeader)
+		return fmt.Errorf("unsupported protocol header %q", header)
+	}
+
+	c.sendMethod(0, method(10, 10).
+		octet(0).octet(9).
+		table(amqp.Table{
+			"product": "amqptest",
+			"capabilities": amqp.Table{
+				"publisher_confirms":     true,
+				"basic.nack":             true,
+				"consumer_cancel_notify": true,
+				"per_consumer_qos":       true,
+			},
+		}).
+		longstr([]byte("PLAIN AMQPLAIN")).
+		longstr([]byte("en_US")))
+
+	// start-ok: any credentials are accepted
+	_, err = c.expectMethod(10, 11)
+	if err != nil {
+		return err
+	}
+
+	c.sendMethod(0, method(10, 30).short(maxChannels).long(maxFrameSize).short(heartbeat))
+	tuneOk, err := c.expectMethod(10, 31)
+	if err != nil {
+		return err
+	}
+	tuneOk.short()
+	if frameMax := tuneOk.long(); frameMax > 0 && frameMax < c.frameMax {
+		c.frameMax = frameMax
+	}
+
+	_, err = c.expectMethod(10, 40)
+	if err != nil {
+		return err
+	}
+	c.sendMethod(0, method(10, 41).shortstr(""))
+	return nil
+}
+
+func (c *conn) expectMethod(class, id uint16) (*decoder, error) {
+	for {
+		f, err := readFrame(c.r, 0)
+		if err != nil {
+			return nil, err
+		}
+		if f.typ == frameHeartbeat {
+			continue
+		}
+		d := newDecoder(f.payload)
+		gotClass, gotID := d.short(), d.short()
+		if f.typ != frameMethod || gotClass != class || gotID != id || d.err != nil {
+			return nil, fmt.Errorf("expected method %d.%d during handshake", class, id)
+		}
+		return d, nil
+	}
+}
+
+// heartbeats keeps the client's read deadline from expiring while idle
+func (c *conn) heartbeats(stop chan struct{}) {
+	interval := heartbeat * time.Second / 2
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if time.Since(c.out.idleSince()) >= interval {
+				c.out.send(frame{typ: frameHeartbeat}.encode())
+			}
+		}
+	}
+}
+
+// handleFrame processes one frame, and reports whether the connection is
+// finished
+func (c *conn) handleFrame(f frame) (bool, error) {
+	c.s.mu.Lock()
+	defer c.s.mu.Unlock()
+
+	switch f.typ {
+	case frameHeartbeat:
+		return false, nil
+
+	case frameMethod:
+		d := newDecoder(f.payload)
+		class, id := d.short(), d.short()
+		if d.err != nil {
+			return false, connectionError(amqp.FrameError, 0, 0, "short method frame")
+		}
+
+		if f.channel == 0 {
+			return c.handleConnectionMethod(class, id, d)
+		}
+		if c.closing {
+			return false, nil
+		}
+
+		ch, ok := c.channels[f.channel]
+		if class == 20 && id == 10 {
+			if ok {
+				return false, connectionError(amqp.ChannelError, class, id, "channel %d is already open", f.channel)
+			}
+			c.channels[f.channel] = newChannel(c, f.channel)
+			c.sendMethod(f.channel, method(20, 11).longstr(nil))
+			return false, nil
+		}
+		if !ok {
+			return false, connectionError(amqp.ChannelError, class, id, "channel %d is not open", f.channel)
+		}
+		return false, ch.handleMethod(class, id, d)
+
+	case frameHeader, frameBody:
+		if c.closing {
+			return false, nil
+		}
+		ch, ok := c.channels[f.channel]
+		if !ok {
+			return false, connectionError(amqp.ChannelError, 0, 0, "channel %d is not open", f.channel)
+		}
+		return false, ch.handleContent(f)
+	}
+
+	return false, connectionError(amqp.FrameError, 0, 0, "unknown frame type %d", f.typ)
+}
+
+func (c *conn) handleConnectionMethod(class, id uint16, d *decoder) (bool, error) {
+	switch {
+	case class == 10 && id == 50: // close
+		c.sendMethod(0, method(10, 51))
+		return true, nil
+
+	case class == 10 && id == 51: // close-ok
+		return true, nil
+
+	case class == 10 && (id == 60 || id == 61): // blocked, unblocked
+		return false, nil
+	}
+
+	return false, connectionError(amqp.CommandInvalid, class, id, "unexpected method %d.%d on channel 0", class, id)
+}
+
+// closeChannel reports a channel exception to the client and releases the
+// channel's resources. The client answers with close-ok.
+func (c *conn) closeChannel(id uint16, err *amqpError) {
+	c.s.mu.Lock()
+	defer c.s.mu.Unlock()
+
+	ch, ok := c.channels[id]
+	if !ok || ch.closing {
+		return
+	}
+	ch.closing = true
+	ch.release()
+	c.sendMethod(id, method(20, 40).short(err.code).shortstr(err.text).short(err.class).short(err.id))
+}
+
+// teardown releases everything the connection held once it has gone away
+func (c *conn) teardown() {
+	c.s.mu.Lock()
+	for _, ch := range c.channels {
+		ch.release()
+	}
+	c.channels = map[uint16]*channel{}
+	for _, q := range c.s.queues {
+		if q.exclusive && q.owner == c {
+			c.s.deleteQueue(q)
+		}
+	}
+	delete(c.s.conns, c)
+	c.s.dispatchAll()
+	c.s.mu.Unlock()
+
+	// let the writer flush close-ok, unless the client has stopped reading
+	c.out.close()
+	select {
+	case <-c.out.done:
+	case <-time.After(time.Second):
+	}
+	c.nc.Close()
+}
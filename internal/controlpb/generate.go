@@ -0,0 +1,5 @@
+// Package controlpb holds the generated gRPC bindings for the server's
+// control plane.
+package controlpb
+
+//go:generate buf generate --template buf.gen.yaml
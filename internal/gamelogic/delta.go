@@ -0,0 +1,87 @@
+package gamelogic
+
+import (
+	"fmt"
+	"sort"
+)
+
+// HandleDelta applies a change the server made to the player's units.
+// Deltas for other players are only reported.
+func (gs *GameState) HandleDelta(delta StateDelta) {
+	defer fmt.Println("------------------------")
+	fmt.Println()
+
+	if delta.Username != gs.GetUsername() {
+		fmt.Println("==== Enemy Activity ====")
+		for _, reason := range delta.Reasons {
+			fmt.Printf("%s %s\n", delta.Username, reason)
+		}
+		return
+	}
+
+	fmt.Println("==== Orders Carried Out ====")
+	for _, reason := range delta.Reasons {
+		fmt.Printf("You %s\n", reason)
+	}
+	err := gs.applyDelta(delta)
+	if err != nil {
+		fmt.Printf("could not record orders: %v\n", err)
+	}
+	if len(delta.Removed) > 0 {
+		fmt.Printf("You lost %d unit(s).\n", len(delta.Removed))
+	}
+}
+
+// applyDelta records the events that turn the player's units into the
+// ones delta describes
+func (gs *GameState) applyDelta(delta StateDelta) error {
+	return gs.record(gs.reconcile(delta.Reset, delta.Units, delta.Removed)...)
+}
+
+// reconcile returns the events that bring the player's units in line with
+// units, after destroying removed. With reset, units not listed are
+// destroyed too.
+func (gs *GameState) reconcile(reset bool, units []Unit, removed []int) []GameEvent {
+	current := gs.GetPlayerSnap().Units
+	events := []GameEvent{}
+
+	gone := []int{}
+	if reset {
+		listed := map[int]bool{}
+		for _, u := range units {
+			listed[u.ID] = true
+		}
+		for id := range current {
+			if !listed[id] {
+				gone = append(gone, id)
+			}
+		}
+		sort.Ints(gone)
+	}
+	if len(gone) > 0 {
+		events = append(events, GameEvent{UnitsDestroyed: &UnitsDestroyed{IDs: gone}})
+	}
+
+	for _, u := range units {
+		old, ok := current[u.ID]
+		switch {
+		case !ok || old.Rank != u.Rank:
+			events = append(events, GameEvent{UnitSpawned: &UnitSpawned{Unit: u}})
+		case old.Location != u.Location:
+			events = append(events, GameEvent{UnitMoved: &UnitMoved{ID: u.ID, From: old.Location, To: u.Location}})
+		}
+		current[u.ID] = u
+	}
+
+	destroyed := []int{}
+	for _, id := range removed {
+		if _, ok := current[id]; ok {
+			destroyed = append(destroyed, id)
+			delete(current, id)
+		}
+	}
+	if len(destroyed) > 0 {
+		events = append(events, GameEvent{UnitsDestroyed: &UnitsDestroyed{IDs: destroyed}})
+	}
+	return events
+}
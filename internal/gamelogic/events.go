@@ -0,0 +1,223 @@
+package gamelogic
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// GameEvent is one change to a GameState. Exactly one of the change fields
+// is set. Seq numbers a state's events from 1, in the order they happened.
+type GameEvent struct {
+	Seq  int
+	Time time.Time
+
+	UnitSpawned    *UnitSpawned    `json:",omitempty"`
+	UnitMoved      *UnitMoved      `json:",omitempty"`
+	UnitsDestroyed *UnitsDestroyed `json:",omitempty"`
+	GamePaused     *GamePaused     `json:",omitempty"`
+	GameResumed    *GameResumed    `json:",omitempty"`
+	UnitIDsUsed    *UnitIDsUsed    `json:",omitempty"`
+}
+
+// UnitSpawned adds a unit, or replaces the one with the same ID
+type UnitSpawned struct {
+	Unit Unit
+}
+
+type UnitMoved struct {
+	ID   int
+	From Location
+	To   Location
+}
+
+type UnitsDestroyed struct {
+	IDs []int
+}
+
+type GamePaused struct{}
+
+type GameResumed struct{}
+
+// UnitIDsUsed records that IDs up to Last have been given out, for units
+// whose spawns aren't in the log, like those restored from a save and
+// destroyed before it was made
+type UnitIDsUsed struct {
+	Last int
+}
+
+func (ev GameEvent) String() string {
+	switch {
+	case ev.UnitSpawned != nil:
+		u := ev.UnitSpawned.Unit
+		return fmt.Sprintf("unit %v spawned: %s in %s", u.ID, u.Rank, u.Location)
+	case ev.UnitMoved != nil:
+		return fmt.Sprintf("unit %v moved from %s to %s", ev.UnitMoved.ID, ev.UnitMoved.From, ev.UnitMoved.To)
+	case ev.UnitsDestroyed != nil:
+		return fmt.Sprintf("unit(s) %v destroyed", ev.UnitsDestroyed.IDs)
+	case ev.GamePaused != nil:
+		return "game paused"
+	case ev.GameResumed != nil:
+		return "game resumed"
+	case ev.UnitIDsUsed != nil:
+		return fmt.Sprintf("unit IDs up to %v used", ev.UnitIDsUsed.Last)
+	}
+	return "unknown event"
+}
+
+// Snapshot is a GameState as of its event Seq. LastUnitID is the highest
+// unit ID the player has had, destroyed units included.
+type Snapshot struct {
+	Seq        int
+	Player     Player
+	Paused     bool
+	LastUnitID int
+}
+
+// Reduce returns the state after ev. s is left as it was, so snapshots
+// can be kept along the way.
+func Reduce(s Snapshot, ev GameEvent) Snapshot {
+	units := map[int]Unit{}
+	for id, u := range s.Player.Units {
+		units[id] = u
+	}
+	next := Snapshot{
+		Seq:        ev.Seq,
+		Player:     Player{Username: s.Player.Username, Units: units},
+		Paused:     s.Paused,
+		LastUnitID: s.LastUnitID,
+	}
+
+	switch {
+	case ev.UnitSpawned != nil:
+		units[ev.UnitSpawned.Unit.ID] = ev.UnitSpawned.Unit
+		next.LastUnitID = max(next.LastUnitID, ev.UnitSpawned.Unit.ID)
+	case ev.UnitMoved != nil:
+		if u, ok := units[ev.UnitMoved.ID]; ok {
+			u.Location = ev.UnitMoved.To
+			units[u.ID] = u
+		}
+	case ev.UnitsDestroyed != nil:
+		for _, id := range ev.UnitsDestroyed.IDs {
+			delete(units, id)
+		}
+	case ev.GamePaused != nil:
+		next.Paused = true
+	case ev.GameResumed != nil:
+		next.Paused = false
+	case ev.UnitIDsUsed != nil:
+		next.LastUnitID = max(next.LastUnitID, ev.UnitIDsUsed.Last)
+	}
+	return next
+}
+
+// Replay reduces events onto an empty state for username, stopping after
+// the event numbered upTo. An upTo of 0 or less replays everything.
+func Replay(username string, events []GameEvent, upTo int) Snapshot {
+	s := Snapshot{Player: Player{Username: username, Units: map[int]Unit{}}}
+	for _, ev := range events {
+		if upTo > 0 && ev.Seq > upTo {
+			break
+		}
+		s = Reduce(s, ev)
+	}
+	return s
+}
+
+// EventStore is an append-only log of one GameState's events
+type EventStore interface {
+	Append(ev GameEvent) error
+	Events() ([]GameEvent, error)
+}
+
+// MemoryEventStore keeps events for as long as the process runs
+type MemoryEventStore struct {
+	mu     *sync.Mutex
+	events []GameEvent
+}
+
+func NewMemoryEventStore() *MemoryEventStore {
+	return &MemoryEventStore{mu: &sync.Mutex{}}
+}
+
+func (s *MemoryEventStore) Append(ev GameEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, ev)
+	return nil
+}
+
+func (s *MemoryEventStore) Events() ([]GameEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]GameEvent{}, s.events...), nil
+}
+
+// FileEventStore keeps events in a file, one JSON object per line. The
+// file is only ever appended to.
+type FileEventStore struct {
+	path string
+	mu   *sync.Mutex
+}
+
+func NewFileEventStore(path string) *FileEventStore {
+	return &FileEventStore{path: path, mu: &sync.Mutex{}}
+}
+
+// EventFileName is where a player's events in game are kept
+func EventFileName(username, game string) string {
+	return fmt.Sprintf("%s.%s.events.jsonl", username, game)
+}
+
+func (s *FileEventStore) Append(ev GameEvent) error {
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return fmt.Errorf("could not encode event: %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("could not open event log: %v", err)
+	}
+	defer f.Close()
+	_, err = f.Write(append(b, '\n'))
+	if err != nil {
+		return fmt.Errorf("could not write to event log: %v", err)
+	}
+	return nil
+}
+
+// Events reads the whole log. A missing file is an empty log.
+func (s *FileEventStore) Events() ([]GameEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return []GameEvent{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not open event log: %v", err)
+	}
+	defer f.Close()
+
+	events := []GameEvent{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		var ev GameEvent
+		err := json.Unmarshal(scanner.Bytes(), &ev)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode event log line %d: %v", line, err)
+		}
+		events = append(events, ev)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read event log: %v", err)
+	}
+	return events, nil
+}
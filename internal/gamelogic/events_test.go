@@ -0,0 +1,256 @@
+package gamelogic
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// snapshotOf is gs as a Snapshot, for comparing with replays
+func snapshotOf(gs *GameState) Snapshot {
+	player := gs.GetPlayerSnap()
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return Snapshot{Seq: gs.seq, Player: player, Paused: gs.Paused, LastUnitID: gs.LastUnitID}
+}
+
+// playTestGame puts gs through spawns, moves, losses, pauses and a resync,
+// and returns its state after each step
+func playTestGame(t *testing.T, gs *GameState) []Snapshot {
+	t.Helper()
+	steps := []func() error{
+		func() error {
+			return gs.applyDelta(StateDelta{Units: []Unit{
+				{ID: 1, Rank: RankInfantry, Location: "europe"},
+				{ID: 2, Rank: RankCavalry, Location: "europe"},
+			}})
+		},
+		func() error {
+			return gs.applyDelta(StateDelta{Units: []Unit{{ID: 3, Rank: RankArtillery, Location: "asia"}}})
+		},
+		func() error {
+			return gs.applyDelta(StateDelta{Units: []Unit{
+				{ID: 1, Rank: RankInfantry, Location: "africa"},
+				{ID: 2, Rank: RankCavalry, Location: "africa"},
+			}})
+		},
+		func() error { return gs.SetPaused(true) },
+		func() error { return gs.applyDelta(StateDelta{Removed: []int{2, 9}}) },
+		func() error { return gs.SetPaused(false) },
+		func() error {
+			// the server's sync replaces everything
+			return gs.applyDelta(StateDelta{Reset: true, Units: []Unit{
+				{ID: 3, Rank: RankArtillery, Location: "americas"},
+				{ID: 4, Rank: RankInfantry, Location: "asia"},
+			}})
+		},
+	}
+
+	snapshots := []Snapshot{}
+	for i, step := range steps {
+		err := step()
+		if err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+		snapshots = append(snapshots, snapshotOf(gs))
+	}
+	return snapshots
+}
+
+func TestReplayMatchesLiveState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), EventFileName("bob", "europe"))
+	gs, err := RebuildGameState("bob", "europe", NewFileEventStore(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps := playTestGame(t, gs)
+	live := steps[len(steps)-1]
+	if live.LastUnitID != 4 || len(live.Player.Units) != 2 {
+		t.Fatalf("live state %+v, want units 3 and 4", live)
+	}
+
+	events, err := gs.Events()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, ev := range events {
+		if ev.Seq != i+1 {
+			t.Errorf("event %d has seq %d", i, ev.Seq)
+		}
+	}
+	if got := Replay("bob", events, 0); !reflect.DeepEqual(got, live) {
+		t.Errorf("Replay = %+v, want %+v", got, live)
+	}
+
+	// a new process reading the same log ends up in the same place
+	rebuilt, err := RebuildGameState("bob", "europe", NewFileEventStore(path))
+	if err != nil {
+		t.Fatalf("RebuildGameState: %v", err)
+	}
+	if got := snapshotOf(rebuilt); !reflect.DeepEqual(got, live) {
+		t.Errorf("rebuilt %+v, want %+v", got, live)
+	}
+
+	// and carries on numbering events after it
+	err = rebuilt.SetPaused(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err = rebuilt.Events()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := events[len(events)-1]; last.Seq != live.Seq+1 || last.GamePaused == nil {
+		t.Errorf("last event %+v, want a pause numbered %d", last, live.Seq+1)
+	}
+}
+
+func TestStateAtMatchesLiveState(t *testing.T) {
+	gs := NewGameState("bob", "europe")
+	for _, want := range playTestGame(t, gs) {
+		got, err := gs.StateAt(want.Seq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("StateAt(%d) = %+v, want %+v", want.Seq, got, want)
+		}
+	}
+}
+
+func TestReduce(t *testing.T) {
+	start := Snapshot{
+		Seq: 4,
+		Player: Player{Username: "bob", Units: map[int]Unit{
+			1: {ID: 1, Rank: RankInfantry, Location: "europe"},
+			2: {ID: 2, Rank: RankCavalry, Location: "asia"},
+		}},
+		LastUnitID: 5,
+	}
+	tests := []struct {
+		name       string
+		ev         GameEvent
+		units      []Unit
+		paused     bool
+		lastUnitID int
+	}{
+		{
+			"spawned",
+			GameEvent{UnitSpawned: &UnitSpawned{Unit: Unit{ID: 6, Rank: RankArtillery, Location: "africa"}}},
+			[]Unit{{1, RankInfantry, "europe"}, {2, RankCavalry, "asia"}, {6, RankArtillery, "africa"}},
+			false, 6,
+		},
+		{
+			"respawned",
+			GameEvent{UnitSpawned: &UnitSpawned{Unit: Unit{ID: 1, Rank: RankCavalry, Location: "africa"}}},
+			[]Unit{{1, RankCavalry, "africa"}, {2, RankCavalry, "asia"}},
+			false, 5,
+		},
+		{
+			"moved",
+			GameEvent{UnitMoved: &UnitMoved{ID: 2, From: "asia", To: "americas"}},
+			[]Unit{{1, RankInfantry, "europe"}, {2, RankCavalry, "americas"}},
+			false, 5,
+		},
+		{
+			"unknown unit moved",
+			GameEvent{UnitMoved: &UnitMoved{ID: 3, From: "asia", To: "americas"}},
+			[]Unit{{1, RankInfantry, "europe"}, {2, RankCavalry, "asia"}},
+			false, 5,
+		},
+		{
+			"destroyed",
+			GameEvent{UnitsDestroyed: &UnitsDestroyed{IDs: []int{1, 3}}},
+			[]Unit{{2, RankCavalry, "asia"}},
+			false, 5,
+		},
+		{
+			"paused",
+			GameEvent{GamePaused: &GamePaused{}},
+			[]Unit{{1, RankInfantry, "europe"}, {2, RankCavalry, "asia"}},
+			true, 5,
+		},
+		{
+			"IDs used",
+			GameEvent{UnitIDsUsed: &UnitIDsUsed{Last: 9}},
+			[]Unit{{1, RankInfantry, "europe"}, {2, RankCavalry, "asia"}},
+			false, 9,
+		},
+		{
+			"fewer IDs used",
+			GameEvent{UnitIDsUsed: &UnitIDsUsed{Last: 2}},
+			[]Unit{{1, RankInfantry, "europe"}, {2, RankCavalry, "asia"}},
+			false, 5,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := start
+			before.Player.Units = map[int]Unit{}
+			for id, u := range start.Player.Units {
+				before.Player.Units[id] = u
+			}
+
+			tt.ev.Seq = 5
+			got := Reduce(before, tt.ev)
+
+			want := map[int]Unit{}
+			for _, u := range tt.units {
+				want[u.ID] = u
+			}
+			if !reflect.DeepEqual(got.Player.Units, want) {
+				t.Errorf("units = %+v, want %+v", got.Player.Units, want)
+			}
+			if got.Seq != 5 || got.Paused != tt.paused || got.LastUnitID != tt.lastUnitID {
+				t.Errorf("seq %d, paused %v, last unit ID %d; want 5, %v, %d",
+					got.Seq, got.Paused, got.LastUnitID, tt.paused, tt.lastUnitID)
+			}
+			if !reflect.DeepEqual(before, start) {
+				t.Errorf("Reduce changed its input to %+v", before)
+			}
+		})
+	}
+}
+
+func TestFileEventStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	store := NewFileEventStore(path)
+
+	events, err := store.Events()
+	if err != nil || len(events) != 0 {
+		t.Fatalf("Events of a missing log = %v, %v, want none", events, err)
+	}
+
+	written := []GameEvent{
+		{Seq: 1, UnitSpawned: &UnitSpawned{Unit: Unit{ID: 1, Rank: RankInfantry, Location: "europe"}}},
+		{Seq: 2, UnitMoved: &UnitMoved{ID: 1, From: "europe", To: "asia"}},
+		{Seq: 3, UnitsDestroyed: &UnitsDestroyed{IDs: []int{1}}},
+		{Seq: 4, GamePaused: &GamePaused{}},
+		{Seq: 5, GameResumed: &GameResumed{}},
+		{Seq: 6, UnitIDsUsed: &UnitIDsUsed{Last: 3}},
+	}
+	for _, ev := range written {
+		err := store.Append(ev)
+		if err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+	events, err = NewFileEventStore(path).Events()
+	if err != nil {
+		t.Fatalf("Events: %v", err)
+	}
+	if !reflect.DeepEqual(events, written) {
+		t.Errorf("Events = %+v, want %+v", events, written)
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("{not json\n")
+	f.Close()
+	if _, err := store.Events(); err == nil {
+		t.Error("read a corrupt log")
+	}
+}
@@ -20,16 +20,53 @@ type Unit struct {
 }
 
 type ArmyMove struct {
+	Game       string
 	Player     Player
 	Units      []Unit
 	ToLocation Location
 }
 
+// Sender is the player who published the move
+func (m ArmyMove) Sender() string {
+	return m.Player.Username
+}
+
+// RecognitionOfWar is published by the server for spectators when a move
+// starts a war. Each player holds only their units in the contested
+// location.
 type RecognitionOfWar struct {
+	Game     string
 	Attacker Player
 	Defender Player
 }
 
+// SpawnIntent asks the server for a new unit. The server picks its ID.
+type SpawnIntent struct {
+	Game     string
+	Username string
+	Location Location
+	Rank     UnitRank
+}
+
+// Sender is the player asking for the unit
+func (si SpawnIntent) Sender() string {
+	return si.Username
+}
+
+// StateDelta is a change the server made to one player's units in a game.
+// Units were added or changed, then the units in Removed were destroyed.
+// A Reset delta replaces all of the player's units with Units instead.
+// Reasons say what the player did or had done to them, e.g. "moved 2
+// unit(s) to asia".
+type StateDelta struct {
+	Game     string
+	Username string
+	Reset    bool
+	Units    []Unit
+	Removed  []int
+	Reasons  []string
+}
+
 type Location string
 
 func getAllRanks() map[UnitRank]struct{} {
@@ -7,10 +7,18 @@ import (
 	"math/rand"
 	"os"
 	"strings"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
 )
 
 func PrintClientHelp() {
 	fmt.Println("Possible commands:")
+	fmt.Println("* games")
+	fmt.Println("* create <game>")
+	fmt.Println("* join <game>")
+	fmt.Println("    example:")
+	fmt.Println("    join europe1914")
+	fmt.Println("* leave")
 	fmt.Println("* move <location> <unitID> <unitID> <unitID>...")
 	fmt.Println("    example:")
 	fmt.Println("    move asia 1")
@@ -18,6 +26,13 @@ func PrintClientHelp() {
 	fmt.Println("    example:")
 	fmt.Println("    spawn europe infantry")
 	fmt.Println("* status")
+	fmt.Println("* history")
+	fmt.Println("* replay <seq>")
+	fmt.Println("    example:")
+	fmt.Println("    replay 3")
+	fmt.Println("* save [file]")
+	fmt.Println("* load [file]")
+	fmt.Println("    without a file, your username's save file is used")
 	fmt.Println("* spam <n>")
 	fmt.Println("    example:")
 	fmt.Println("    spam 5")
@@ -33,6 +48,10 @@ func ClientWelcome() (string, error) {
 		return "", errors.New("you must enter a username. goodbye")
 	}
 	username := words[0]
+	err := routing.ValidateUsername(username)
+	if err != nil {
+		return "", err
+	}
 	fmt.Printf("Welcome, %s!\n", username)
 	PrintClientHelp()
 	return username, nil
@@ -40,12 +59,51 @@ func ClientWelcome() (string, error) {
 
 func PrintServerHelp() {
 	fmt.Println("Possible commands:")
-	fmt.Println("* pause")
-	fmt.Println("* resume")
+	fmt.Println("* pause [game]")
+	fmt.Println("* resume [game]")
+	fmt.Println("    without a game, every game is paused or resumed")
+	fmt.Println("* games")
+	fmt.Println("* broadcast <message>")
+	fmt.Println("    example:")
+	fmt.Println("    broadcast the war is over")
+	fmt.Println("* status")
+	fmt.Println("* players")
+	fmt.Println("* logs [n]")
+	fmt.Println("* issue <username>")
+	fmt.Println("    example:")
+	fmt.Println("    issue washington")
+	fmt.Println("* offenders")
 	fmt.Println("* quit")
 	fmt.Println("* help")
 }
 
+// PrintGames lists the games a lobby hosts
+func PrintGames(games []GameInfo) {
+	if len(games) == 0 {
+		fmt.Println("There are no games yet.")
+	}
+	for _, g := range games {
+		state := "running"
+		if g.Paused {
+			state = "paused"
+		}
+		fmt.Printf("* %s (%s): %d player(s) %v\n", g.ID, state, len(g.Players), g.Players)
+	}
+}
+
+// PrintLobby shows a player the lobby's reply to them
+func PrintLobby(reply LobbyReply) {
+	fmt.Println("==== Lobby ====")
+	switch reply.Action {
+	case LobbyList:
+		PrintGames(reply.Games)
+	case LobbyCreate, LobbyJoin:
+		fmt.Printf("You are playing %s.\n", reply.Game)
+	case LobbyLeave:
+		fmt.Println("You are back in the lobby.")
+	}
+}
+
 func GetInput() []string {
 	fmt.Print("> ")
 	scanner := bufio.NewScanner(os.Stdin)
@@ -2,35 +2,83 @@ package gamelogic
 
 import (
 	"sync"
+	"time"
 )
 
+// GameState is a player's view of their units in a game. It only changes
+// by recording events, which are appended to its store and reduced into
+// Player, Paused and LastUnitID, so it can always be rebuilt from the store.
 type GameState struct {
-	Player Player
-	Paused bool
-	mu     *sync.RWMutex
+	Game       string
+	Player     Player
+	Paused     bool
+	LastUnitID int
+	mu         *sync.RWMutex
+	store      EventStore
+	seq        int
 }
 
-func NewGameState(username string) *GameState {
+// NewGameState starts an empty state whose events are only kept in memory
+func NewGameState(username, game string) *GameState {
 	return &GameState{
+		Game: game,
 		Player: Player{
 			Username: username,
 			Units:    map[int]Unit{},
 		},
 		Paused: false,
 		mu:     &sync.RWMutex{},
+		store:  NewMemoryEventStore(),
 	}
 }
 
-func (gs *GameState) resumeGame() {
-	gs.mu.Lock()
-	defer gs.mu.Unlock()
-	gs.Paused = false
+// RebuildGameState replays every event in store, and records new events
+// there
+func RebuildGameState(username, game string, store EventStore) (*GameState, error) {
+	events, err := store.Events()
+	if err != nil {
+		return nil, err
+	}
+	s := Replay(username, events, 0)
+	return &GameState{
+		Game:       game,
+		Player:     s.Player,
+		Paused:     s.Paused,
+		LastUnitID: s.LastUnitID,
+		mu:         &sync.RWMutex{},
+		store:      store,
+		seq:        s.Seq,
+	}, nil
 }
 
-func (gs *GameState) pauseGame() {
+// record appends events to the store and applies them. Events after one
+// the store refuses are dropped, so the state never runs ahead of it.
+func (gs *GameState) record(events ...GameEvent) error {
 	gs.mu.Lock()
 	defer gs.mu.Unlock()
-	gs.Paused = true
+	s := Snapshot{Seq: gs.seq, Player: gs.Player, Paused: gs.Paused, LastUnitID: gs.LastUnitID}
+	for _, ev := range events {
+		ev.Seq = s.Seq + 1
+		ev.Time = time.Now()
+		err := gs.store.Append(ev)
+		if err != nil {
+			return err
+		}
+		s = Reduce(s, ev)
+		gs.seq, gs.Player, gs.Paused, gs.LastUnitID = s.Seq, s.Player, s.Paused, s.LastUnitID
+	}
+	return nil
+}
+
+// SetPaused records a pause or resume, if it changes anything
+func (gs *GameState) SetPaused(paused bool) error {
+	if gs.isPaused() == paused {
+		return nil
+	}
+	if paused {
+		return gs.record(GameEvent{GamePaused: &GamePaused{}})
+	}
+	return gs.record(GameEvent{GameResumed: &GameResumed{}})
 }
 
 func (gs *GameState) isPaused() bool {
@@ -39,26 +87,24 @@ func (gs *GameState) isPaused() bool {
 	return gs.Paused
 }
 
-func (gs *GameState) addUnit(u Unit) {
-	gs.mu.Lock()
-	defer gs.mu.Unlock()
-	gs.Player.Units[u.ID] = u
+func (gs *GameState) lastUnitID() int {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.LastUnitID
 }
 
-func (gs *GameState) removeUnitsInLocation(loc Location) {
-	gs.mu.Lock()
-	defer gs.mu.Unlock()
-	for k, v := range gs.Player.Units {
-		if v.Location == loc {
-			delete(gs.Player.Units, k)
-		}
-	}
+// Events returns everything recorded about the state, oldest first
+func (gs *GameState) Events() ([]GameEvent, error) {
+	return gs.store.Events()
 }
 
-func (gs *GameState) UpdateUnit(u Unit) {
-	gs.mu.Lock()
-	defer gs.mu.Unlock()
-	gs.Player.Units[u.ID] = u
+// StateAt rebuilds the state as it was after event seq
+func (gs *GameState) StateAt(seq int) (Snapshot, error) {
+	events, err := gs.store.Events()
+	if err != nil {
+		return Snapshot{}, err
+	}
+	return Replay(gs.GetUsername(), events, seq), nil
 }
 
 func (gs *GameState) GetUsername() string {
@@ -0,0 +1,58 @@
+package gamelogic
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// CommandHistory prints every event recorded in the player's game
+func (gs *GameState) CommandHistory() error {
+	events, err := gs.Events()
+	if err != nil {
+		return err
+	}
+	if len(events) == 0 {
+		fmt.Println("Nothing has happened yet.")
+		return nil
+	}
+	for _, ev := range events {
+		fmt.Printf("%4d %s %s\n", ev.Seq, ev.Time.Format("15:04:05"), ev)
+	}
+	return nil
+}
+
+// CommandReplay prints the state as it was after an earlier event. The
+// current state is left alone.
+func (gs *GameState) CommandReplay(words []string) error {
+	if len(words) < 2 {
+		return errors.New("usage: replay <seq>")
+	}
+	seq, err := strconv.Atoi(words[1])
+	if err != nil || seq < 1 {
+		return fmt.Errorf("error: %s is not a valid event number", words[1])
+	}
+
+	s, err := gs.StateAt(seq)
+	if err != nil {
+		return err
+	}
+	if s.Seq < seq {
+		return fmt.Errorf("error: there is no event %d, the last is %d", seq, s.Seq)
+	}
+
+	fmt.Printf("After event %d:\n", s.Seq)
+	if s.Paused {
+		fmt.Println("The game was paused.")
+	}
+	units := make([]Unit, 0, len(s.Player.Units))
+	for _, u := range s.Player.Units {
+		units = append(units, u)
+	}
+	sortUnits(units)
+	fmt.Printf("You had %d units.\n", len(units))
+	for _, unit := range units {
+		fmt.Printf("* %v: %v, %v\n", unit.ID, unit.Location, unit.Rank)
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+package gamelogic
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// LobbyAction is what a player asks the lobby to do
+type LobbyAction string
+
+const (
+	LobbyList   LobbyAction = "list"
+	LobbyCreate LobbyAction = "create"
+	LobbyJoin   LobbyAction = "join"
+	LobbyLeave  LobbyAction = "leave"
+)
+
+// LobbyRequest asks the server to list, create, join or leave a game.
+// Game is ignored for list and leave.
+type LobbyRequest struct {
+	Username string
+	Action   LobbyAction
+	Game     string
+}
+
+// Sender is the player making the request
+func (r LobbyRequest) Sender() string {
+	return r.Username
+}
+
+// GameInfo describes a game in the lobby
+type GameInfo struct {
+	ID      string
+	Players []string
+	Paused  bool
+}
+
+// LobbyReply answers a LobbyRequest. Game is the game the player is in
+// afterwards, if any, and Games is every game the server hosts.
+type LobbyReply struct {
+	Username string
+	Action   LobbyAction
+	Game     string
+	Games    []GameInfo
+	Error    string
+}
+
+// Lobby is every game the server hosts and who is playing which. A player
+// is in at most one game at a time.
+type Lobby struct {
+	mu      *sync.Mutex
+	worlds  map[string]*World
+	players map[string]map[string]bool
+	playing map[string]string
+}
+
+func NewLobby() *Lobby {
+	return &Lobby{
+		mu:      &sync.Mutex{},
+		worlds:  map[string]*World{},
+		players: map[string]map[string]bool{},
+		playing: map[string]string{},
+	}
+}
+
+// Handle carries out req. Failures are reported in the reply rather than
+// returned, since they're the player's to fix.
+func (l *Lobby) Handle(req LobbyRequest) LobbyReply {
+	var err error
+	switch req.Action {
+	case LobbyList:
+	case LobbyCreate:
+		err = l.Create(req.Game)
+		if err == nil {
+			err = l.Join(req.Game, req.Username)
+		}
+	case LobbyJoin:
+		err = l.Join(req.Game, req.Username)
+	case LobbyLeave:
+		l.Leave(req.Username)
+	default:
+		err = fmt.Errorf("unknown lobby action %q", req.Action)
+	}
+
+	reply := LobbyReply{
+		Username: req.Username,
+		Action:   req.Action,
+		Game:     l.GameOf(req.Username),
+		Games:    l.Games(),
+	}
+	if err != nil {
+		reply.Error = err.Error()
+	}
+	return reply
+}
+
+// Create starts an empty game
+func (l *Lobby) Create(game string) error {
+	err := routing.ValidateGameID(game)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.worlds[game]; ok {
+		return fmt.Errorf("game %s already exists", game)
+	}
+	l.worlds[game] = NewWorld(game)
+	l.players[game] = map[string]bool{}
+	return nil
+}
+
+// Join moves username into game, leaving whatever game they were in. Their
+// units in a game stay there while they're away.
+func (l *Lobby) Join(game, username string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.worlds[game]; !ok {
+		return fmt.Errorf("game %s doesn't exist", game)
+	}
+	l.leave(username)
+	l.players[game][username] = true
+	l.playing[username] = game
+	return nil
+}
+
+// Leave takes username out of their game and returns it, or "" if they
+// weren't in one
+func (l *Lobby) Leave(username string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.leave(username)
+}
+
+// leave is Leave with l.mu held
+func (l *Lobby) leave(username string) string {
+	game, ok := l.playing[username]
+	if !ok {
+		return ""
+	}
+	delete(l.players[game], username)
+	delete(l.playing, username)
+	return game
+}
+
+// GameOf returns the game username is in, or ""
+func (l *Lobby) GameOf(username string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.playing[username]
+}
+
+// World returns game's world
+func (l *Lobby) World(game string) (*World, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	w, ok := l.worlds[game]
+	return w, ok
+}
+
+// Games lists every game, sorted by ID
+func (l *Lobby) Games() []GameInfo {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	games := make([]GameInfo, 0, len(l.worlds))
+	for id, w := range l.worlds {
+		info := GameInfo{ID: id, Players: []string{}, Paused: w.Paused()}
+		for username := range l.players[id] {
+			info.Players = append(info.Players, username)
+		}
+		sort.Strings(info.Players)
+		games = append(games, info)
+	}
+	sort.Slice(games, func(i, j int) bool {
+		return games[i].ID < games[j].ID
+	})
+	return games
+}
+
+// SetPaused pauses or resumes game, or every game if game is ""
+func (l *Lobby) SetPaused(game string, paused bool) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if game == "" {
+		for _, w := range l.worlds {
+			w.SetPaused(paused)
+		}
+		return nil
+	}
+	w, ok := l.worlds[game]
+	if !ok {
+		return fmt.Errorf("game %s doesn't exist", game)
+	}
+	w.SetPaused(paused)
+	return nil
+}
+
+// Spawn carries out intent in its game, if the player is playing it
+func (l *Lobby) Spawn(intent SpawnIntent) (StateDelta, error) {
+	w, err := l.member(intent.Game, intent.Username)
+	if err != nil {
+		return StateDelta{}, rejectSpawn(intent, RejectNotInGame, "%v", err)
+	}
+	return w.Spawn(intent)
+}
+
+// Move carries out move in its game, if the player is playing it
+func (l *Lobby) Move(move ArmyMove) (MoveResult, error) {
+	w, err := l.member(move.Game, move.Player.Username)
+	if err != nil {
+		return MoveResult{}, rejectMove(move, RejectNotInGame, "%v", err)
+	}
+	return w.Move(move)
+}
+
+// member returns game's world if username is playing it. A player who
+// isn't in any game is playing "", which is never a world.
+func (l *Lobby) member(game, username string) (*World, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	w, ok := l.worlds[game]
+	if !ok {
+		return nil, fmt.Errorf("game %q doesn't exist", game)
+	}
+	if l.playing[username] != game {
+		return nil, errors.New(username + " is not playing " + game)
+	}
+	return w, nil
+}
@@ -0,0 +1,44 @@
+package gamelogic
+
+import "testing"
+
+func TestLobbyRefusesPlayersOutsideGames(t *testing.T) {
+	l := NewLobby()
+	err := l.Create("europe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = l.Join("europe", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// bob isn't in any game, which used to look like playing ""
+	tests := []struct {
+		name     string
+		game     string
+		username string
+	}{
+		{"no game", "", "bob"},
+		{"unknown game", "asia", "bob"},
+		{"someone else's game", "europe", "bob"},
+		{"another game than theirs", "", "alice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := l.Spawn(SpawnIntent{Game: tt.game, Username: tt.username, Location: "europe", Rank: RankInfantry})
+			if err == nil {
+				t.Error("Spawn succeeded")
+			}
+			_, err = l.Move(ArmyMove{Game: tt.game, Player: Player{Username: tt.username}, ToLocation: "asia"})
+			if err == nil {
+				t.Error("Move succeeded")
+			}
+		})
+	}
+
+	_, err = l.Spawn(SpawnIntent{Game: "europe", Username: "alice", Location: "europe", Rank: RankInfantry})
+	if err != nil {
+		t.Errorf("Spawn by a player in the game: %v", err)
+	}
+}
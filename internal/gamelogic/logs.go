@@ -1,6 +1,8 @@
 package gamelogic
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -30,3 +32,28 @@ func WriteLog(gamelog routing.GameLog) error {
 	}
 	return nil
 }
+
+// ReadLogs returns the last n lines of the logs file, oldest first
+func ReadLogs(n int) ([]string, error) {
+	f, err := os.Open(logsFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not open logs file: %v", err)
+	}
+	defer f.Close()
+
+	lines := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+		if len(lines) > n {
+			lines = lines[1:]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read logs file: %v", err)
+	}
+	return lines, nil
+}
@@ -11,9 +11,11 @@ func (gs *GameState) HandlePause(ps routing.PlayingState) {
 	fmt.Println()
 	if ps.IsPaused {
 		fmt.Println("==== Pause Detected ====")
-		gs.pauseGame()
 	} else {
 		fmt.Println("==== Resume Detected ====")
-		gs.resumeGame()
+	}
+	err := gs.SetPaused(ps.IsPaused)
+	if err != nil {
+		fmt.Printf("could not record pause: %v\n", err)
 	}
 }
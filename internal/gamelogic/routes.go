@@ -0,0 +1,52 @@
+package gamelogic
+
+import (
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// armyMoveV1 is ArmyMove as it was before the server hosted several games
+type armyMoveV1 struct {
+	Player     Player
+	Units      []Unit
+	ToLocation Location
+}
+
+// game messages are registered here since routing can't import gamelogic.
+// Moves, spawns and lobby requests are requests the server must not lose;
+// wars, deltas and replies are its answers, which only matter to whoever
+// is listening.
+func init() {
+	routing.Register(routing.ExchangePerilTopic, routing.ArmyMovesPattern, routing.JSON, pubsub.Durable, func(m ArmyMove) (string, error) {
+		return routing.ArmyMoveKey(m.Game, m.Player.Username)
+	})
+	// version 2 added Game. Clients that haven't upgraded still publish
+	// version 1 moves to army_moves.*, so they're upcast without one; no
+	// world will carry them out, but they're dead-lettered rather than lost.
+	routing.RegisterVersion[ArmyMove](2)
+	routing.RegisterUpcaster(1, func(old armyMoveV1) (ArmyMove, error) {
+		return ArmyMove{Player: old.Player, Units: old.Units, ToLocation: old.ToLocation}, nil
+	})
+	routing.RegisterLegacyRoute[ArmyMove](1, routing.ExchangePerilTopic, routing.ArmyMovesPrefix+".*")
+	routing.Register(routing.ExchangePerilTopic, routing.SpawnsPattern, routing.JSON, pubsub.Durable, func(si SpawnIntent) (string, error) {
+		return routing.SpawnKey(si.Game, si.Username)
+	})
+	routing.Register(routing.ExchangePerilTopic, routing.WarRecognitionsPattern, routing.JSON, pubsub.Transient, func(rw RecognitionOfWar) (string, error) {
+		return routing.WarKey(rw.Game, rw.Defender.Username)
+	})
+	// version 2 added Game. Only the server declares wars now, so no
+	// version 1 payloads are left once it's upgraded.
+	routing.RegisterVersion[RecognitionOfWar](2)
+	routing.Register(routing.ExchangePerilTopic, routing.DeltasPattern, routing.JSON, pubsub.Transient, func(d StateDelta) (string, error) {
+		return routing.DeltaKey(d.Game, d.Username)
+	})
+	routing.Register(routing.ExchangePerilTopic, routing.RejectionsPattern, routing.JSON, pubsub.Transient, func(r Rejection) (string, error) {
+		return routing.RejectionKey(r.Game, r.Username)
+	})
+	routing.Register(routing.ExchangePerilTopic, routing.LobbyPattern, routing.JSON, pubsub.Durable, func(r LobbyRequest) (string, error) {
+		return routing.LobbyKey(r.Username)
+	})
+	routing.Register(routing.ExchangePerilTopic, routing.LobbyRepliesPattern, routing.JSON, pubsub.Transient, func(r LobbyReply) (string, error) {
+		return routing.LobbyReplyKey(r.Username)
+	})
+}
@@ -0,0 +1,109 @@
+package gamelogic
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// saveVersion is the save file layout this build writes. Bump it when the
+// layout changes, and teach ReadSave to read the old one.
+const saveVersion = 2
+
+// SaveFile is a GameState on disk. LastUnitID is kept because the units
+// alone don't say which IDs destroyed units had; the server's sync on
+// rejoining the game still has the final say on the units themselves.
+type SaveFile struct {
+	Version    int
+	SavedAt    time.Time
+	Game       string
+	Paused     bool
+	Player     Player
+	LastUnitID int
+}
+
+// SaveFileName is where a player's game is saved when no file is given
+func SaveFileName(username string) string {
+	return username + ".peril.json"
+}
+
+// Save writes the state to path, replacing it atomically so a crash can't
+// leave half a save behind
+func (gs *GameState) Save(path string) error {
+	b, err := json.MarshalIndent(SaveFile{
+		Version:    saveVersion,
+		SavedAt:    time.Now(),
+		Game:       gs.Game,
+		Paused:     gs.isPaused(),
+		Player:     gs.GetPlayerSnap(),
+		LastUnitID: gs.lastUnitID(),
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode game state: %v", err)
+	}
+
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, b, 0o644)
+	if err != nil {
+		return fmt.Errorf("could not write save file: %v", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// ReadSave reads a save written by Save
+func ReadSave(path string) (SaveFile, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return SaveFile{}, fmt.Errorf("could not read save file: %v", err)
+	}
+
+	var save SaveFile
+	err = json.Unmarshal(b, &save)
+	if err != nil {
+		return SaveFile{}, fmt.Errorf("could not decode save file: %v", err)
+	}
+	if save.Version < 1 || save.Version > saveVersion {
+		return SaveFile{}, fmt.Errorf("save file version %d is not supported, expected %d", save.Version, saveVersion)
+	}
+	if save.Player.Username == "" || save.Game == "" {
+		return SaveFile{}, fmt.Errorf("save file %s has no player or game", path)
+	}
+
+	// version 1 had no ID counter, and it's never below a unit's ID, so the
+	// highest one left stands in for it
+	for id := range save.Player.Units {
+		save.LastUnitID = max(save.LastUnitID, id)
+	}
+	return save, nil
+}
+
+// RestoreGameState rebuilds the save's game from store, then records
+// whatever events it takes to get back to the saved units, ID counter and
+// pause, so loading a save is part of the history like anything else
+func RestoreGameState(save SaveFile, store EventStore) (*GameState, error) {
+	gs, err := RebuildGameState(save.Player.Username, save.Game, store)
+	if err != nil {
+		return nil, err
+	}
+	units := make([]Unit, 0, len(save.Player.Units))
+	for _, u := range save.Player.Units {
+		units = append(units, u)
+	}
+	sortUnits(units)
+	err = gs.record(gs.reconcile(true, units, nil)...)
+	if err != nil {
+		return nil, err
+	}
+	if save.LastUnitID > gs.lastUnitID() {
+		err = gs.record(GameEvent{UnitIDsUsed: &UnitIDsUsed{Last: save.LastUnitID}})
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = gs.SetPaused(save.Paused)
+	if err != nil {
+		return nil, err
+	}
+	return gs, nil
+}
@@ -0,0 +1,167 @@
+package gamelogic
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// newTestState is bob in europe with units 1 and 2 left after unit 3 was
+// destroyed, and the game paused
+func newTestState(t *testing.T) *GameState {
+	t.Helper()
+	gs := NewGameState("bob", "europe")
+	err := gs.applyDelta(StateDelta{Game: "europe", Username: "bob", Units: []Unit{
+		{ID: 1, Rank: RankInfantry, Location: "europe"},
+		{ID: 2, Rank: RankCavalry, Location: "asia"},
+		{ID: 3, Rank: RankArtillery, Location: "asia"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = gs.applyDelta(StateDelta{Game: "europe", Username: "bob", Removed: []int{3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = gs.SetPaused(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gs
+}
+
+func TestSaveRoundTrip(t *testing.T) {
+	gs := newTestState(t)
+	path := filepath.Join(t.TempDir(), SaveFileName("bob"))
+	err := gs.Save(path)
+	if err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if _, err := os.Stat(path + ".tmp"); err == nil {
+		t.Error("Save left its temporary file behind")
+	}
+
+	save, err := ReadSave(path)
+	if err != nil {
+		t.Fatalf("ReadSave: %v", err)
+	}
+	if save.Version != saveVersion || save.Game != "europe" || !save.Paused || save.LastUnitID != 3 {
+		t.Errorf("save = %+v, want version %d of paused europe with last unit ID 3", save, saveVersion)
+	}
+	if !reflect.DeepEqual(save.Player, gs.GetPlayerSnap()) {
+		t.Errorf("saved player %+v, want %+v", save.Player, gs.GetPlayerSnap())
+	}
+
+	restored, err := RestoreGameState(save, NewMemoryEventStore())
+	if err != nil {
+		t.Fatalf("RestoreGameState: %v", err)
+	}
+	if !reflect.DeepEqual(restored.GetPlayerSnap(), gs.GetPlayerSnap()) {
+		t.Errorf("restored player %+v, want %+v", restored.GetPlayerSnap(), gs.GetPlayerSnap())
+	}
+	if !restored.isPaused() {
+		t.Error("restored game isn't paused")
+	}
+	if restored.lastUnitID() != 3 {
+		t.Errorf("restored last unit ID %d, want 3", restored.lastUnitID())
+	}
+}
+
+// restoring records events like anything else, so the log alone rebuilds
+// the restored state, counter included
+func TestRestoreIsRecorded(t *testing.T) {
+	path := filepath.Join(t.TempDir(), EventFileName("bob", "europe"))
+	store := NewFileEventStore(path)
+
+	// the log already has a unit the save doesn't, and lacks the save's
+	gs, err := RebuildGameState("bob", "europe", store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = gs.applyDelta(StateDelta{Game: "europe", Username: "bob", Units: []Unit{
+		{ID: 1, Rank: RankInfantry, Location: "americas"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	save := SaveFile{
+		Version: saveVersion,
+		Game:    "europe",
+		Paused:  true,
+		Player: Player{Username: "bob", Units: map[int]Unit{
+			5: {ID: 5, Rank: RankCavalry, Location: "africa"},
+		}},
+		LastUnitID: 7,
+	}
+	restored, err := RestoreGameState(save, store)
+	if err != nil {
+		t.Fatalf("RestoreGameState: %v", err)
+	}
+	if !reflect.DeepEqual(restored.GetPlayerSnap(), save.Player) {
+		t.Errorf("restored player %+v, want %+v", restored.GetPlayerSnap(), save.Player)
+	}
+
+	rebuilt, err := RebuildGameState("bob", "europe", NewFileEventStore(path))
+	if err != nil {
+		t.Fatalf("RebuildGameState: %v", err)
+	}
+	if !reflect.DeepEqual(rebuilt.GetPlayerSnap(), save.Player) || !rebuilt.isPaused() || rebuilt.lastUnitID() != 7 {
+		t.Errorf("rebuilt %+v paused %v with last unit ID %d, want the save",
+			rebuilt.GetPlayerSnap(), rebuilt.isPaused(), rebuilt.lastUnitID())
+	}
+}
+
+func TestReadSaveRefuses(t *testing.T) {
+	tests := []struct {
+		name string
+		save string
+	}{
+		{"not JSON", `not a save`},
+		{"no version", `{"Game":"europe","Player":{"Username":"bob"}}`},
+		{"newer version", fmt.Sprintf(`{"Version":%d,"Game":"europe","Player":{"Username":"bob"}}`, saveVersion+1)},
+		{"no game", fmt.Sprintf(`{"Version":%d,"Player":{"Username":"bob"}}`, saveVersion)},
+		{"no player", fmt.Sprintf(`{"Version":%d,"Game":"europe"}`, saveVersion)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "save.json")
+			err := os.WriteFile(path, []byte(tt.save), 0o644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if save, err := ReadSave(path); err == nil {
+				t.Errorf("ReadSave = %+v", save)
+			}
+		})
+	}
+
+	if _, err := ReadSave(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("ReadSave read a missing file")
+	}
+}
+
+func TestReadSaveV1(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "save.json")
+	err := os.WriteFile(path, []byte(`{
+		"Version": 1,
+		"Game": "europe",
+		"Player": {"Username": "bob", "Units": {
+			"2": {"ID": 2, "Rank": "infantry", "Location": "europe"},
+			"4": {"ID": 4, "Rank": "cavalry", "Location": "asia"}
+		}}
+	}`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	save, err := ReadSave(path)
+	if err != nil {
+		t.Fatalf("ReadSave: %v", err)
+	}
+	if save.LastUnitID != 4 || len(save.Player.Units) != 2 {
+		t.Errorf("save = %+v, want 2 units and last unit ID 4", save)
+	}
+}
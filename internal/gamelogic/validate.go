@@ -0,0 +1,102 @@
+package gamelogic
+
+import (
+	"fmt"
+)
+
+// RejectReason is why the server refused a player's request
+type RejectReason string
+
+const (
+	RejectPaused          RejectReason = "paused"
+	RejectUnknownLocation RejectReason = "unknown_location"
+	RejectUnknownRank     RejectReason = "unknown_rank"
+	RejectNoUnits         RejectReason = "no_units"
+	RejectNotOwner        RejectReason = "not_owner"
+	RejectDuplicateUnit   RejectReason = "duplicate_unit"
+	RejectMismatchedUnit  RejectReason = "mismatched_unit"
+	RejectAlreadyThere    RejectReason = "already_there"
+	RejectNotInGame       RejectReason = "not_in_game"
+)
+
+// Rejection is a request the server refused. It is both the error the
+// validators return and the message published back to the player.
+type Rejection struct {
+	Game     string
+	Username string
+	Request  string
+	Reason   RejectReason
+	Detail   string
+}
+
+func (r *Rejection) Error() string {
+	return fmt.Sprintf("%s rejected (%s): %s", r.Request, r.Reason, r.Detail)
+}
+
+func rejectMove(move ArmyMove, reason RejectReason, format string, args ...any) *Rejection {
+	return &Rejection{
+		Game:     move.Game,
+		Username: move.Player.Username,
+		Request:  "move",
+		Reason:   reason,
+		Detail:   fmt.Sprintf(format, args...),
+	}
+}
+
+func rejectSpawn(intent SpawnIntent, reason RejectReason, format string, args ...any) *Rejection {
+	return &Rejection{
+		Game:     intent.Game,
+		Username: intent.Username,
+		Request:  "spawn",
+		Reason:   reason,
+		Detail:   fmt.Sprintf(format, args...),
+	}
+}
+
+// ValidateMove checks move against owner, the server's copy of the moving
+// player. The move may only name units owner has, once each, as the rank
+// they really are, and none of them may already be at the destination.
+// Moves aren't allowed while the game is paused.
+func ValidateMove(owner Player, paused bool, move ArmyMove) error {
+	if paused {
+		return rejectMove(move, RejectPaused, "the game is paused, you can not move units")
+	}
+	if _, ok := getAllLocations()[move.ToLocation]; !ok {
+		return rejectMove(move, RejectUnknownLocation, "%s is not a valid location", move.ToLocation)
+	}
+	if len(move.Units) == 0 {
+		return rejectMove(move, RejectNoUnits, "a move needs at least one unit")
+	}
+
+	seen := map[int]bool{}
+	for _, claimed := range move.Units {
+		if seen[claimed.ID] {
+			return rejectMove(move, RejectDuplicateUnit, "unit %v is listed more than once", claimed.ID)
+		}
+		seen[claimed.ID] = true
+
+		u, ok := owner.Units[claimed.ID]
+		if !ok {
+			return rejectMove(move, RejectNotOwner, "%s has no unit with ID %v", owner.Username, claimed.ID)
+		}
+		if claimed.Rank != u.Rank {
+			return rejectMove(move, RejectMismatchedUnit, "unit %v is a(n) %s, not a(n) %s", u.ID, u.Rank, claimed.Rank)
+		}
+		if u.Location == move.ToLocation {
+			return rejectMove(move, RejectAlreadyThere, "unit %v is already in %s", u.ID, u.Location)
+		}
+	}
+	return nil
+}
+
+// ValidateSpawn checks that intent asks for a real rank in a real location.
+// Units may be spawned while the game is paused.
+func ValidateSpawn(intent SpawnIntent) error {
+	if _, ok := getAllLocations()[intent.Location]; !ok {
+		return rejectSpawn(intent, RejectUnknownLocation, "%s is not a valid location", intent.Location)
+	}
+	if _, ok := getAllRanks()[intent.Rank]; !ok {
+		return rejectSpawn(intent, RejectUnknownRank, "%s is not a valid unit", intent.Rank)
+	}
+	return nil
+}
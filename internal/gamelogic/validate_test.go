@@ -0,0 +1,84 @@
+package gamelogic
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateMove(t *testing.T) {
+	owner := Player{Username: "bob", Units: map[int]Unit{
+		1: {ID: 1, Rank: RankInfantry, Location: "europe"},
+		2: {ID: 2, Rank: RankCavalry, Location: "asia"},
+	}}
+	infantry := Unit{ID: 1, Rank: RankInfantry}
+	cavalry := Unit{ID: 2, Rank: RankCavalry}
+
+	tests := []struct {
+		name   string
+		paused bool
+		units  []Unit
+		to     Location
+		want   RejectReason
+	}{
+		{"valid", false, []Unit{infantry, cavalry}, "africa", ""},
+		{"paused", true, []Unit{infantry}, "africa", RejectPaused},
+		{"unknown location", false, []Unit{infantry}, "atlantis", RejectUnknownLocation},
+		{"no units", false, nil, "africa", RejectNoUnits},
+		{"duplicate unit", false, []Unit{infantry, infantry}, "africa", RejectDuplicateUnit},
+		{"someone else's unit", false, []Unit{{ID: 3, Rank: RankInfantry}}, "africa", RejectNotOwner},
+		{"promoted unit", false, []Unit{{ID: 1, Rank: RankArtillery}}, "africa", RejectMismatchedUnit},
+		{"already there", false, []Unit{cavalry}, "asia", RejectAlreadyThere},
+		// where the mover says the unit is doesn't matter
+		{"claimed location", false, []Unit{{ID: 1, Rank: RankInfantry, Location: "asia"}}, "europe", RejectAlreadyThere},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			move := ArmyMove{Game: "europe", Player: Player{Username: "bob"}, Units: tt.units, ToLocation: tt.to}
+			err := ValidateMove(owner, tt.paused, move)
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("ValidateMove: %v", err)
+				}
+				return
+			}
+
+			var rejection *Rejection
+			if !errors.As(err, &rejection) {
+				t.Fatalf("ValidateMove = %v, want a rejection", err)
+			}
+			want := Rejection{Game: "europe", Username: "bob", Request: "move", Reason: tt.want}
+			rejection.Detail = ""
+			if *rejection != want {
+				t.Errorf("rejected with %+v, want %+v", *rejection, want)
+			}
+		})
+	}
+}
+
+func TestValidateSpawn(t *testing.T) {
+	tests := []struct {
+		name     string
+		location Location
+		rank     UnitRank
+		want     RejectReason
+	}{
+		{"valid", "europe", RankArtillery, ""},
+		{"unknown location", "atlantis", RankInfantry, RejectUnknownLocation},
+		{"unknown rank", "europe", "general", RejectUnknownRank},
+	}
+
+	for _, tt := range tests {
+		err := ValidateSpawn(SpawnIntent{Game: "europe", Username: "bob", Location: tt.location, Rank: tt.rank})
+		var rejection *Rejection
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%s: ValidateSpawn: %v", tt.name, err)
+		case tt.want == "":
+		case !errors.As(err, &rejection):
+			t.Errorf("%s: ValidateSpawn = %v, want a rejection", tt.name, err)
+		case rejection.Reason != tt.want || rejection.Request != "spawn":
+			t.Errorf("%s: rejected with %+v, want %s", tt.name, *rejection, tt.want)
+		}
+	}
+}
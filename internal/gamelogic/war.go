@@ -4,91 +4,72 @@ import (
 	"fmt"
 )
 
-type WarOutcome int
-
-const (
-	WarOutcomeNotInvolved WarOutcome = iota
-	WarOutcomeNoUnits
-	WarOutcomeYouWon
-	WarOutcomeOpponentWon
-	WarOutcomeDraw
-)
-
-func (gs *GameState) HandleWar(rw RecognitionOfWar) (outcome WarOutcome, winner string, loser string) {
-	defer fmt.Println("------------------------")
-	fmt.Println()
-	fmt.Println("==== War Declared ====")
-	fmt.Printf("%s has declared war on %s!\n", rw.Attacker.Username, rw.Defender.Username)
+// War is a battle the server fought because a move brought two players'
+// units together. Winner and Loser are empty for a draw.
+type War struct {
+	Game     string
+	Location Location
+	Attacker Player
+	Defender Player
+	Winner   string
+	Loser    string
+}
 
-	player := gs.GetPlayerSnap()
+// Recognition is the war as published to spectators
+func (w War) Recognition() RecognitionOfWar {
+	return RecognitionOfWar{Game: w.Game, Attacker: w.Attacker, Defender: w.Defender}
+}
 
-	if player.Username == rw.Defender.Username {
-		fmt.Printf("%s, you published the war.\n", player.Username)
-		return WarOutcomeNotInvolved, "", ""
+func (w War) String() string {
+	if w.Winner == "" {
+		return fmt.Sprintf("A war between %s and %s in %s resulted in a draw", w.Attacker.Username, w.Defender.Username, w.Location)
 	}
+	return fmt.Sprintf("%s won a war against %s in %s", w.Winner, w.Loser, w.Location)
+}
 
-	if player.Username != rw.Attacker.Username {
-		fmt.Printf("%s, you are not involved in this war.\n", player.Username)
-		return WarOutcomeNotInvolved, "", ""
+// fight resolves a war between the units attacker and defender have in
+// loc. The loser's units there are destroyed, or both sides' in a draw.
+func fight(attacker, defender *Player, loc Location) War {
+	war := War{
+		Location: loc,
+		Attacker: unitsIn(attacker, loc),
+		Defender: unitsIn(defender, loc),
 	}
 
-	overlappingLocation := getOverlappingLocation(rw.Attacker, rw.Defender)
-	if overlappingLocation == "" {
-		fmt.Printf("Error! No units are in the same location. No war will be fought.\n")
-		return WarOutcomeNoUnits, "", ""
+	attackerPower := unitsToPowerLevel(war.Attacker.Units)
+	defenderPower := unitsToPowerLevel(war.Defender.Units)
+	switch {
+	case attackerPower > defenderPower:
+		war.Winner, war.Loser = attacker.Username, defender.Username
+		removeUnits(defender, war.Defender.Units)
+	case defenderPower > attackerPower:
+		war.Winner, war.Loser = defender.Username, attacker.Username
+		removeUnits(attacker, war.Attacker.Units)
+	default:
+		removeUnits(attacker, war.Attacker.Units)
+		removeUnits(defender, war.Defender.Units)
 	}
+	return war
+}
 
-	attackerUnits := []Unit{}
-	defenderUnits := []Unit{}
-	for _, unit := range rw.Attacker.Units {
-		if unit.Location == overlappingLocation {
-			attackerUnits = append(attackerUnits, unit)
-		}
-	}
-	for _, unit := range rw.Defender.Units {
-		if unit.Location == overlappingLocation {
-			defenderUnits = append(defenderUnits, unit)
+// unitsIn returns a copy of p holding only its units in loc
+func unitsIn(p *Player, loc Location) Player {
+	units := map[int]Unit{}
+	for id, u := range p.Units {
+		if u.Location == loc {
+			units[id] = u
 		}
 	}
+	return Player{Username: p.Username, Units: units}
+}
 
-	fmt.Printf("%s's units:\n", rw.Attacker.Username)
-	for _, unit := range attackerUnits {
-		fmt.Printf("  * %v\n", unit.Rank)
-	}
-	fmt.Printf("%s's units:\n", rw.Defender.Username)
-	for _, unit := range defenderUnits {
-		fmt.Printf("  * %v\n", unit.Rank)
-	}
-	attackerPower := unitsToPowerLevel(attackerUnits)
-	defenderPower := unitsToPowerLevel(defenderUnits)
-	fmt.Printf("Attacker has a power level of %v\n", attackerPower)
-	fmt.Printf("Defender has a power level of %v\n", defenderPower)
-	if attackerPower > defenderPower {
-		fmt.Printf("%s has won the war!\n", rw.Attacker.Username)
-		if player.Username == rw.Defender.Username {
-			fmt.Println("You have lost the war!")
-			gs.removeUnitsInLocation(overlappingLocation)
-			fmt.Printf("Your units in %s have been killed.\n", overlappingLocation)
-			return WarOutcomeOpponentWon, rw.Attacker.Username, rw.Defender.Username
-		}
-		return WarOutcomeYouWon, rw.Attacker.Username, rw.Defender.Username
-	} else if defenderPower > attackerPower {
-		fmt.Printf("%s has won the war!\n", rw.Defender.Username)
-		if player.Username == rw.Attacker.Username {
-			fmt.Println("You have lost the war!")
-			gs.removeUnitsInLocation(overlappingLocation)
-			fmt.Printf("Your units in %s have been killed.\n", overlappingLocation)
-			return WarOutcomeOpponentWon, rw.Defender.Username, rw.Attacker.Username
-		}
-		return WarOutcomeYouWon, rw.Defender.Username, rw.Attacker.Username
+func removeUnits(p *Player, units map[int]Unit) {
+	for id := range units {
+		delete(p.Units, id)
 	}
-	fmt.Println("The war ended in a draw!")
-	fmt.Printf("Your units in %s have been killed.\n", overlappingLocation)
-	gs.removeUnitsInLocation(overlappingLocation)
-	return WarOutcomeDraw, rw.Attacker.Username, rw.Defender.Username
 }
 
-func unitsToPowerLevel(units []Unit) int {
+func unitsToPowerLevel(units map[int]Unit) int {
 	power := 0
 	for _, unit := range units {
 		if unit.Rank == RankArtillery {
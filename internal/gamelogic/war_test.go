@@ -0,0 +1,77 @@
+package gamelogic
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFight(t *testing.T) {
+	tests := []struct {
+		name     string
+		attacker []Unit
+		defender []Unit
+		winner   string
+		// units left afterwards
+		attackerLeft []int
+		defenderLeft []int
+	}{
+		{
+			"attacker wins",
+			[]Unit{{ID: 1, Rank: RankCavalry, Location: "asia"}},
+			[]Unit{{ID: 1, Rank: RankInfantry, Location: "asia"}, {ID: 2, Rank: RankInfantry, Location: "asia"}},
+			"alice", []int{1}, []int{},
+		},
+		{
+			"defender wins",
+			[]Unit{{ID: 1, Rank: RankInfantry, Location: "asia"}},
+			[]Unit{{ID: 1, Rank: RankArtillery, Location: "asia"}},
+			"bob", []int{}, []int{1},
+		},
+		{
+			"draw",
+			[]Unit{{ID: 1, Rank: RankCavalry, Location: "asia"}},
+			[]Unit{{ID: 7, Rank: RankInfantry, Location: "asia"}, {ID: 8, Rank: RankInfantry, Location: "asia"}, {ID: 9, Rank: RankInfantry, Location: "asia"}, {ID: 10, Rank: RankInfantry, Location: "asia"}, {ID: 11, Rank: RankInfantry, Location: "asia"}},
+			"", []int{}, []int{},
+		},
+		{
+			// only units in the war's location fight, or are lost
+			"elsewhere",
+			[]Unit{{ID: 1, Rank: RankInfantry, Location: "asia"}, {ID: 2, Rank: RankArtillery, Location: "europe"}},
+			[]Unit{{ID: 1, Rank: RankCavalry, Location: "asia"}, {ID: 2, Rank: RankInfantry, Location: "africa"}},
+			"bob", []int{2}, []int{1, 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attacker := &Player{Username: "alice", Units: map[int]Unit{}}
+			for _, u := range tt.attacker {
+				attacker.Units[u.ID] = u
+			}
+			defender := &Player{Username: "bob", Units: map[int]Unit{}}
+			for _, u := range tt.defender {
+				defender.Units[u.ID] = u
+			}
+			before := unitsIn(defender, "asia")
+
+			war := fight(attacker, defender, "asia")
+			if war.Winner != tt.winner {
+				t.Errorf("winner = %q, want %q", war.Winner, tt.winner)
+			}
+			if loser := map[string]string{"alice": "bob", "bob": "alice"}[tt.winner]; war.Loser != loser {
+				t.Errorf("loser = %q, want %q", war.Loser, loser)
+			}
+			if war.Location != "asia" || !reflect.DeepEqual(war.Defender, before) {
+				t.Errorf("war in %s with defender %+v, want asia and %+v", war.Location, war.Defender, before)
+			}
+			for _, side := range []struct {
+				player *Player
+				want   []int
+			}{{attacker, tt.attackerLeft}, {defender, tt.defenderLeft}} {
+				if got := unitIDs(side.player.Units); !reflect.DeepEqual(got, side.want) {
+					t.Errorf("%s has units %v left, want %v", side.player.Username, got, side.want)
+				}
+			}
+		})
+	}
+}
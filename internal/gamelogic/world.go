@@ -0,0 +1,228 @@
+package gamelogic
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// World is the server's copy of every player's units in one game, the only
+// one that counts. Players ask for spawns and moves; the world decides what
+// happens and describes it as deltas for the players to apply.
+type World struct {
+	game    string
+	mu      *sync.Mutex
+	paused  bool
+	players map[string]*Player
+	lastID  map[string]int
+}
+
+func NewWorld(game string) *World {
+	return &World{
+		game:    game,
+		mu:      &sync.Mutex{},
+		players: map[string]*Player{},
+		lastID:  map[string]int{},
+	}
+}
+
+func (w *World) SetPaused(paused bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.paused = paused
+}
+
+func (w *World) Paused() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.paused
+}
+
+// Sync returns a delta that replaces whatever units a client thinks
+// username has with the ones they really have
+func (w *World) Sync(username string) StateDelta {
+	p := w.Player(username)
+	delta := StateDelta{
+		Game:     w.game,
+		Username: username,
+		Reset:    true,
+		Units:    []Unit{},
+		Reasons:  []string{fmt.Sprintf("joined %s with %d unit(s)", w.game, len(p.Units))},
+	}
+	for _, u := range p.Units {
+		delta.Units = append(delta.Units, u)
+	}
+	sortUnits(delta.Units)
+	return delta
+}
+
+// Player returns a snapshot of username's units
+func (w *World) Player(username string) Player {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	units := map[int]Unit{}
+	if p, ok := w.players[username]; ok {
+		for id, u := range p.Units {
+			units[id] = u
+		}
+	}
+	return Player{Username: username, Units: units}
+}
+
+// player returns username's units, starting an empty army on first use.
+// w.mu must be held.
+func (w *World) player(username string) *Player {
+	p, ok := w.players[username]
+	if !ok {
+		p = &Player{Username: username, Units: map[int]Unit{}}
+		w.players[username] = p
+	}
+	return p
+}
+
+// Spawn adds the unit intent asks for. Unit IDs are never reused, even
+// after the unit is destroyed. Invalid intents return a *Rejection.
+func (w *World) Spawn(intent SpawnIntent) (StateDelta, error) {
+	err := ValidateSpawn(intent)
+	if err != nil {
+		return StateDelta{}, err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	p := w.player(intent.Username)
+	w.lastID[intent.Username]++
+	u := Unit{
+		ID:       w.lastID[intent.Username],
+		Rank:     intent.Rank,
+		Location: intent.Location,
+	}
+	p.Units[u.ID] = u
+
+	return StateDelta{
+		Game:     w.game,
+		Username: intent.Username,
+		Units:    []Unit{u},
+		Reasons:  []string{fmt.Sprintf("spawned a(n) %s in %s with id %v", u.Rank, u.Location, u.ID)},
+	}, nil
+}
+
+// MoveResult is what a move led to: the move as the world carried it out,
+// a delta for every player whose units changed, the mover's first, and the
+// wars it started
+type MoveResult struct {
+	Move   ArmyMove
+	Deltas []StateDelta
+	Wars   []War
+}
+
+// Move carries out move. Only the player's username and the unit IDs are
+// taken from it; where the units are and what they are comes from the
+// world. Moving into a location held by other players starts a war with
+// each of them in turn, for as long as the mover has units left there.
+// Moves ValidateMove refuses return a *Rejection.
+func (w *World) Move(move ArmyMove) (MoveResult, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	mover := w.player(move.Player.Username)
+	err := ValidateMove(*mover, w.paused, move)
+	if err != nil {
+		return MoveResult{}, err
+	}
+
+	moved := map[int]Unit{}
+	for _, claimed := range move.Units {
+		u := mover.Units[claimed.ID]
+		u.Location = move.ToLocation
+		moved[u.ID] = u
+	}
+
+	deltas := map[string]*StateDelta{
+		mover.Username: {
+			Game:     w.game,
+			Username: mover.Username,
+			Reasons:  []string{fmt.Sprintf("moved %v unit(s) to %s", len(moved), move.ToLocation)},
+		},
+	}
+	order := []string{mover.Username}
+	for _, u := range moved {
+		mover.Units[u.ID] = u
+		deltas[mover.Username].Units = append(deltas[mover.Username].Units, u)
+	}
+	sortUnits(deltas[mover.Username].Units)
+
+	// the mover as they are before any war, like a client's own move
+	result := MoveResult{Move: ArmyMove{
+		Player:     Player{Username: mover.Username, Units: map[int]Unit{}},
+		Units:      append([]Unit{}, deltas[mover.Username].Units...),
+		ToLocation: move.ToLocation,
+		Game:       w.game,
+	}}
+	for id, u := range mover.Units {
+		result.Move.Player.Units[id] = u
+	}
+	for _, username := range w.usernames() {
+		if username == mover.Username {
+			continue
+		}
+		defender := w.players[username]
+		if len(unitsIn(mover, move.ToLocation).Units) == 0 {
+			break
+		}
+		if len(unitsIn(defender, move.ToLocation).Units) == 0 {
+			continue
+		}
+
+		war := fight(mover, defender, move.ToLocation)
+		war.Game = w.game
+		result.Wars = append(result.Wars, war)
+
+		deltas[username] = &StateDelta{Game: w.game, Username: username}
+		order = append(order, username)
+		for _, side := range []struct {
+			player   Player
+			opponent string
+		}{
+			{war.Attacker, war.Defender.Username},
+			{war.Defender, war.Attacker.Username},
+		} {
+			d := deltas[side.player.Username]
+			switch war.Winner {
+			case "":
+				d.Reasons = append(d.Reasons, fmt.Sprintf("drew a war with %s in %s", side.opponent, war.Location))
+			case side.player.Username:
+				d.Reasons = append(d.Reasons, fmt.Sprintf("won a war against %s in %s", side.opponent, war.Location))
+				continue
+			default:
+				d.Reasons = append(d.Reasons, fmt.Sprintf("lost a war against %s in %s", side.opponent, war.Location))
+			}
+			for id := range side.player.Units {
+				d.Removed = append(d.Removed, id)
+			}
+			sort.Ints(d.Removed)
+		}
+	}
+
+	for _, username := range order {
+		result.Deltas = append(result.Deltas, *deltas[username])
+	}
+	return result, nil
+}
+
+// usernames lists the players in a fixed order, so wars are fought in the
+// same order every time. w.mu must be held.
+func (w *World) usernames() []string {
+	usernames := make([]string, 0, len(w.players))
+	for username := range w.players {
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+	return usernames
+}
+
+func sortUnits(units []Unit) {
+	sort.Slice(units, func(i, j int) bool {
+		return units[i].ID < units[j].ID
+	})
+}
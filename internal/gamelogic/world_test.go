@@ -0,0 +1,227 @@
+package gamelogic
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// newTestWorld is europe with each of spawns carried out, in order
+func newTestWorld(t *testing.T, spawns ...SpawnIntent) *World {
+	t.Helper()
+	w := NewWorld("europe")
+	for _, intent := range spawns {
+		intent.Game = "europe"
+		_, err := w.Spawn(intent)
+		if err != nil {
+			t.Fatalf("Spawn: %v", err)
+		}
+	}
+	return w
+}
+
+func unitIDs(units map[int]Unit) []int {
+	ids := []int{}
+	for id := range units {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+func TestWorldMove(t *testing.T) {
+	tests := []struct {
+		name   string
+		spawns []SpawnIntent
+		units  []Unit
+		// the deltas' players, reasons and removed units, in order
+		want []StateDelta
+		// who won each war, in order
+		winners []string
+		// everyone's units afterwards
+		left map[string][]int
+	}{
+		{
+			name:   "nobody there",
+			spawns: []SpawnIntent{{Username: "alice", Location: "europe", Rank: RankInfantry}},
+			units:  []Unit{{ID: 1, Rank: RankInfantry}},
+			want: []StateDelta{
+				{Username: "alice", Reasons: []string{"moved 1 unit(s) to asia"}},
+			},
+			winners: nil,
+			left:    map[string][]int{"alice": {1}},
+		},
+		{
+			name: "mover wins",
+			spawns: []SpawnIntent{
+				{Username: "alice", Location: "europe", Rank: RankArtillery},
+				{Username: "bob", Location: "asia", Rank: RankInfantry},
+				{Username: "bob", Location: "asia", Rank: RankCavalry},
+				{Username: "bob", Location: "africa", Rank: RankInfantry},
+			},
+			units: []Unit{{ID: 1, Rank: RankArtillery}},
+			want: []StateDelta{
+				{Username: "alice", Reasons: []string{"moved 1 unit(s) to asia", "won a war against bob in asia"}},
+				{Username: "bob", Reasons: []string{"lost a war against alice in asia"}, Removed: []int{1, 2}},
+			},
+			winners: []string{"alice"},
+			left:    map[string][]int{"alice": {1}, "bob": {3}},
+		},
+		{
+			name: "mover loses",
+			spawns: []SpawnIntent{
+				{Username: "alice", Location: "europe", Rank: RankInfantry},
+				{Username: "alice", Location: "europe", Rank: RankInfantry},
+				{Username: "alice", Location: "europe", Rank: RankCavalry},
+				{Username: "bob", Location: "asia", Rank: RankArtillery},
+			},
+			units: []Unit{{ID: 1, Rank: RankInfantry}, {ID: 2, Rank: RankInfantry}},
+			want: []StateDelta{
+				{Username: "alice", Reasons: []string{"moved 2 unit(s) to asia", "lost a war against bob in asia"}, Removed: []int{1, 2}},
+				{Username: "bob", Reasons: []string{"won a war against alice in asia"}},
+			},
+			winners: []string{"bob"},
+			left:    map[string][]int{"alice": {3}, "bob": {1}},
+		},
+		{
+			name: "draw",
+			spawns: []SpawnIntent{
+				{Username: "alice", Location: "europe", Rank: RankCavalry},
+				{Username: "bob", Location: "asia", Rank: RankCavalry},
+			},
+			units: []Unit{{ID: 1, Rank: RankCavalry}},
+			want: []StateDelta{
+				{Username: "alice", Reasons: []string{"moved 1 unit(s) to asia", "drew a war with bob in asia"}, Removed: []int{1}},
+				{Username: "bob", Reasons: []string{"drew a war with alice in asia"}, Removed: []int{1}},
+			},
+			winners: []string{""},
+			left:    map[string][]int{"alice": {}, "bob": {}},
+		},
+		{
+			name: "every defender in turn",
+			spawns: []SpawnIntent{
+				{Username: "alice", Location: "europe", Rank: RankArtillery},
+				{Username: "carol", Location: "asia", Rank: RankInfantry},
+				{Username: "bob", Location: "asia", Rank: RankInfantry},
+			},
+			units: []Unit{{ID: 1, Rank: RankArtillery}},
+			want: []StateDelta{
+				{Username: "alice", Reasons: []string{"moved 1 unit(s) to asia", "won a war against bob in asia", "won a war against carol in asia"}},
+				{Username: "bob", Reasons: []string{"lost a war against alice in asia"}, Removed: []int{1}},
+				{Username: "carol", Reasons: []string{"lost a war against alice in asia"}, Removed: []int{1}},
+			},
+			winners: []string{"alice", "alice"},
+			left:    map[string][]int{"alice": {1}, "bob": {}, "carol": {}},
+		},
+		{
+			name: "no units left for the next defender",
+			spawns: []SpawnIntent{
+				{Username: "alice", Location: "europe", Rank: RankInfantry},
+				{Username: "bob", Location: "asia", Rank: RankCavalry},
+				{Username: "carol", Location: "asia", Rank: RankInfantry},
+			},
+			units: []Unit{{ID: 1, Rank: RankInfantry}},
+			want: []StateDelta{
+				{Username: "alice", Reasons: []string{"moved 1 unit(s) to asia", "lost a war against bob in asia"}, Removed: []int{1}},
+				{Username: "bob", Reasons: []string{"won a war against alice in asia"}},
+			},
+			winners: []string{"bob"},
+			left:    map[string][]int{"alice": {}, "bob": {1}, "carol": {1}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestWorld(t, tt.spawns...)
+			result, err := w.Move(ArmyMove{Game: "europe", Player: Player{Username: "alice"}, Units: tt.units, ToLocation: "asia"})
+			if err != nil {
+				t.Fatalf("Move: %v", err)
+			}
+
+			got := []StateDelta{}
+			for _, d := range result.Deltas {
+				if d.Game != "europe" {
+					t.Errorf("delta for %s is in %q", d.Username, d.Game)
+				}
+				got = append(got, StateDelta{Username: d.Username, Reasons: d.Reasons, Removed: d.Removed})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("deltas = %+v\nwant     %+v", got, tt.want)
+			}
+
+			// the mover's delta has the moved units as the world has them
+			moved := result.Deltas[0].Units
+			if len(moved) != len(tt.units) {
+				t.Fatalf("mover's delta has units %+v, want %d", moved, len(tt.units))
+			}
+			for i, u := range moved {
+				if u.ID != tt.units[i].ID || u.Rank != tt.units[i].Rank || u.Location != "asia" {
+					t.Errorf("moved unit %+v, want %d %s in asia", u, tt.units[i].ID, tt.units[i].Rank)
+				}
+			}
+			if m := result.Move; !reflect.DeepEqual(m.Units, moved) || m.Player.Username != "alice" || m.ToLocation != "asia" || m.Game != "europe" {
+				t.Errorf("carried out move %+v, want alice's units %+v to asia in europe", m, moved)
+			}
+
+			winners := []string(nil)
+			for _, war := range result.Wars {
+				winners = append(winners, war.Winner)
+				if war.Game != "europe" || war.Location != "asia" || war.Attacker.Username != "alice" {
+					t.Errorf("war %+v, want alice attacking in europe's asia", war)
+				}
+			}
+			if !reflect.DeepEqual(winners, tt.winners) {
+				t.Errorf("war winners = %q, want %q", winners, tt.winners)
+			}
+
+			for username, want := range tt.left {
+				if got := unitIDs(w.Player(username).Units); !reflect.DeepEqual(got, want) {
+					t.Errorf("%s has units %v, want %v", username, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestWorldMoveUsesWorldUnits(t *testing.T) {
+	w := newTestWorld(t,
+		SpawnIntent{Username: "alice", Location: "europe", Rank: RankInfantry},
+		SpawnIntent{Username: "bob", Location: "asia", Rank: RankCavalry},
+	)
+
+	// the move claims the unit is in africa, which the world ignores, and
+	// sends a player with artillery, which only names who's moving
+	result, err := w.Move(ArmyMove{
+		Game:       "europe",
+		Player:     Player{Username: "alice", Units: map[int]Unit{9: {ID: 9, Rank: RankArtillery, Location: "asia"}}},
+		Units:      []Unit{{ID: 1, Rank: RankInfantry, Location: "africa"}},
+		ToLocation: "asia",
+	})
+	if err != nil {
+		t.Fatalf("Move: %v", err)
+	}
+	if len(result.Wars) != 1 || result.Wars[0].Winner != "bob" {
+		t.Fatalf("wars = %+v, want bob winning one", result.Wars)
+	}
+	if units := result.Wars[0].Attacker.Units; !reflect.DeepEqual(unitIDs(units), []int{1}) {
+		t.Errorf("attacker fought with %+v, want only unit 1", units)
+	}
+}
+
+func TestWorldMoveRejected(t *testing.T) {
+	w := newTestWorld(t, SpawnIntent{Username: "alice", Location: "europe", Rank: RankInfantry})
+	w.SetPaused(true)
+
+	result, err := w.Move(ArmyMove{Game: "europe", Player: Player{Username: "alice"}, Units: []Unit{{ID: 1, Rank: RankInfantry}}, ToLocation: "asia"})
+	var rejection *Rejection
+	if !errors.As(err, &rejection) || rejection.Reason != RejectPaused {
+		t.Fatalf("Move = %v, want a %s rejection", err, RejectPaused)
+	}
+	if len(result.Deltas) != 0 || len(result.Wars) != 0 {
+		t.Errorf("rejected move resulted in %+v", result)
+	}
+	if u := w.Player("alice").Units[1]; u.Location != "europe" {
+		t.Errorf("rejected move took unit 1 to %s", u.Location)
+	}
+}
@@ -0,0 +1,83 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// AMQPTransport is a Transport over a RabbitMQ connection
+type AMQPTransport struct {
+	conn *amqp.Connection
+	mu   *sync.Mutex
+	ch   *amqp.Channel
+}
+
+func NewAMQPTransport(conn *amqp.Connection) *AMQPTransport {
+	return &AMQPTransport{
+		conn: conn,
+		mu:   &sync.Mutex{},
+	}
+}
+
+func (t *AMQPTransport) PublishWithContext(
+	ctx context.Context,
+	exchange,
+	key string,
+	mandatory,
+	immediate bool,
+	msg amqp.Publishing,
+) error {
+	t.mu.Lock()
+	if t.ch == nil || t.ch.IsClosed() {
+		ch, err := t.conn.Channel()
+		if err != nil {
+			t.mu.Unlock()
+			return err
+		}
+		t.ch = ch
+	}
+	ch := t.ch
+	t.mu.Unlock()
+
+	return ch.PublishWithContext(ctx, exchange, key, mandatory, immediate, msg)
+}
+
+func (t *AMQPTransport) Consume(exchange, queueName, key string, simpleQueueType QueueType) (<-chan amqp.Delivery, error) {
+	// declare a queue and bind it to an exchange
+	newChan, _, err := DeclareAndBind(
+		t.conn,
+		exchange,
+		queueName,
+		key,
+		simpleQueueType,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to declare and bind a queue: %v", err)
+	}
+
+	err = newChan.Qos(prefetchCount, 0, false)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to set prefetch size")
+	}
+
+	deliveryChan, err := newChan.Consume(
+		queueName,
+		"",
+		false,
+		false,
+		false,
+		false,
+		nil,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to consume from %s: %v", queueName, err)
+	}
+	return deliveryChan, nil
+}
+
+func (t *AMQPTransport) Close() error {
+	return t.conn.Close()
+}
@@ -0,0 +1,311 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/amqptest"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	testExchange   = "peril_topic"
+	testDeadLetter = "peril_dlq"
+)
+
+// startAMQPBroker runs an in-process broker with the game's topic exchange
+// and a dead-letter queue bound to peril_dlx, as the game expects RabbitMQ
+// to be set up
+func startAMQPBroker(t *testing.T) *amqptest.Server {
+	t.Helper()
+	broker, err := amqptest.NewServer()
+	if err != nil {
+		t.Fatalf("could not start broker: %v", err)
+	}
+	t.Cleanup(func() { broker.Close() })
+
+	conn := dialAMQP(t, broker)
+	ch, err := conn.Channel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ch.Close()
+	for _, step := range []error{
+		ch.ExchangeDeclare(testExchange, amqp.ExchangeTopic, true, false, false, false, nil),
+		ch.ExchangeDeclare(deadLetterExchange, amqp.ExchangeFanout, true, false, false, false, nil),
+	} {
+		if step != nil {
+			t.Fatalf("could not declare exchange: %v", step)
+		}
+	}
+	_, err = ch.QueueDeclare(testDeadLetter, true, false, false, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ch.QueueBind(testDeadLetter, "", deadLetterExchange, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return broker
+}
+
+func dialAMQP(t *testing.T, broker *amqptest.Server) *amqp.Connection {
+	t.Helper()
+	conn, err := amqp.Dial(broker.URL())
+	if err != nil {
+		t.Fatalf("could not dial broker: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// eventually fails the test if cond isn't true within a couple of seconds
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDeclareAndBind(t *testing.T) {
+	tests := []struct {
+		name      string
+		queueType QueueType
+	}{
+		{"durable", Durable},
+		{"transient", Transient},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			broker := startAMQPBroker(t)
+			conn := dialAMQP(t, broker)
+
+			ch, q, err := DeclareAndBind(conn, testExchange, "moves_"+tt.name, "army_moves.*", tt.queueType)
+			if err != nil {
+				t.Fatalf("DeclareAndBind: %v", err)
+			}
+			defer ch.Close()
+			if q.Name != "moves_"+tt.name {
+				t.Errorf("declared %s, want moves_%s", q.Name, tt.name)
+			}
+
+			pub := NewAMQPTransport(conn)
+			for _, key := range []string{"army_moves.bob", "army_moves.europe.bob", "war.bob"} {
+				err := PublishJSON(pub, testExchange, key, key)
+				if err != nil {
+					t.Fatalf("PublishJSON(%s): %v", key, err)
+				}
+			}
+			eventually(t, "the matching message to be queued", func() bool {
+				return broker.QueueLength(q.Name) == 1
+			})
+
+			// only transient queues are exclusive to their connection
+			other := dialAMQP(t, broker)
+			otherCh, _, err := DeclareAndBind(other, testExchange, q.Name, "army_moves.*", tt.queueType)
+			var amqpErr *amqp.Error
+			if tt.queueType == Transient {
+				if !errors.As(err, &amqpErr) || amqpErr.Code != amqp.ResourceLocked {
+					t.Errorf("declaring a transient queue from another connection: err = %v, want resource locked", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("declaring a durable queue from another connection: %v", err)
+			}
+			otherCh.Close()
+		})
+	}
+}
+
+func TestDeclareAndBindMismatchedQueue(t *testing.T) {
+	broker := startAMQPBroker(t)
+	conn := dialAMQP(t, broker)
+
+	ch, _, err := DeclareAndBind(conn, testExchange, "moves", "army_moves.*", Durable)
+	if err != nil {
+		t.Fatalf("DeclareAndBind: %v", err)
+	}
+	ch.Close()
+
+	_, _, err = DeclareAndBind(conn, testExchange, "moves", "army_moves.*", Transient)
+	var amqpErr *amqp.Error
+	if !errors.As(err, &amqpErr) || amqpErr.Code != amqp.PreconditionFailed {
+		t.Errorf("redeclaring a durable queue as transient: err = %v, want precondition failed", err)
+	}
+}
+
+type amqpTestMove struct {
+	Player string
+	To     string
+}
+
+func TestSubscribeAcktypes(t *testing.T) {
+	tests := []struct {
+		name           string
+		acktype        Acktype
+		wantDeliveries int
+		wantDead       int
+	}{
+		// acked messages are gone for good
+		{"ack", Ack, 1, 0},
+		// requeued messages come back until they're acked
+		{"nack requeue", NackRequeue, 2, 0},
+		// discarded messages go to the dead-letter exchange
+		{"nack discard", NackDiscard, 1, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			broker := startAMQPBroker(t)
+			transport := NewAMQPTransport(dialAMQP(t, broker))
+
+			type delivery struct {
+				move        amqpTestMove
+				redelivered bool
+			}
+			deliveries := make(chan delivery, 10)
+			err := Subscribe(
+				transport,
+				testExchange,
+				"moves",
+				"army_moves.*",
+				Durable,
+				func(d delivery) Acktype {
+					deliveries <- d
+					// a requeued message is acked the second time round
+					if d.redelivered {
+						return Ack
+					}
+					return tt.acktype
+				},
+				func(d amqp.Delivery) (delivery, error) {
+					var move amqpTestMove
+					err := json.Unmarshal(d.Body, &move)
+					return delivery{move: move, redelivered: d.Redelivered}, err
+				},
+			)
+			if err != nil {
+				t.Fatalf("Subscribe: %v", err)
+			}
+
+			sent := amqpTestMove{Player: "bob", To: "asia"}
+			err = PublishJSON(transport, testExchange, "army_moves.bob", sent)
+			if err != nil {
+				t.Fatalf("PublishJSON: %v", err)
+			}
+
+			for i := 0; i < tt.wantDeliveries; i++ {
+				select {
+				case d := <-deliveries:
+					if d.move != sent {
+						t.Errorf("delivery %d: got %+v, want %+v", i+1, d.move, sent)
+					}
+					if d.redelivered != (i > 0) {
+						t.Errorf("delivery %d: redelivered = %v", i+1, d.redelivered)
+					}
+				case <-time.After(2 * time.Second):
+					t.Fatalf("delivery %d never arrived", i+1)
+				}
+			}
+			select {
+			case d := <-deliveries:
+				t.Errorf("unexpected extra delivery %+v", d)
+			case <-time.After(100 * time.Millisecond):
+			}
+
+			if tt.wantDead > 0 {
+				eventually(t, "the message to be dead-lettered", func() bool {
+					return broker.QueueLength(testDeadLetter) == tt.wantDead
+				})
+			}
+			if got := broker.QueueLength(testDeadLetter); got != tt.wantDead {
+				t.Errorf("%d dead-lettered messages, want %d", got, tt.wantDead)
+			}
+			if got := broker.QueueLength("moves"); got != 0 {
+				t.Errorf("%d messages left on the queue, want 0", got)
+			}
+		})
+	}
+}
+
+func TestSubscribeDiscardsUndecodable(t *testing.T) {
+	broker := startAMQPBroker(t)
+	transport := NewAMQPTransport(dialAMQP(t, broker))
+
+	called := make(chan amqpTestMove, 1)
+	err := SubscribeJSON(
+		transport,
+		testExchange,
+		"moves",
+		"army_moves.*",
+		Durable,
+		func(m amqpTestMove) Acktype {
+			called <- m
+			return Ack
+		},
+	)
+	if err != nil {
+		t.Fatalf("SubscribeJSON: %v", err)
+	}
+
+	err = transport.PublishWithContext(context.Background(), testExchange, "army_moves.bob", false, false, amqp.Publishing{
+		ContentType: "application/json",
+		Body:        []byte("{not json"),
+	})
+	if err != nil {
+		t.Fatalf("PublishWithContext: %v", err)
+	}
+
+	eventually(t, "the message to be dead-lettered", func() bool {
+		return broker.QueueLength(testDeadLetter) == 1
+	})
+	select {
+	case m := <-called:
+		t.Errorf("handler was called with %+v", m)
+	default:
+	}
+}
+
+func TestSubscribeGob(t *testing.T) {
+	broker := startAMQPBroker(t)
+	transport := NewAMQPTransport(dialAMQP(t, broker))
+
+	received := make(chan amqpTestMove, 1)
+	err := SubscribeGob(
+		transport,
+		testExchange,
+		"moves",
+		"army_moves.*",
+		Transient,
+		func(m amqpTestMove) Acktype {
+			received <- m
+			return Ack
+		},
+	)
+	if err != nil {
+		t.Fatalf("SubscribeGob: %v", err)
+	}
+
+	sent := amqpTestMove{Player: "bob", To: "asia"}
+	err = PublishGob(transport, testExchange, "army_moves.bob", sent)
+	if err != nil {
+		t.Fatalf("PublishGob: %v", err)
+	}
+	select {
+	case got := <-received:
+		if got != sent {
+			t.Errorf("got %+v, want %+v", got, sent)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler was never called")
+	}
+}
@@ -0,0 +1,311 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// CircuitBreaker opens after threshold consecutive failures and refuses
+// calls until cooldown has passed. It then lets a single probe through:
+// success closes it again, failure reopens it for another cooldown.
+type CircuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	mu        *sync.Mutex
+	state     BreakerState
+	failures  int
+	openedAt  time.Time
+	now       func() time.Time
+}
+
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		mu:        &sync.Mutex{},
+		state:     BreakerClosed,
+		now:       time.Now,
+	}
+}
+
+// Allow reports whether a call may be attempted now
+func (cb *CircuitBreaker) Allow() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case BreakerOpen:
+		if cb.now().Sub(cb.openedAt) < cb.cooldown {
+			return false
+		}
+		cb.state = BreakerHalfOpen
+		return true
+
+	case BreakerHalfOpen:
+		// a probe is already in flight
+		return false
+
+	default:
+		return true
+	}
+}
+
+func (cb *CircuitBreaker) Success() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.failures = 0
+	cb.state = BreakerClosed
+}
+
+func (cb *CircuitBreaker) Failure() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.failures++
+	if cb.state == BreakerHalfOpen || cb.failures >= cb.threshold {
+		cb.state = BreakerOpen
+		cb.openedAt = cb.now()
+	}
+}
+
+func (cb *CircuitBreaker) State() BreakerState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.state
+}
+
+var ErrBufferFull = errors.New("publish buffer is full")
+
+type pendingPublish struct {
+	exchange  string
+	key       string
+	mandatory bool
+	immediate bool
+	msg       amqp.Publishing
+}
+
+// BufferedPublisher publishes through a circuit breaker. While the breaker
+// is open, messages are held in a bounded buffer and sent in order once
+// the broker accepts publishes again.
+type BufferedPublisher struct {
+	pub      Publisher
+	breaker  *CircuitBreaker
+	capacity int
+	// sending is held while talking to the broker, which can be slow when
+	// it's down; mu only guards pending, so Pending never waits on it
+	sending *sync.Mutex
+	mu      *sync.Mutex
+	pending []pendingPublish
+}
+
+func NewBufferedPublisher(pub Publisher, breaker *CircuitBreaker, capacity int) *BufferedPublisher {
+	return &BufferedPublisher{
+		pub:      pub,
+		breaker:  breaker,
+		capacity: capacity,
+		sending:  &sync.Mutex{},
+		mu:       &sync.Mutex{},
+		pending:  []pendingPublish{},
+	}
+}
+
+// PublishWithContext publishes msg, or buffers it if the broker is
+// unavailable or another publish is still waiting on it. It only fails
+// when the buffer is full.
+func (bp *BufferedPublisher) PublishWithContext(
+	ctx context.Context,
+	exchange,
+	key string,
+	mandatory,
+	immediate bool,
+	msg amqp.Publishing,
+) error {
+	p := pendingPublish{
+		exchange:  exchange,
+		key:       key,
+		mandatory: mandatory,
+		immediate: immediate,
+		msg:       msg,
+	}
+
+	// the publish in flight, or Run, sends the buffer in order
+	if !bp.sending.TryLock() {
+		return bp.buffer(p)
+	}
+	defer bp.sending.Unlock()
+
+	// anything already buffered has to go out first to keep ordering, and
+	// msg joins the buffer before it's sent so later publishes queue
+	// behind it
+	bp.flush(ctx)
+	err := bp.buffer(p)
+	if err != nil {
+		return err
+	}
+	bp.flush(ctx)
+	return nil
+}
+
+func (bp *BufferedPublisher) buffer(p pendingPublish) error {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	if len(bp.pending) >= bp.capacity {
+		return ErrBufferFull
+	}
+	bp.pending = append(bp.pending, p)
+	return nil
+}
+
+// Pending returns the number of buffered messages
+func (bp *BufferedPublisher) Pending() int {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	return len(bp.pending)
+}
+
+// Run flushes the buffer every interval until ctx is done
+func (bp *BufferedPublisher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			bp.sending.Lock()
+			bp.flush(ctx)
+			bp.sending.Unlock()
+		}
+	}
+}
+
+// flush sends buffered messages in order until one fails. bp.sending must
+// be held; bp.mu is only taken between sends.
+func (bp *BufferedPublisher) flush(ctx context.Context) {
+	for {
+		bp.mu.Lock()
+		if len(bp.pending) == 0 {
+			bp.mu.Unlock()
+			return
+		}
+		p := bp.pending[0]
+		bp.mu.Unlock()
+
+		if !bp.try(ctx, p) {
+			return
+		}
+
+		bp.mu.Lock()
+		bp.pending = bp.pending[1:]
+		bp.mu.Unlock()
+	}
+}
+
+func (bp *BufferedPublisher) try(ctx context.Context, p pendingPublish) bool {
+	if !bp.breaker.Allow() {
+		return false
+	}
+
+	err := bp.pub.PublishWithContext(ctx, p.exchange, p.key, p.mandatory, p.immediate, p.msg)
+	if err != nil {
+		bp.breaker.Failure()
+		return false
+	}
+	bp.breaker.Success()
+	return true
+}
+
+// reconnectTimeout bounds each redial, so a probe against a broker that's
+// down fails quickly instead of waiting out the default dial timeout
+const reconnectTimeout = 3 * time.Second
+
+// ReconnectingChannel publishes on an AMQP channel, dialing a new
+// connection whenever the previous one has been lost
+type ReconnectingChannel struct {
+	url  string
+	mu   *sync.Mutex
+	conn *amqp.Connection
+	ch   *amqp.Channel
+}
+
+func NewReconnectingChannel(url string) *ReconnectingChannel {
+	return &ReconnectingChannel{
+		url: url,
+		mu:  &sync.Mutex{},
+	}
+}
+
+func (rc *ReconnectingChannel) PublishWithContext(
+	ctx context.Context,
+	exchange,
+	key string,
+	mandatory,
+	immediate bool,
+	msg amqp.Publishing,
+) error {
+	ch, err := rc.channel()
+	if err != nil {
+		return err
+	}
+	return ch.PublishWithContext(ctx, exchange, key, mandatory, immediate, msg)
+}
+
+func (rc *ReconnectingChannel) Close() error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.conn == nil {
+		return nil
+	}
+	return rc.conn.Close()
+}
+
+func (rc *ReconnectingChannel) channel() (*amqp.Channel, error) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rc.ch != nil && !rc.ch.IsClosed() {
+		return rc.ch, nil
+	}
+
+	if rc.conn == nil || rc.conn.IsClosed() {
+		conn, err := amqp.DialConfig(rc.url, amqp.Config{
+			Heartbeat: 10 * time.Second,
+			Locale:    "en_US",
+			Dial:      amqp.DefaultDial(reconnectTimeout),
+		})
+		if err != nil {
+			return nil, err
+		}
+		rc.conn = conn
+	}
+
+	ch, err := rc.conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+	rc.ch = ch
+	return ch, nil
+}
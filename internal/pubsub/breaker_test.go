@@ -0,0 +1,159 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func newTestBreaker(threshold int, cooldown time.Duration) (*CircuitBreaker, *testClock) {
+	clock := &testClock{now: time.Unix(0, 0)}
+	cb := NewCircuitBreaker(threshold, cooldown)
+	cb.now = func() time.Time { return clock.now }
+	return cb, clock
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	cb, clock := newTestBreaker(2, time.Minute)
+	check := func(step string, wantAllow bool, wantState BreakerState) {
+		t.Helper()
+		if got := cb.Allow(); got != wantAllow {
+			t.Errorf("%s: Allow = %v, want %v", step, got, wantAllow)
+		}
+		if got := cb.State(); got != wantState {
+			t.Errorf("%s: state = %s, want %s", step, got, wantState)
+		}
+	}
+
+	check("new", true, BreakerClosed)
+	cb.Failure()
+	check("one failure", true, BreakerClosed)
+	cb.Success()
+	cb.Failure()
+	check("success resets the count", true, BreakerClosed)
+	cb.Failure()
+	check("threshold", false, BreakerOpen)
+
+	clock.advance(time.Minute - time.Second)
+	check("during cooldown", false, BreakerOpen)
+	clock.advance(time.Second)
+	check("probe", true, BreakerHalfOpen)
+	check("probe in flight", false, BreakerHalfOpen)
+
+	// a failed probe reopens it straight away, below the threshold
+	cb.Failure()
+	check("failed probe", false, BreakerOpen)
+	clock.advance(time.Minute)
+	check("second probe", true, BreakerHalfOpen)
+	cb.Success()
+	check("successful probe", true, BreakerClosed)
+	cb.Failure()
+	check("failures counted afresh", true, BreakerClosed)
+}
+
+// flakyPublisher records the keys it publishes to, and fails while down
+type flakyPublisher struct {
+	mu   sync.Mutex
+	down bool
+	keys []string
+}
+
+func (p *flakyPublisher) PublishWithContext(_ context.Context, _, key string, _, _ bool, _ amqp.Publishing) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.down {
+		return errors.New("broker is down")
+	}
+	p.keys = append(p.keys, key)
+	return nil
+}
+
+func (p *flakyPublisher) setDown(down bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.down = down
+}
+
+func (p *flakyPublisher) published() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string{}, p.keys...)
+}
+
+func TestBufferedPublisher(t *testing.T) {
+	pub := &flakyPublisher{}
+	breaker, clock := newTestBreaker(1, time.Minute)
+	bp := NewBufferedPublisher(pub, breaker, 2)
+	publish := func(key string) error {
+		return bp.PublishWithContext(context.Background(), "peril_topic", key, false, false, amqp.Publishing{})
+	}
+
+	if err := publish("a"); err != nil {
+		t.Fatalf("publish a: %v", err)
+	}
+
+	// the failed publish opens the breaker and is kept for later
+	pub.setDown(true)
+	if err := publish("b"); err != nil {
+		t.Fatalf("publish b: %v", err)
+	}
+	if breaker.State() != BreakerOpen {
+		t.Errorf("breaker is %s after a failure, want open", breaker.State())
+	}
+	if err := publish("c"); err != nil {
+		t.Fatalf("publish c: %v", err)
+	}
+	if err := publish("d"); err != ErrBufferFull {
+		t.Errorf("publish d error = %v, want ErrBufferFull", err)
+	}
+	if bp.Pending() != 2 {
+		t.Errorf("%d pending, want 2", bp.Pending())
+	}
+
+	// the broker coming back isn't tried until the cooldown is over
+	pub.setDown(false)
+	if err := publish("e"); err != ErrBufferFull {
+		t.Errorf("publish e during cooldown error = %v, want ErrBufferFull", err)
+	}
+
+	// then the buffer goes out in order, ahead of the new message
+	clock.advance(time.Minute)
+	if err := publish("f"); err != nil {
+		t.Fatalf("publish f: %v", err)
+	}
+	want := []string{"a", "b", "c", "f"}
+	if got := pub.published(); !slices.Equal(got, want) {
+		t.Errorf("published %v, want %v", got, want)
+	}
+	if bp.Pending() != 0 {
+		t.Errorf("%d pending after flushing, want 0", bp.Pending())
+	}
+}
+
+func TestBufferedPublisherRunFlushes(t *testing.T) {
+	pub := &flakyPublisher{down: true}
+	breaker, clock := newTestBreaker(1, time.Minute)
+	bp := NewBufferedPublisher(pub, breaker, 10)
+	for _, key := range []string{"a", "b", "c"} {
+		err := bp.PublishWithContext(context.Background(), "peril_topic", key, false, false, amqp.Publishing{})
+		if err != nil {
+			t.Fatalf("publish %s: %v", key, err)
+		}
+	}
+
+	pub.setDown(false)
+	clock.advance(time.Minute)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go bp.Run(ctx, time.Millisecond)
+
+	eventually(t, "the buffer to be flushed", func() bool { return bp.Pending() == 0 })
+	if got := pub.published(); !slices.Equal(got, []string{"a", "b", "c"}) {
+		t.Errorf("published %v, want [a b c]", got)
+	}
+}
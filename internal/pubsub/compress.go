@@ -0,0 +1,123 @@
+package pubsub
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+type Compression int
+
+const (
+	NoCompression Compression = iota
+	Gzip
+	Zstd
+)
+
+// DefaultCompressionThreshold is the body size in bytes below which
+// compressing costs more than it saves
+const DefaultCompressionThreshold = 1024
+
+// maxDecompressedSize caps how far a delivery may expand, so a small
+// malicious message can't exhaust the subscriber's memory
+const maxDecompressedSize = 16 << 20
+
+type compression struct {
+	algorithm Compression
+	threshold int
+}
+
+// WithCompression compresses message bodies of at least threshold bytes.
+// Subscribers decompress them transparently based on ContentEncoding.
+func WithCompression(algorithm Compression, threshold int) PublishOption {
+	return func(cfg *publishConfig) {
+		cfg.compression = &compression{algorithm: algorithm, threshold: threshold}
+	}
+}
+
+var (
+	zstdEncoder = sync.OnceValues(func() (*zstd.Encoder, error) {
+		return zstd.NewWriter(nil)
+	})
+	zstdDecoder = sync.OnceValues(func() (*zstd.Decoder, error) {
+		return zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxDecompressedSize))
+	})
+)
+
+func (c compression) compress(msg *amqp.Publishing) error {
+	if len(msg.Body) < c.threshold {
+		return nil
+	}
+
+	switch c.algorithm {
+	case Gzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		_, err := w.Write(msg.Body)
+		if err != nil {
+			return err
+		}
+		err = w.Close()
+		if err != nil {
+			return err
+		}
+		msg.Body = buf.Bytes()
+		msg.ContentEncoding = "gzip"
+
+	case Zstd:
+		enc, err := zstdEncoder()
+		if err != nil {
+			return err
+		}
+		msg.Body = enc.EncodeAll(msg.Body, nil)
+		msg.ContentEncoding = "zstd"
+	}
+
+	return nil
+}
+
+// decompress undoes compress according to the delivery's ContentEncoding
+func decompress(d *amqp.Delivery) error {
+	switch d.ContentEncoding {
+	case "":
+		return nil
+
+	case "gzip":
+		r, err := gzip.NewReader(bytes.NewReader(d.Body))
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+
+		body, err := io.ReadAll(io.LimitReader(r, maxDecompressedSize+1))
+		if err != nil {
+			return err
+		}
+		if len(body) > maxDecompressedSize {
+			return fmt.Errorf("decompressed message is larger than %d bytes", maxDecompressedSize)
+		}
+		d.Body = body
+
+	case "zstd":
+		dec, err := zstdDecoder()
+		if err != nil {
+			return err
+		}
+		body, err := dec.DecodeAll(d.Body, nil)
+		if err != nil {
+			return err
+		}
+		d.Body = body
+
+	default:
+		return fmt.Errorf("unsupported content encoding %s", d.ContentEncoding)
+	}
+
+	d.ContentEncoding = ""
+	return nil
+}
@@ -0,0 +1,137 @@
+package pubsub
+
+import (
+	"bytes"
+	"compress/gzip"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestCompressRoundTrip(t *testing.T) {
+	large := []byte(strings.Repeat("army_moves.europe.bob ", 100))
+
+	tests := []struct {
+		name      string
+		algorithm Compression
+		body      []byte
+		threshold int
+		encoding  string
+	}{
+		{"gzip", Gzip, large, DefaultCompressionThreshold, "gzip"},
+		{"zstd", Zstd, large, DefaultCompressionThreshold, "zstd"},
+		{"below threshold", Gzip, large[:100], DefaultCompressionThreshold, ""},
+		{"at threshold", Zstd, large[:100], 100, "zstd"},
+		{"no compression", NoCompression, large, 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := amqp.Publishing{Body: bytes.Clone(tt.body)}
+			err := compression{algorithm: tt.algorithm, threshold: tt.threshold}.compress(&msg)
+			if err != nil {
+				t.Fatalf("compress: %v", err)
+			}
+			if msg.ContentEncoding != tt.encoding {
+				t.Errorf("ContentEncoding = %q, want %q", msg.ContentEncoding, tt.encoding)
+			}
+			if tt.encoding != "" && len(msg.Body) >= len(tt.body) {
+				t.Errorf("compressed %d bytes to %d", len(tt.body), len(msg.Body))
+			}
+
+			d := amqp.Delivery{ContentEncoding: msg.ContentEncoding, Body: msg.Body}
+			err = decompress(&d)
+			if err != nil {
+				t.Fatalf("decompress: %v", err)
+			}
+			if !bytes.Equal(d.Body, tt.body) || d.ContentEncoding != "" {
+				t.Errorf("decompressed to %d bytes with encoding %q", len(d.Body), d.ContentEncoding)
+			}
+		})
+	}
+}
+
+func TestDecompressLimitsSize(t *testing.T) {
+	tests := []struct {
+		name      string
+		algorithm Compression
+		size      int
+		wantErr   bool
+	}{
+		{"gzip at limit", Gzip, maxDecompressedSize, false},
+		{"gzip over limit", Gzip, maxDecompressedSize + 1, true},
+		{"zstd at limit", Zstd, maxDecompressedSize, false},
+		{"zstd over limit", Zstd, maxDecompressedSize + 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// zeros compress to almost nothing, like a decompression bomb
+			msg := amqp.Publishing{Body: make([]byte, tt.size)}
+			err := compression{algorithm: tt.algorithm}.compress(&msg)
+			if err != nil {
+				t.Fatalf("compress: %v", err)
+			}
+
+			d := amqp.Delivery{ContentEncoding: msg.ContentEncoding, Body: msg.Body}
+			err = decompress(&d)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decompress error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && len(d.Body) != tt.size {
+				t.Errorf("decompressed %d bytes, want %d", len(d.Body), tt.size)
+			}
+		})
+	}
+}
+
+// a streamed zstd frame doesn't declare its size up front, so the decoder
+// has to stop it while decoding
+func TestDecompressLimitsStreamedZstd(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := zstd.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for written := 0; written <= maxDecompressedSize; written += 1 << 20 {
+		_, err := w.Write(make([]byte, 1<<20))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := amqp.Delivery{ContentEncoding: "zstd", Body: buf.Bytes()}
+	if err := decompress(&d); err == nil {
+		t.Errorf("decompressed %d bytes", len(d.Body))
+	}
+}
+
+func TestDecompressRefusesBadInput(t *testing.T) {
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write([]byte("truncated"))
+	w.Close()
+
+	tests := []struct {
+		name     string
+		encoding string
+		body     []byte
+	}{
+		{"unsupported encoding", "br", []byte("body")},
+		{"not gzip", "gzip", []byte("body")},
+		{"truncated gzip", "gzip", gz.Bytes()[:gz.Len()-4]},
+		{"not zstd", "zstd", []byte("body")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := amqp.Delivery{ContentEncoding: tt.encoding, Body: tt.body}
+			if err := decompress(&d); err == nil {
+				t.Errorf("decompressed to %q", d.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,315 @@
+package pubsub
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// startBroker starts a broker for one test and returns a function dialing
+// it. Each call is a new connection, closed when the test ends.
+type startBroker func(t *testing.T) func() Transport
+
+type conformanceLog struct {
+	Username string
+	Message  string
+}
+
+func (l conformanceLog) Sender() string {
+	return l.Username
+}
+
+// testTransport checks the behaviour every transport shares, so the game
+// runs the same whichever broker it's given
+func testTransport(t *testing.T, start startBroker) {
+	for _, qt := range []QueueType{Durable, Transient} {
+		name := map[QueueType]string{Durable: "durable", Transient: "transient"}[qt]
+		t.Run("signed round trip/"+name, func(t *testing.T) {
+			testSignedRoundTrip(t, start(t), qt)
+		})
+		t.Run("acktypes/"+name, func(t *testing.T) {
+			testAcktypes(t, start, qt)
+		})
+	}
+	t.Run("durable queues share messages", func(t *testing.T) {
+		testDurableQueuesShareMessages(t, start(t))
+	})
+	t.Run("transient queues each get messages", func(t *testing.T) {
+		testTransientQueuesEachGetMessages(t, start(t))
+	})
+	t.Run("only matching queues get messages", func(t *testing.T) {
+		testOnlyMatchingQueuesGetMessages(t, start(t))
+	})
+	t.Run("# matches zero words", func(t *testing.T) {
+		testHashMatchesZeroWords(t, start(t))
+	})
+}
+
+func testSignedRoundTrip(t *testing.T, dial func() Transport, qt QueueType) {
+	sub := dial()
+	pub := dial()
+
+	_, authority, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds, err := IssueCredentials(authority, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received := make(chan conformanceLog, 1)
+	err = SubscribeJSON(
+		sub,
+		"peril_topic",
+		"game_logs",
+		"game_logs.*.*",
+		qt,
+		func(l conformanceLog) Acktype {
+			received <- l
+			return Ack
+		},
+		WithVerification(authority.Public().(ed25519.PublicKey)),
+	)
+	if err != nil {
+		t.Fatalf("SubscribeJSON: %v", err)
+	}
+
+	// the signature covers the body byte for byte and the version header,
+	// so this only arrives if the transport carries both unchanged
+	sent := conformanceLog{Username: "bob", Message: "hello"}
+	err = PublishJSON(pub, "peril_topic", "game_logs.europe.bob", sent, WithSigner(creds), WithVersion(2))
+	if err != nil {
+		t.Fatalf("PublishJSON: %v", err)
+	}
+
+	select {
+	case got := <-received:
+		if got != sent {
+			t.Errorf("handler got %+v, want %+v", got, sent)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler was never called")
+	}
+}
+
+func testAcktypes(t *testing.T, start startBroker, qt QueueType) {
+	tests := []struct {
+		name           string
+		acktype        Acktype
+		wantDeliveries int
+	}{
+		{"ack", Ack, 1},
+		{"nack requeue", NackRequeue, 2},
+		{"nack discard", NackDiscard, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport := start(t)()
+
+			redelivered := make(chan bool, 10)
+			err := Subscribe(
+				transport,
+				"peril_topic",
+				"moves",
+				"army_moves.*.*",
+				qt,
+				func(redelivery bool) Acktype {
+					redelivered <- redelivery
+					if redelivery {
+						return Ack
+					}
+					return tt.acktype
+				},
+				func(d amqp.Delivery) (bool, error) {
+					return d.Redelivered, nil
+				},
+			)
+			if err != nil {
+				t.Fatalf("Subscribe: %v", err)
+			}
+
+			err = PublishJSON(transport, "peril_topic", "army_moves.europe.bob", "move")
+			if err != nil {
+				t.Fatalf("PublishJSON: %v", err)
+			}
+
+			for i := 0; i < tt.wantDeliveries; i++ {
+				select {
+				case got := <-redelivered:
+					if got != (i > 0) {
+						t.Errorf("delivery %d: Redelivered = %v", i+1, got)
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatalf("delivery %d never arrived", i+1)
+				}
+			}
+			select {
+			case <-redelivered:
+				t.Error("unexpected extra delivery")
+			case <-time.After(200 * time.Millisecond):
+			}
+		})
+	}
+}
+
+func testDurableQueuesShareMessages(t *testing.T, dial func() Transport) {
+	pub := dial()
+
+	const messages = 10
+	received := make(chan int, 2*messages)
+	for i := 0; i < 2; i++ {
+		err := SubscribeJSON(
+			dial(),
+			"peril_topic",
+			"spawns",
+			"spawns.*.*",
+			Durable,
+			func(n int) Acktype {
+				received <- n
+				return Ack
+			},
+		)
+		if err != nil {
+			t.Fatalf("SubscribeJSON: %v", err)
+		}
+	}
+
+	for n := 0; n < messages; n++ {
+		err := PublishJSON(pub, "peril_topic", fmt.Sprintf("spawns.europe.p%d", n), n)
+		if err != nil {
+			t.Fatalf("PublishJSON: %v", err)
+		}
+	}
+
+	seen := map[int]bool{}
+	timeout := time.After(5 * time.Second)
+	for len(seen) < messages {
+		select {
+		case n := <-received:
+			if seen[n] {
+				t.Fatalf("message %d was delivered twice", n)
+			}
+			seen[n] = true
+		case <-timeout:
+			t.Fatalf("only %d of %d messages arrived", len(seen), messages)
+		}
+	}
+
+	// give a duplicate the chance to show up
+	select {
+	case n := <-received:
+		t.Errorf("message %d was delivered twice", n)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func testTransientQueuesEachGetMessages(t *testing.T, dial func() Transport) {
+	pub := dial()
+
+	received := make(chan string, 4)
+	for _, name := range []string{"alice", "bob"} {
+		err := SubscribeJSON(
+			dial(),
+			"peril_topic",
+			"pause."+name,
+			"pause.*",
+			Transient,
+			func(bool) Acktype {
+				received <- name
+				return Ack
+			},
+		)
+		if err != nil {
+			t.Fatalf("SubscribeJSON: %v", err)
+		}
+	}
+
+	err := PublishJSON(pub, "peril_topic", "pause.europe", true)
+	if err != nil {
+		t.Fatalf("PublishJSON: %v", err)
+	}
+
+	got := map[string]bool{}
+	for len(got) < 2 {
+		select {
+		case name := <-received:
+			got[name] = true
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %v got the message", got)
+		}
+	}
+}
+
+func testOnlyMatchingQueuesGetMessages(t *testing.T, dial func() Transport) {
+	transport := dial()
+
+	received := make(chan string, 4)
+	for queueName, key := range map[string]string{
+		"moves":        "army_moves.*.*",
+		"wars":         "war.#",
+		"europe_wars":  "war.europe.*",
+		"direct_pause": "pause",
+	} {
+		err := SubscribeJSON(transport, "peril_topic", queueName, key, Durable, func(string) Acktype {
+			received <- queueName
+			return Ack
+		})
+		if err != nil {
+			t.Fatalf("SubscribeJSON(%s): %v", queueName, err)
+		}
+	}
+
+	err := PublishJSON(transport, "peril_topic", "war.asia.bob", "war")
+	if err != nil {
+		t.Fatalf("PublishJSON: %v", err)
+	}
+
+	select {
+	case queueName := <-received:
+		if queueName != "wars" {
+			t.Errorf("%s got a war in asia", queueName)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the war never arrived")
+	}
+	select {
+	case queueName := <-received:
+		t.Errorf("%s got a war in asia too", queueName)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func testHashMatchesZeroWords(t *testing.T, dial func() Transport) {
+	transport := dial()
+
+	received := make(chan string, 4)
+	err := SubscribeJSON(transport, "peril_topic", "logs", "game_logs.#", Durable, func(key string) Acktype {
+		received <- key
+		return Ack
+	})
+	if err != nil {
+		t.Fatalf("SubscribeJSON: %v", err)
+	}
+
+	for _, key := range []string{"game_logs", "game_logs.europe.bob"} {
+		err := PublishJSON(transport, "peril_topic", key, key)
+		if err != nil {
+			t.Fatalf("PublishJSON(%s): %v", key, err)
+		}
+	}
+
+	got := map[string]bool{}
+	for len(got) < 2 {
+		select {
+		case key := <-received:
+			got[key] = true
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %v arrived", got)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package pubsub
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const headerKeyID = "x-peril-key-id"
+
+// Keyring looks up AES keys by ID. A key ID can name a recipient, a team or
+// a whole game, depending on who should be able to read the channel.
+type Keyring interface {
+	Key(id string) ([]byte, bool)
+}
+
+// StaticKeyring is a Keyring backed by a fixed set of keys
+type StaticKeyring map[string][]byte
+
+func (k StaticKeyring) Key(id string) ([]byte, bool) {
+	key, ok := k[id]
+	return key, ok
+}
+
+// NewEncryptionKey returns a random AES-256 key
+func NewEncryptionKey() ([]byte, error) {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+type encryption struct {
+	keyID string
+	key   []byte
+}
+
+// WithEncryption encrypts the message body with AES-GCM so only holders of
+// the key named by keyID can read it
+func WithEncryption(keyID string, key []byte) PublishOption {
+	return func(cfg *publishConfig) {
+		cfg.encryption = &encryption{keyID: keyID, key: key}
+	}
+}
+
+// WithDecryption decrypts deliveries with keys from keyring. Deliveries that
+// are not encrypted, or use a key the keyring does not hold, are rejected.
+func WithDecryption(keyring Keyring) SubscribeOption {
+	return func(cfg *subscribeConfig) {
+		cfg.keyring = keyring
+	}
+}
+
+func (e encryption) encrypt(exchange, key string, msg *amqp.Publishing) error {
+	gcm, err := newGCM(e.key)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return err
+	}
+
+	if msg.Headers == nil {
+		msg.Headers = amqp.Table{}
+	}
+	msg.Headers[headerKeyID] = e.keyID
+	msg.Body = gcm.Seal(nonce, nonce, msg.Body, additionalData(exchange, key, msg.ContentType, e.keyID))
+	return nil
+}
+
+func decrypt(keyring Keyring, d *amqp.Delivery) error {
+	keyID, ok := d.Headers[headerKeyID].(string)
+	if !ok {
+		return errors.New("message is not encrypted")
+	}
+	key, ok := keyring.Key(keyID)
+	if !ok {
+		return fmt.Errorf("no key for %s", keyID)
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+	if len(d.Body) < gcm.NonceSize() {
+		return errors.New("encrypted message is too short")
+	}
+
+	nonce, ciphertext := d.Body[:gcm.NonceSize()], d.Body[gcm.NonceSize():]
+	body, err := gcm.Open(nil, nonce, ciphertext, additionalData(d.Exchange, d.RoutingKey, d.ContentType, keyID))
+	if err != nil {
+		return fmt.Errorf("could not decrypt message: %v", err)
+	}
+	d.Body = body
+	return nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// additionalData binds the ciphertext to where it was published, so it
+// can't be replayed onto another routing key
+func additionalData(exchange, key, contentType, keyID string) []byte {
+	var b bytes.Buffer
+	for _, field := range []string{exchange, key, contentType, keyID} {
+		b.WriteString(field)
+		b.WriteByte(0)
+	}
+	return b.Bytes()
+}
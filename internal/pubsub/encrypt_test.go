@@ -0,0 +1,148 @@
+package pubsub
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func newTestKey(t *testing.T) []byte {
+	t.Helper()
+	key, err := NewEncryptionKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestDecryptionDeadLetters(t *testing.T) {
+	key := newTestKey(t)
+	otherKey := newTestKey(t)
+	// carol holds the same key under another ID
+	keyring := StaticKeyring{"bob": key, "carol": key}
+
+	tests := []struct {
+		name   string
+		opts   []PublishOption
+		tamper func(*amqp.Publishing)
+		want   bool
+	}{
+		{"encrypted", []PublishOption{WithEncryption("bob", key)}, nil, true},
+		{"not encrypted", nil, nil, false},
+		{"unknown key", []PublishOption{WithEncryption("alice", key)}, nil, false},
+		{"wrong key", []PublishOption{WithEncryption("bob", otherKey)}, nil, false},
+		{"tampered ciphertext", []PublishOption{WithEncryption("bob", key)}, func(msg *amqp.Publishing) {
+			msg.Body[len(msg.Body)-1] ^= 1
+		}, false},
+		{"another key ID", []PublishOption{WithEncryption("bob", key)}, func(msg *amqp.Publishing) {
+			// the key ID is bound to the ciphertext too
+			msg.Headers[headerKeyID] = "carol"
+		}, false},
+		{"truncated", []PublishOption{WithEncryption("bob", key)}, func(msg *amqp.Publishing) {
+			msg.Body = msg.Body[:4]
+		}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport := openTestLog(t, t.TempDir(), 1)
+			dead, err := transport.Consume(deadLetterExchange, "dlq", "#", Durable)
+			if err != nil {
+				t.Fatalf("Consume: %v", err)
+			}
+
+			received := make(chan string, 1)
+			err = SubscribeJSON(transport, "peril_direct", "whispers.bob", "whispers.bob", Durable, func(s string) Acktype {
+				received <- s
+				return Ack
+			}, WithDecryption(keyring))
+			if err != nil {
+				t.Fatalf("SubscribeJSON: %v", err)
+			}
+
+			var pub Publisher = transport
+			if tt.tamper != nil {
+				pub = tamperingPublisher{Publisher: transport, tamper: tt.tamper}
+			}
+			err = PublishJSON(pub, "peril_direct", "whispers.bob", "attack at dawn", tt.opts...)
+			if err != nil {
+				t.Fatalf("PublishJSON: %v", err)
+			}
+
+			select {
+			case s := <-received:
+				if !tt.want {
+					t.Errorf("delivered %q", s)
+				}
+				if s != "attack at dawn" {
+					t.Errorf("decrypted %q", s)
+				}
+			case d := <-dead:
+				if tt.want {
+					t.Errorf("dead-lettered %x", d.Body)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("neither delivered nor dead-lettered")
+			}
+		})
+	}
+}
+
+func TestEncryptHidesBody(t *testing.T) {
+	key := newTestKey(t)
+	msg := amqp.Publishing{ContentType: "application/json", Body: []byte(`"attack at dawn"`)}
+	err := encryption{keyID: "bob", key: key}.encrypt("peril_direct", "whispers.bob", &msg)
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+	if bytes.Contains(msg.Body, []byte("attack")) {
+		t.Errorf("ciphertext %q contains the plaintext", msg.Body)
+	}
+	if msg.Headers[headerKeyID] != "bob" {
+		t.Errorf("key ID header = %v, want bob", msg.Headers[headerKeyID])
+	}
+}
+
+// ciphertext is bound to where it was published, so a subscriber on
+// another routing key or exchange can't be handed it
+func TestDecryptRefusesReplays(t *testing.T) {
+	key := newTestKey(t)
+	keyring := StaticKeyring{"bob": key}
+
+	tests := []struct {
+		name     string
+		exchange string
+		key      string
+		wantErr  bool
+	}{
+		{"same key", "peril_direct", "whispers.bob", false},
+		{"another key", "peril_direct", "whispers.alice", true},
+		{"another exchange", "peril_topic", "whispers.bob", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := amqp.Publishing{ContentType: "application/json", Body: []byte(`"attack at dawn"`)}
+			err := encryption{keyID: "bob", key: key}.encrypt("peril_direct", "whispers.bob", &msg)
+			if err != nil {
+				t.Fatalf("encrypt: %v", err)
+			}
+
+			d := amqp.Delivery{
+				Exchange:    tt.exchange,
+				RoutingKey:  tt.key,
+				ContentType: msg.ContentType,
+				Headers:     msg.Headers,
+				Body:        msg.Body,
+			}
+			err = decrypt(keyring, &d)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decrypt error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && string(d.Body) != `"attack at dawn"` {
+				t.Errorf("decrypted %q", d.Body)
+			}
+		})
+	}
+}
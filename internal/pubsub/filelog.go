@@ -0,0 +1,652 @@
+package pubsub
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing/topic"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	// DefaultLogPartitions is how many partitions a new exchange log gets
+	DefaultLogPartitions = 8
+	// logPollInterval is how often a caught-up subscriber looks for new
+	// records
+	logPollInterval = 100 * time.Millisecond
+	// maxLogRecordSize guards against reading garbage as a huge length
+	maxLogRecordSize = 64 << 20
+)
+
+var (
+	errLogClosed = errors.New("log transport is closed")
+	// errMalformedLogRecord is a record that was written in full but can't
+	// be decoded, so it can be skipped
+	errMalformedLogRecord = errors.New("malformed log record")
+	// errLogRecordTooLarge is a length no record can have, so whatever
+	// follows it in the partition can't be framed
+	errLogRecordTooLarge = errors.New("log record is too large")
+)
+
+// StartPosition is where a subscriber with no committed offsets starts
+// reading a log
+type StartPosition struct {
+	earliest bool
+	at       time.Time
+}
+
+var (
+	// StartEarliest replays everything still in the log
+	StartEarliest = StartPosition{earliest: true}
+	// StartLatest only sees records appended after subscribing
+	StartLatest = StartPosition{}
+)
+
+// StartAt starts from the first record appended at or after t
+func StartAt(t time.Time) StartPosition {
+	return StartPosition{at: t}
+}
+
+// ParseStartPosition reads "earliest", "latest" or an RFC 3339 timestamp
+func ParseStartPosition(s string) (StartPosition, error) {
+	switch s {
+	case "", "latest":
+		return StartLatest, nil
+	case "earliest":
+		return StartEarliest, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return StartPosition{}, fmt.Errorf("start must be earliest, latest or an RFC 3339 time: %v", err)
+	}
+	return StartAt(t), nil
+}
+
+// FileLogTransport is a Transport over append-only log files, so games can
+// be replayed after the fact. Each exchange is a directory of partition
+// files; records are routed to a partition by the player their routing key
+// names, so one player's messages stay in order. Offsets are byte positions
+// in a partition file. Subscribers read every partition and skip records
+// their binding key doesn't match. Durable queues commit the offsets they
+// have acked to disk and resume from them; Transient queues start afresh
+// every time. Each queue should have one consumer at a time.
+type FileLogTransport struct {
+	dir        string
+	partitions int
+	start      StartPosition
+	ctx        context.Context
+	cancel     context.CancelFunc
+	wg         *sync.WaitGroup
+
+	mu      *sync.Mutex
+	writers map[string]*os.File
+	nextTag uint64
+	pending map[uint64]logPending
+	subs    []*logSubscription
+}
+
+// logRecord is one message in a partition file, stored as a big-endian
+// length followed by this struct as JSON
+type logRecord struct {
+	Timestamp       time.Time         `json:"timestamp"`
+	Key             string            `json:"key"`
+	ContentType     string            `json:"content_type,omitempty"`
+	ContentEncoding string            `json:"content_encoding,omitempty"`
+	Headers         map[string]string `json:"headers,omitempty"`
+	Body            []byte            `json:"body"`
+}
+
+type logSubscription struct {
+	exchange   string
+	queueName  string
+	key        string
+	durable    bool
+	deliveries chan amqp.Delivery
+
+	// committed is where reading resumes in each partition; acked holds
+	// the end offsets of records acked past a gap, keyed by start offset
+	committed []int64
+	acked     []map[int64]int64
+}
+
+type logPending struct {
+	sub       *logSubscription
+	partition int
+	offset    int64
+	next      int64
+	delivery  amqp.Delivery
+}
+
+// OpenFileLog uses dir as the log directory, creating it if needed.
+// Subscribers without committed offsets begin at start.
+func OpenFileLog(dir string, partitions int, start StartPosition) (*FileLogTransport, error) {
+	if partitions < 1 {
+		return nil, errors.New("a log needs at least one partition")
+	}
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &FileLogTransport{
+		dir:        dir,
+		partitions: partitions,
+		start:      start,
+		wg:         &sync.WaitGroup{},
+		mu:         &sync.Mutex{},
+		writers:    map[string]*os.File{},
+		pending:    map[uint64]logPending{},
+	}
+	t.ctx, t.cancel = context.WithCancel(context.Background())
+	return t, nil
+}
+
+func (t *FileLogTransport) PublishWithContext(
+	ctx context.Context,
+	exchange,
+	key string,
+	mandatory,
+	immediate bool,
+	msg amqp.Publishing,
+) error {
+	rec := logRecord{
+		Timestamp:       msg.Timestamp,
+		Key:             key,
+		ContentType:     msg.ContentType,
+		ContentEncoding: msg.ContentEncoding,
+		Body:            msg.Body,
+	}
+	if rec.Timestamp.IsZero() {
+		rec.Timestamp = time.Now()
+	}
+	if len(msg.Headers) > 0 {
+		rec.Headers = map[string]string{}
+		for k, v := range msg.Headers {
+			rec.Headers[k] = fmt.Sprint(v)
+		}
+	}
+	return t.append(exchange, rec)
+}
+
+func (t *FileLogTransport) Consume(exchange, queueName, key string, simpleQueueType QueueType) (<-chan amqp.Delivery, error) {
+	return t.ConsumeFrom(exchange, queueName, key, simpleQueueType, t.start)
+}
+
+// ConsumeFrom is Consume with its own start position, for replaying from
+// the beginning or a point in time. A Durable queue that has committed
+// offsets resumes from them regardless.
+func (t *FileLogTransport) ConsumeFrom(
+	exchange,
+	queueName,
+	key string,
+	simpleQueueType QueueType,
+	start StartPosition,
+) (<-chan amqp.Delivery, error) {
+	partitions, err := t.partitionCount(exchange)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open log for %s: %v", exchange, err)
+	}
+
+	sub := &logSubscription{
+		exchange:   exchange,
+		queueName:  queueName,
+		key:        key,
+		durable:    simpleQueueType == Durable,
+		deliveries: make(chan amqp.Delivery, prefetchCount),
+		acked:      make([]map[int64]int64, partitions),
+	}
+	for i := range sub.acked {
+		sub.acked[i] = map[int64]int64{}
+	}
+
+	if sub.durable {
+		sub.committed, err = t.loadOffsets(sub)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load offsets for %s: %v", queueName, err)
+		}
+	}
+	if sub.committed == nil {
+		sub.committed, err = t.startOffsets(exchange, partitions, start)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to find start of %s: %v", exchange, err)
+		}
+	}
+
+	t.mu.Lock()
+	if t.ctx.Err() != nil {
+		t.mu.Unlock()
+		return nil, errLogClosed
+	}
+	t.subs = append(t.subs, sub)
+	t.wg.Add(1)
+	t.mu.Unlock()
+	go func() {
+		defer t.wg.Done()
+		t.read(sub)
+	}()
+	return sub.deliveries, nil
+}
+
+func (t *FileLogTransport) Close() error {
+	// cancelling under mu means nothing adds to wg once Wait has started
+	t.mu.Lock()
+	t.cancel()
+	t.mu.Unlock()
+	// deliveries are only closed once nothing can send on them
+	t.wg.Wait()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, sub := range t.subs {
+		close(sub.deliveries)
+	}
+	t.subs = nil
+
+	var err error
+	for _, f := range t.writers {
+		err = errors.Join(err, f.Close())
+	}
+	t.writers = map[string]*os.File{}
+	return err
+}
+
+// Ack, Nack and Reject make FileLogTransport the amqp.Acknowledger of its
+// own deliveries. Nothing is ever removed from the log: acking commits the
+// offset, requeueing delivers the record again and discarding appends it to
+// the peril_dlx log before committing it.
+func (t *FileLogTransport) Ack(tag uint64, multiple bool) error {
+	p, err := t.take(tag)
+	if err != nil {
+		return err
+	}
+	return t.commit(p.sub, p.partition, p.offset, p.next)
+}
+
+func (t *FileLogTransport) Nack(tag uint64, multiple, requeue bool) error {
+	p, err := t.take(tag)
+	if err != nil {
+		return err
+	}
+
+	if requeue {
+		d := p.delivery
+		d.Redelivered = true
+		// the subscriber may be the one blocked reading deliveries
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if t.ctx.Err() != nil {
+			// left uncommitted, so a durable queue sees it again
+			return errLogClosed
+		}
+		t.wg.Add(1)
+		go func() {
+			defer t.wg.Done()
+			t.deliver(p.sub, p.partition, p.offset, p.next, d)
+		}()
+		return nil
+	}
+
+	rec := logRecord{
+		Timestamp:       time.Now(),
+		Key:             p.delivery.RoutingKey,
+		ContentType:     p.delivery.ContentType,
+		ContentEncoding: p.delivery.ContentEncoding,
+		Body:            p.delivery.Body,
+		Headers:         map[string]string{"x-peril-queue": p.sub.queueName},
+	}
+	for k, v := range p.delivery.Headers {
+		rec.Headers[k] = fmt.Sprint(v)
+	}
+	err = t.append(deadLetterExchange, rec)
+	if err != nil {
+		return err
+	}
+	return t.commit(p.sub, p.partition, p.offset, p.next)
+}
+
+func (t *FileLogTransport) Reject(tag uint64, requeue bool) error {
+	return t.Nack(tag, false, requeue)
+}
+
+// PartitionFor returns the partition a routing key's records go to,
+// hashing everything after its first word, so "army_moves.europe.bob" is
+// placed by "europe.bob", or the whole key if it's a single word
+func PartitionFor(key string, partitions int) int {
+	_, player, ok := strings.Cut(key, ".")
+	if !ok {
+		player = key
+	}
+	h := fnv.New32a()
+	h.Write([]byte(player))
+	return int(h.Sum32() % uint32(partitions))
+}
+
+func (t *FileLogTransport) append(exchange string, rec logRecord) error {
+	partitions, err := t.partitionCount(exchange)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	buf := binary.BigEndian.AppendUint32(nil, uint32(len(b)))
+	buf = append(buf, b...)
+
+	path := t.partitionPath(exchange, PartitionFor(rec.Key, partitions))
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	f, ok := t.writers[path]
+	if !ok {
+		f, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return err
+		}
+		t.writers[path] = f
+	}
+	// one write per record, so appends from other processes don't interleave
+	_, err = f.Write(buf)
+	return err
+}
+
+// partitionCount returns how many partitions exchange's log has, creating
+// the log with t.partitions if it doesn't exist yet
+func (t *FileLogTransport) partitionCount(exchange string) (int, error) {
+	dir := filepath.Join(t.dir, exchange)
+	meta := filepath.Join(dir, "partitions")
+	b, err := os.ReadFile(meta)
+	if err == nil {
+		return strconv.Atoi(strings.TrimSpace(string(b)))
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return 0, err
+	}
+
+	err = os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return 0, err
+	}
+	err = writeFileAtomic(meta, []byte(strconv.Itoa(t.partitions)+"\n"))
+	if err != nil {
+		return 0, err
+	}
+	return t.partitions, nil
+}
+
+func (t *FileLogTransport) partitionPath(exchange string, partition int) string {
+	return filepath.Join(t.dir, exchange, fmt.Sprintf("%d.log", partition))
+}
+
+func (t *FileLogTransport) offsetsPath(sub *logSubscription) string {
+	return filepath.Join(t.dir, sub.exchange, "offsets", sub.queueName+".json")
+}
+
+// loadOffsets returns a durable queue's committed offsets, or nil if it
+// has none yet
+func (t *FileLogTransport) loadOffsets(sub *logSubscription) ([]int64, error) {
+	b, err := os.ReadFile(t.offsetsPath(sub))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var offsets []int64
+	err = json.Unmarshal(b, &offsets)
+	if err != nil {
+		return nil, err
+	}
+	if len(offsets) != len(sub.acked) {
+		return nil, fmt.Errorf("have offsets for %d partitions, log has %d", len(offsets), len(sub.acked))
+	}
+	return offsets, nil
+}
+
+// startOffsets finds where start falls in each partition
+func (t *FileLogTransport) startOffsets(exchange string, partitions int, start StartPosition) ([]int64, error) {
+	offsets := make([]int64, partitions)
+	if start.earliest {
+		return offsets, nil
+	}
+
+	for i := range offsets {
+		f, err := os.Open(t.partitionPath(exchange, i))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if start.at.IsZero() {
+			offsets[i], err = f.Seek(0, io.SeekEnd)
+			f.Close()
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		r := bufio.NewReader(f)
+		for {
+			rec, n, err := readLogRecord(r)
+			if errors.Is(err, errMalformedLogRecord) {
+				offsets[i] += n
+				continue
+			}
+			if err != nil || !rec.Timestamp.Before(start.at) {
+				break
+			}
+			offsets[i] += n
+		}
+		f.Close()
+	}
+	return offsets, nil
+}
+
+// read follows every partition of sub's exchange from its committed offsets,
+// delivering records its binding key matches, until the transport is closed
+func (t *FileLogTransport) read(sub *logSubscription) {
+	t.mu.Lock()
+	cursors := append([]int64(nil), sub.committed...)
+	t.mu.Unlock()
+
+	files := make([]*os.File, len(cursors))
+	corrupt := make([]bool, len(cursors))
+	defer func() {
+		for _, f := range files {
+			if f != nil {
+				f.Close()
+			}
+		}
+	}()
+
+	for t.ctx.Err() == nil {
+		progress := false
+		for i := range files {
+			if corrupt[i] {
+				continue
+			}
+			if files[i] == nil {
+				f, err := os.Open(t.partitionPath(sub.exchange, i))
+				if err != nil {
+					continue
+				}
+				files[i] = f
+			}
+
+			_, err := files[i].Seek(cursors[i], io.SeekStart)
+			if err != nil {
+				continue
+			}
+			r := bufio.NewReader(files[i])
+			for t.ctx.Err() == nil {
+				// a short read is a record still being written
+				rec, n, err := readLogRecord(r)
+				if errors.Is(err, errLogRecordTooLarge) {
+					log.Printf("%s is corrupt at offset %d, no longer reading it: %v", files[i].Name(), cursors[i], err)
+					corrupt[i] = true
+					break
+				}
+				if err != nil && !errors.Is(err, errMalformedLogRecord) {
+					break
+				}
+				offset, next := cursors[i], cursors[i]+n
+				cursors[i] = next
+				progress = true
+
+				if err != nil {
+					log.Printf("skipping record at %s offset %d: %v", files[i].Name(), offset, err)
+					t.commit(sub, i, offset, next)
+					continue
+				}
+
+				if !topic.Match(sub.key, rec.Key) {
+					t.commit(sub, i, offset, next)
+					continue
+				}
+				t.deliver(sub, i, offset, next, logDelivery(sub.exchange, rec))
+			}
+		}
+
+		if !progress {
+			select {
+			case <-t.ctx.Done():
+			case <-time.After(logPollInterval):
+			}
+		}
+	}
+}
+
+func (t *FileLogTransport) deliver(sub *logSubscription, partition int, offset, next int64, d amqp.Delivery) {
+	d.Acknowledger = t
+
+	t.mu.Lock()
+	t.nextTag++
+	d.DeliveryTag = t.nextTag
+	t.pending[d.DeliveryTag] = logPending{
+		sub:       sub,
+		partition: partition,
+		offset:    offset,
+		next:      next,
+		delivery:  d,
+	}
+	t.mu.Unlock()
+
+	select {
+	case sub.deliveries <- d:
+	case <-t.ctx.Done():
+	}
+}
+
+// commit marks the record at offset done, advancing the committed offset
+// past every done record with none outstanding before it
+func (t *FileLogTransport) commit(sub *logSubscription, partition int, offset, next int64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	sub.acked[partition][offset] = next
+	advanced := false
+	for {
+		n, ok := sub.acked[partition][sub.committed[partition]]
+		if !ok {
+			break
+		}
+		delete(sub.acked[partition], sub.committed[partition])
+		sub.committed[partition] = n
+		advanced = true
+	}
+	if !advanced || !sub.durable {
+		return nil
+	}
+
+	b, err := json.Marshal(sub.committed)
+	if err != nil {
+		return err
+	}
+	path := t.offsetsPath(sub)
+	err = os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, b)
+}
+
+func (t *FileLogTransport) take(tag uint64) (logPending, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, ok := t.pending[tag]
+	if !ok {
+		return logPending{}, fmt.Errorf("unknown delivery tag %d", tag)
+	}
+	delete(t.pending, tag)
+	return p, nil
+}
+
+// readLogRecord reads one record and reports how many bytes it took up,
+// which is still known if the record is malformed
+func readLogRecord(r io.Reader) (logRecord, int64, error) {
+	var head [4]byte
+	_, err := io.ReadFull(r, head[:])
+	if err != nil {
+		return logRecord{}, 0, err
+	}
+	size := binary.BigEndian.Uint32(head[:])
+	if size > maxLogRecordSize {
+		return logRecord{}, 0, fmt.Errorf("%w: %d bytes", errLogRecordTooLarge, size)
+	}
+	b := make([]byte, size)
+	_, err = io.ReadFull(r, b)
+	if err != nil {
+		return logRecord{}, 0, err
+	}
+
+	n := int64(len(head) + len(b))
+	var rec logRecord
+	err = json.Unmarshal(b, &rec)
+	if err != nil {
+		return logRecord{}, n, fmt.Errorf("%w: %v", errMalformedLogRecord, err)
+	}
+	return rec, n, nil
+}
+
+func logDelivery(exchange string, rec logRecord) amqp.Delivery {
+	d := amqp.Delivery{
+		Exchange:        exchange,
+		RoutingKey:      rec.Key,
+		ContentType:     rec.ContentType,
+		ContentEncoding: rec.ContentEncoding,
+		Timestamp:       rec.Timestamp,
+		Body:            rec.Body,
+	}
+	if len(rec.Headers) > 0 {
+		d.Headers = amqp.Table{}
+		for k, v := range rec.Headers {
+			d.Headers[k] = v
+		}
+	}
+	return d
+}
+
+// writeFileAtomic replaces path with b, so readers never see half a file
+func writeFileAtomic(path string, b []byte) error {
+	tmp := path + ".tmp"
+	err := os.WriteFile(tmp, b, 0o644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
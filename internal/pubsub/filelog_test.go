@@ -0,0 +1,200 @@
+package pubsub
+
+import (
+	"encoding/binary"
+	"os"
+	"testing"
+	"time"
+)
+
+func openTestLog(t *testing.T, dir string, partitions int) *FileLogTransport {
+	t.Helper()
+	transport, err := OpenFileLog(dir, partitions, StartEarliest)
+	if err != nil {
+		t.Fatalf("OpenFileLog: %v", err)
+	}
+	t.Cleanup(func() { transport.Close() })
+	return transport
+}
+
+// appendRaw writes bytes straight to the end of a partition file
+func appendRaw(t *testing.T, transport *FileLogTransport, exchange string, partition int, b []byte) {
+	t.Helper()
+	_, err := transport.partitionCount(exchange)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(transport.partitionPath(exchange, partition), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	_, err = f.Write(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileLogSkipsMalformedRecords(t *testing.T) {
+	dir := t.TempDir()
+	transport := openTestLog(t, dir, 1)
+
+	garbage := []byte("{not json")
+	appendRaw(t, transport, "peril_topic", 0, append(binary.BigEndian.AppendUint32(nil, uint32(len(garbage))), garbage...))
+	err := PublishJSON(transport, "peril_topic", "army_moves.europe.bob", "move")
+	if err != nil {
+		t.Fatalf("PublishJSON: %v", err)
+	}
+
+	received := make(chan string, 2)
+	err = SubscribeJSON(transport, "peril_topic", "moves", "army_moves.*.*", Durable, func(s string) Acktype {
+		received <- s
+		return Ack
+	})
+	if err != nil {
+		t.Fatalf("SubscribeJSON: %v", err)
+	}
+
+	select {
+	case got := <-received:
+		if got != "move" {
+			t.Errorf("got %q, want move", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the record after the malformed one never arrived")
+	}
+
+	// the malformed record is committed along with the good one, so a
+	// restarted queue doesn't trip over it again
+	transport.Close()
+	reopened := openTestLog(t, dir, 1)
+	deliveries, err := reopened.Consume("peril_topic", "moves", "army_moves.*.*", Durable)
+	if err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+	select {
+	case d := <-deliveries:
+		t.Errorf("redelivered %s after a restart", d.Body)
+	case <-time.After(3 * logPollInterval):
+	}
+}
+
+func TestFileLogStopsReadingCorruptPartitions(t *testing.T) {
+	transport := openTestLog(t, t.TempDir(), 2)
+
+	bob := PartitionFor("army_moves.europe.bob", 2)
+	var alice string
+	for _, name := range []string{"alice", "carol", "dave", "erin"} {
+		if PartitionFor("army_moves.europe."+name, 2) != bob {
+			alice = name
+			break
+		}
+	}
+	if alice == "" {
+		t.Fatal("no player hashes to the other partition")
+	}
+
+	// a length no record can have, so nothing after it can be read
+	appendRaw(t, transport, "peril_topic", bob, binary.BigEndian.AppendUint32(nil, maxLogRecordSize+1))
+
+	received := make(chan string, 2)
+	err := SubscribeJSON(transport, "peril_topic", "moves", "army_moves.*.*", Durable, func(s string) Acktype {
+		received <- s
+		return Ack
+	})
+	if err != nil {
+		t.Fatalf("SubscribeJSON: %v", err)
+	}
+	for _, name := range []string{"bob", alice} {
+		err = PublishJSON(transport, "peril_topic", "army_moves.europe."+name, name)
+		if err != nil {
+			t.Fatalf("PublishJSON: %v", err)
+		}
+	}
+
+	select {
+	case got := <-received:
+		if got != alice {
+			t.Errorf("got %s's move, want %s's", got, alice)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the healthy partition was never read")
+	}
+	select {
+	case got := <-received:
+		t.Errorf("read %s's move past the corruption", got)
+	case <-time.After(3 * logPollInterval):
+	}
+}
+
+func TestFileLogNackRequeueRedelivers(t *testing.T) {
+	transport := openTestLog(t, t.TempDir(), 1)
+
+	redelivered := make(chan bool, 2)
+	deliveries, err := transport.Consume("peril_topic", "moves", "army_moves.*.*", Durable)
+	if err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+	err = PublishJSON(transport, "peril_topic", "army_moves.europe.bob", "move")
+	if err != nil {
+		t.Fatalf("PublishJSON: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case d := <-deliveries:
+			redelivered <- d.Redelivered
+			if d.Redelivered {
+				d.Ack(false)
+			} else {
+				d.Nack(false, true)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("delivery %d never arrived", i+1)
+		}
+	}
+	if first, second := <-redelivered, <-redelivered; first || !second {
+		t.Errorf("Redelivered = %v then %v, want false then true", first, second)
+	}
+}
+
+func TestFileLogNackAfterClose(t *testing.T) {
+	transport, err := OpenFileLog(t.TempDir(), 1, StartEarliest)
+	if err != nil {
+		t.Fatalf("OpenFileLog: %v", err)
+	}
+	deliveries, err := transport.Consume("peril_topic", "moves", "army_moves.*.*", Durable)
+	if err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+	err = PublishJSON(transport, "peril_topic", "army_moves.europe.bob", "move")
+	if err != nil {
+		t.Fatalf("PublishJSON: %v", err)
+	}
+
+	d := <-deliveries
+	err = transport.Close()
+	if err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := d.Nack(false, true); err == nil {
+		t.Error("requeued on a closed transport")
+	}
+	if _, err := transport.Consume("peril_topic", "moves", "army_moves.*.*", Durable); err == nil {
+		t.Error("consumed from a closed transport")
+	}
+}
+
+func TestPartitionFor(t *testing.T) {
+	// every message about one player in one game shares a partition,
+	// whatever its prefix
+	for _, partitions := range []int{1, 3, 8} {
+		want := PartitionFor("army_moves.europe.bob", partitions)
+		if got := PartitionFor("spawns.europe.bob", partitions); got != want {
+			t.Errorf("%d partitions: spawns went to %d, moves to %d", partitions, got, want)
+		}
+		if got := PartitionFor("pause", partitions); got < 0 || got >= partitions {
+			t.Errorf("%d partitions: single word key went to %d", partitions, got)
+		}
+	}
+}
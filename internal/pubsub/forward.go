@@ -0,0 +1,58 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// headerForwarded marks messages a Forward has already carried across, so
+// two Forwards running in opposite directions don't loop
+const headerForwarded = "x-peril-forwarded"
+
+// Forward consumes every message bound to exchange with key on src and
+// republishes it unchanged to the same exchange and routing key on dst.
+// Messages are acked on src once dst has accepted them.
+func Forward(src, dst Transport, exchange, queueName, key string, simpleQueueType QueueType) error {
+	deliveryChan, err := src.Consume(exchange, queueName, key, simpleQueueType)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for d := range deliveryChan {
+			if _, ok := d.Headers[headerForwarded]; ok {
+				d.Ack(false)
+				continue
+			}
+
+			headers := amqp.Table{headerForwarded: "true"}
+			for k, v := range d.Headers {
+				headers[k] = v
+			}
+
+			err := dst.PublishWithContext(
+				context.Background(),
+				d.Exchange,
+				d.RoutingKey,
+				false,
+				false,
+				amqp.Publishing{
+					Headers:         headers,
+					ContentType:     d.ContentType,
+					ContentEncoding: d.ContentEncoding,
+					Body:            d.Body,
+				},
+			)
+			if err != nil {
+				fmt.Printf("Failed to forward message on %s: %v\n", d.RoutingKey, err)
+				d.Nack(false, true)
+				continue
+			}
+			d.Ack(false)
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,328 @@
+package pubsub
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// DefaultMQTTRoots maps exchanges to the MQTT topic level their routing
+// keys hang off, so army_moves.* on peril_topic becomes peril/army_moves/+
+var DefaultMQTTRoots = map[string]string{
+	"peril_topic":  "peril",
+	"peril_direct": "peril_direct",
+}
+
+// mqttDeadLetterRoot is where discarded messages are republished, since MQTT
+// has no dead-letter exchanges of its own
+const mqttDeadLetterRoot = "peril_dlx"
+
+const mqttTimeout = 10 * time.Second
+
+// MQTTTransport is a Transport over an MQTT 3.1.1 broker. MQTT has no
+// message headers, so messages travel in a JSON envelope. Durable queues use
+// shared subscriptions ($share/<queue>/<topic>) so their consumers compete
+// for messages the way they do on a RabbitMQ queue.
+type MQTTTransport struct {
+	client mqtt.Client
+	roots  map[string]string
+
+	mu      *sync.Mutex
+	nextTag uint64
+	pending map[uint64]mqttPending
+}
+
+type mqttPending struct {
+	msg        mqtt.Message
+	delivery   amqp.Delivery
+	deliveries chan amqp.Delivery
+}
+
+// mqttEnvelope carries the parts of an amqp.Publishing that MQTT can't.
+// JSON bodies are embedded as-is so MQTT observers can read them directly.
+type mqttEnvelope struct {
+	ContentType     string            `json:"content_type,omitempty"`
+	ContentEncoding string            `json:"content_encoding,omitempty"`
+	Headers         map[string]string `json:"headers,omitempty"`
+	Payload         json.RawMessage   `json:"payload,omitempty"`
+	Body            []byte            `json:"body,omitempty"`
+}
+
+// DialMQTT connects to an MQTT broker, e.g. "tcp://localhost:1883"
+func DialMQTT(brokerURL, clientID, username, password string) (*MQTTTransport, error) {
+	opts := mqtt.NewClientOptions().
+		AddBroker(brokerURL).
+		SetClientID(clientID).
+		SetUsername(username).
+		SetPassword(password).
+		SetAutoAckDisabled(true)
+
+	client := mqtt.NewClient(opts)
+	token := client.Connect()
+	if !token.WaitTimeout(mqttTimeout) {
+		return nil, errors.New("timed out connecting to MQTT broker")
+	}
+	if token.Error() != nil {
+		return nil, token.Error()
+	}
+
+	return &MQTTTransport{
+		client:  client,
+		roots:   DefaultMQTTRoots,
+		mu:      &sync.Mutex{},
+		pending: map[uint64]mqttPending{},
+	}, nil
+}
+
+func (t *MQTTTransport) PublishWithContext(
+	ctx context.Context,
+	exchange,
+	key string,
+	mandatory,
+	immediate bool,
+	msg amqp.Publishing,
+) error {
+	topic, err := MQTTTopic(t.roots, exchange, key)
+	if err != nil {
+		return err
+	}
+	payload, err := encodeMQTTEnvelope(msg)
+	if err != nil {
+		return err
+	}
+	return t.publish(ctx, topic, payload)
+}
+
+func (t *MQTTTransport) Consume(exchange, queueName, key string, simpleQueueType QueueType) (<-chan amqp.Delivery, error) {
+	topic, err := MQTTTopic(t.roots, exchange, key)
+	if err != nil {
+		return nil, err
+	}
+	if simpleQueueType == Durable {
+		topic = fmt.Sprintf("$share/%s/%s", queueName, topic)
+	}
+
+	deliveries := make(chan amqp.Delivery, prefetchCount)
+	token := t.client.Subscribe(topic, 1, func(_ mqtt.Client, m mqtt.Message) {
+		t.deliver(m, deliveries)
+	})
+	if !token.WaitTimeout(mqttTimeout) {
+		return nil, fmt.Errorf("Failed to consume from %s: timed out", queueName)
+	}
+	if token.Error() != nil {
+		return nil, fmt.Errorf("Failed to consume from %s: %v", queueName, token.Error())
+	}
+	return deliveries, nil
+}
+
+func (t *MQTTTransport) Close() error {
+	t.client.Disconnect(250)
+	return nil
+}
+
+// Ack, Nack and Reject make MQTTTransport the amqp.Acknowledger of its own
+// deliveries. MQTT can't requeue, so requeued messages are redelivered
+// locally, and discarded ones are republished under peril_dlx/.
+func (t *MQTTTransport) Ack(tag uint64, multiple bool) error {
+	p, err := t.take(tag)
+	if err != nil {
+		return err
+	}
+	p.msg.Ack()
+	return nil
+}
+
+func (t *MQTTTransport) Nack(tag uint64, multiple, requeue bool) error {
+	p, err := t.take(tag)
+	if err != nil {
+		return err
+	}
+
+	if requeue {
+		d := p.delivery
+		d.Redelivered = true
+		// the subscriber may be the one blocked reading deliveries
+		go t.track(p.msg, d, p.deliveries)
+		return nil
+	}
+
+	err = t.publish(context.Background(), mqttDeadLetterRoot+"/"+p.msg.Topic(), p.msg.Payload())
+	if err != nil {
+		return err
+	}
+	p.msg.Ack()
+	return nil
+}
+
+func (t *MQTTTransport) Reject(tag uint64, requeue bool) error {
+	return t.Nack(tag, false, requeue)
+}
+
+func (t *MQTTTransport) publish(ctx context.Context, topic string, payload []byte) error {
+	token := t.client.Publish(topic, 1, false, payload)
+	select {
+	case <-token.Done():
+		return token.Error()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (t *MQTTTransport) deliver(m mqtt.Message, deliveries chan amqp.Delivery) {
+	exchange, key, err := ParseMQTTTopic(t.roots, m.Topic())
+	if err != nil {
+		m.Ack()
+		return
+	}
+
+	d, err := decodeMQTTEnvelope(m.Payload())
+	if err != nil {
+		// not something a pubsub publisher sent, pass the raw payload on
+		d = amqp.Delivery{Body: m.Payload()}
+	}
+	d.Acknowledger = t
+	d.Exchange = exchange
+	d.RoutingKey = key
+	d.Redelivered = m.Duplicate()
+	t.track(m, d, deliveries)
+}
+
+func (t *MQTTTransport) track(m mqtt.Message, d amqp.Delivery, deliveries chan amqp.Delivery) {
+	t.mu.Lock()
+	t.nextTag++
+	d.DeliveryTag = t.nextTag
+	t.pending[d.DeliveryTag] = mqttPending{msg: m, delivery: d, deliveries: deliveries}
+	t.mu.Unlock()
+
+	deliveries <- d
+}
+
+func (t *MQTTTransport) take(tag uint64) (mqttPending, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, ok := t.pending[tag]
+	if !ok {
+		return mqttPending{}, fmt.Errorf("unknown delivery tag %d", tag)
+	}
+	delete(t.pending, tag)
+	return p, nil
+}
+
+func encodeMQTTEnvelope(msg amqp.Publishing) ([]byte, error) {
+	env := mqttEnvelope{
+		ContentType:     msg.ContentType,
+		ContentEncoding: msg.ContentEncoding,
+	}
+	if len(msg.Headers) > 0 {
+		env.Headers = map[string]string{}
+		for k, v := range msg.Headers {
+			env.Headers[k] = fmt.Sprint(v)
+		}
+	}
+	if msg.ContentType == "application/json" && msg.ContentEncoding == "" && isCompactJSON(msg.Body) {
+		env.Payload = msg.Body
+	} else {
+		env.Body = msg.Body
+	}
+	return json.Marshal(env)
+}
+
+// isCompactJSON reports whether body survives being embedded in the
+// envelope byte for byte, which signatures depend on
+func isCompactJSON(body []byte) bool {
+	out, err := json.Marshal(json.RawMessage(body))
+	return err == nil && bytes.Equal(out, body)
+}
+
+func decodeMQTTEnvelope(payload []byte) (amqp.Delivery, error) {
+	var env mqttEnvelope
+	err := json.Unmarshal(payload, &env)
+	if err != nil {
+		return amqp.Delivery{}, err
+	}
+	if env.Payload == nil && env.Body == nil {
+		return amqp.Delivery{}, errors.New("MQTT envelope has no body")
+	}
+
+	d := amqp.Delivery{
+		ContentType:     env.ContentType,
+		ContentEncoding: env.ContentEncoding,
+		Body:            env.Body,
+	}
+	if env.Payload != nil {
+		d.Body = env.Payload
+	}
+	if len(env.Headers) > 0 {
+		d.Headers = amqp.Table{}
+		for k, v := range env.Headers {
+			d.Headers[k] = v
+		}
+	}
+	return d, nil
+}
+
+// MQTTTopic translates an exchange and AMQP routing key or binding pattern
+// into an MQTT topic or filter. Words become topic levels, * becomes + and
+// a trailing # stays #.
+func MQTTTopic(roots map[string]string, exchange, key string) (string, error) {
+	root, ok := roots[exchange]
+	if !ok {
+		return "", fmt.Errorf("exchange %s has no MQTT topic root", exchange)
+	}
+
+	words := strings.Split(key, ".")
+	levels := make([]string, 0, len(words)+1)
+	levels = append(levels, root)
+	for i, word := range words {
+		switch {
+		case word == "*":
+			levels = append(levels, "+")
+		case word == "#":
+			if i != len(words)-1 {
+				return "", fmt.Errorf("%s: MQTT only allows # as the last level", key)
+			}
+			levels = append(levels, "#")
+		case strings.ContainsAny(word, "/+#"):
+			return "", fmt.Errorf("%s: routing key words can't contain / + or #", key)
+		default:
+			levels = append(levels, word)
+		}
+	}
+	return strings.Join(levels, "/"), nil
+}
+
+// ParseMQTTTopic translates an MQTT topic or filter back into an exchange
+// and AMQP routing key or binding pattern
+func ParseMQTTTopic(roots map[string]string, topic string) (exchange, key string, err error) {
+	levels := strings.Split(topic, "/")
+	for ex, root := range roots {
+		if levels[0] != root {
+			continue
+		}
+		if len(levels) < 2 {
+			return "", "", fmt.Errorf("topic %s has no routing key", topic)
+		}
+
+		words := make([]string, 0, len(levels)-1)
+		for _, level := range levels[1:] {
+			switch {
+			case level == "+":
+				words = append(words, "*")
+			case strings.Contains(level, "."):
+				return "", "", fmt.Errorf("topic level %s can't contain a dot", level)
+			default:
+				words = append(words, level)
+			}
+		}
+		return ex, strings.Join(words, "."), nil
+	}
+	return "", "", fmt.Errorf("topic %s is not under a known root", topic)
+}
@@ -0,0 +1,149 @@
+package pubsub
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	mochi "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+)
+
+// startMQTTBroker runs an embedded MQTT broker and returns its URL
+func startMQTTBroker(t *testing.T) string {
+	t.Helper()
+
+	// find a free port for the broker to listen on
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not find a free port: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	server := mochi.New(&mochi.Options{
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	err = server.AddHook(new(auth.AllowHook), nil)
+	if err != nil {
+		t.Fatalf("could not allow clients: %v", err)
+	}
+	err = server.AddListener(listeners.NewTCP(listeners.Config{ID: "test", Address: addr}))
+	if err != nil {
+		t.Fatalf("could not add listener: %v", err)
+	}
+	err = server.Serve()
+	if err != nil {
+		t.Fatalf("could not start MQTT broker: %v", err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return "mqtt://" + addr
+}
+
+func dialTestMQTT(t *testing.T, brokerURL string) Transport {
+	t.Helper()
+	transport, err := Dial(brokerURL)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	if _, ok := transport.(*MQTTTransport); !ok {
+		t.Fatalf("Dial returned a %T, want a *MQTTTransport", transport)
+	}
+	t.Cleanup(func() { transport.Close() })
+	return transport
+}
+
+func TestMQTTConformance(t *testing.T) {
+	testTransport(t, func(t *testing.T) func() Transport {
+		brokerURL := startMQTTBroker(t)
+		return func() Transport { return dialTestMQTT(t, brokerURL) }
+	})
+}
+
+func TestMQTTNackDiscardDeadLetters(t *testing.T) {
+	brokerURL := startMQTTBroker(t)
+	transport := dialTestMQTT(t, brokerURL)
+
+	// watch the dead-letter topics with a plain MQTT client
+	opts := mqtt.NewClientOptions().
+		AddBroker(brokerURL).
+		SetClientID("dlx-watcher")
+	watcher := mqtt.NewClient(opts)
+	if token := watcher.Connect(); !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("could not connect watcher: %v", token.Error())
+	}
+	defer watcher.Disconnect(0)
+	deadLetters := make(chan string, 1)
+	token := watcher.Subscribe(mqttDeadLetterRoot+"/#", 1, func(_ mqtt.Client, m mqtt.Message) {
+		deadLetters <- m.Topic()
+	})
+	if !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("could not subscribe watcher: %v", token.Error())
+	}
+
+	err := SubscribeJSON(
+		transport,
+		"peril_topic",
+		"moves",
+		"army_moves.*.*",
+		Transient,
+		func(string) Acktype { return NackDiscard },
+	)
+	if err != nil {
+		t.Fatalf("SubscribeJSON: %v", err)
+	}
+
+	err = PublishJSON(transport, "peril_topic", "army_moves.europe.bob", "move")
+	if err != nil {
+		t.Fatalf("PublishJSON: %v", err)
+	}
+
+	select {
+	case topic := <-deadLetters:
+		want := mqttDeadLetterRoot + "/peril/army_moves/europe/bob"
+		if topic != want {
+			t.Errorf("dead-lettered to %s, want %s", topic, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("discarded message was never dead-lettered")
+	}
+}
+
+func TestMQTTTopic(t *testing.T) {
+	tests := []struct {
+		exchange string
+		key      string
+		want     string
+		wantErr  bool
+	}{
+		{"peril_topic", "army_moves.europe.bob", "peril/army_moves/europe/bob", false},
+		{"peril_topic", "army_moves.*.*", "peril/army_moves/+/+", false},
+		{"peril_topic", "game_logs.#", "peril/game_logs/#", false},
+		{"peril_direct", "pause", "peril_direct/pause", false},
+		{"peril_topic", "a.#.b", "", true},
+		{"peril_topic", "a/b", "", true},
+		{"unknown", "a", "", true},
+	}
+	for _, tt := range tests {
+		got, err := MQTTTopic(DefaultMQTTRoots, tt.exchange, tt.key)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("MQTTTopic(%s, %s) error = %v, wantErr %v", tt.exchange, tt.key, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("MQTTTopic(%s, %s) = %s, want %s", tt.exchange, tt.key, got, tt.want)
+		}
+		if tt.wantErr {
+			continue
+		}
+
+		exchange, key, err := ParseMQTTTopic(DefaultMQTTRoots, got)
+		if err != nil || exchange != tt.exchange || key != tt.key {
+			t.Errorf("ParseMQTTTopic(%s) = %s, %s, %v", got, exchange, key, err)
+		}
+	}
+}
@@ -0,0 +1,331 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const natsTimeout = 10 * time.Second
+
+// a consumer that fails to fetch waits natsMinBackoff before trying again,
+// doubling up to natsMaxBackoff while the failures continue
+const (
+	natsMinBackoff = 100 * time.Millisecond
+	natsMaxBackoff = 5 * time.Second
+)
+
+// natsDeadLetterMaxAge is how long discarded messages are kept for
+// inspection, since nothing consumes the dead-letter stream by default
+const natsDeadLetterMaxAge = 7 * 24 * time.Hour
+
+// NATSTransport is a Transport over NATS JetStream. Each exchange is a
+// stream whose subjects are <exchange>.<routing key>, so a binding key is a
+// subject filter with a trailing # written as >, plus the subject before it
+// since # also matches zero words. Durable queues are durable consumers
+// shared by everyone consuming them; Transient queues are ephemeral
+// consumers that go away once their subscriber does.
+type NATSTransport struct {
+	nc *nats.Conn
+	js jetstream.JetStream
+
+	mu      *sync.Mutex
+	streams map[string]jetstream.Stream
+	iters   []jetstream.MessagesContext
+	nextTag uint64
+	pending map[uint64]jetstream.Msg
+}
+
+// DialNATS connects to a NATS server with JetStream enabled, e.g.
+// "nats://localhost:4222"
+func DialNATS(url string) (*NATSTransport, error) {
+	nc, err := nats.Connect(url)
+	if err != nil {
+		return nil, err
+	}
+	js, err := jetstream.New(nc)
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+
+	return &NATSTransport{
+		nc:      nc,
+		js:      js,
+		mu:      &sync.Mutex{},
+		streams: map[string]jetstream.Stream{},
+		pending: map[uint64]jetstream.Msg{},
+	}, nil
+}
+
+func (t *NATSTransport) PublishWithContext(
+	ctx context.Context,
+	exchange,
+	key string,
+	mandatory,
+	immediate bool,
+	msg amqp.Publishing,
+) error {
+	subject, err := natsSubject(exchange, key)
+	if err != nil {
+		return err
+	}
+	if strings.ContainsAny(subject, "*>") {
+		return fmt.Errorf("%s: can't publish to a wildcard routing key", key)
+	}
+	_, err = t.stream(ctx, exchange)
+	if err != nil {
+		return err
+	}
+
+	m := nats.NewMsg(subject)
+	m.Data = msg.Body
+	if msg.ContentType != "" {
+		m.Header.Set("Content-Type", msg.ContentType)
+	}
+	if msg.ContentEncoding != "" {
+		m.Header.Set("Content-Encoding", msg.ContentEncoding)
+	}
+	for k, v := range msg.Headers {
+		m.Header.Set(k, fmt.Sprint(v))
+	}
+
+	_, err = t.js.PublishMsg(ctx, m)
+	return err
+}
+
+func (t *NATSTransport) Consume(exchange, queueName, key string, simpleQueueType QueueType) (<-chan amqp.Delivery, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), natsTimeout)
+	defer cancel()
+
+	filters, err := natsFilters(exchange, key)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := t.stream(ctx, exchange)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to declare stream for %s: %v", exchange, err)
+	}
+
+	cfg := jetstream.ConsumerConfig{
+		FilterSubjects: filters,
+		AckPolicy:      jetstream.AckExplicitPolicy,
+		DeliverPolicy:  jetstream.DeliverNewPolicy,
+	}
+	if simpleQueueType == Durable {
+		cfg.Durable = natsConsumerName(queueName)
+	}
+	cons, err := stream.CreateOrUpdateConsumer(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to consume from %s: %v", queueName, err)
+	}
+
+	iter, err := cons.Messages(jetstream.PullMaxMessages(prefetchCount))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to consume from %s: %v", queueName, err)
+	}
+	t.mu.Lock()
+	t.iters = append(t.iters, iter)
+	t.mu.Unlock()
+
+	deliveries := make(chan amqp.Delivery, prefetchCount)
+	go func() {
+		defer close(deliveries)
+		backoff := natsMinBackoff
+		for {
+			m, err := iter.Next()
+			if errors.Is(err, jetstream.ErrMsgIteratorClosed) {
+				return
+			}
+			if err != nil {
+				// the iterator keeps retrying by itself, but don't spin
+				// while it does
+				log.Printf("could not fetch from %s, retrying in %s: %v", queueName, backoff, err)
+				time.Sleep(backoff)
+				backoff = min(2*backoff, natsMaxBackoff)
+				continue
+			}
+			backoff = natsMinBackoff
+			deliveries <- t.delivery(exchange, m)
+		}
+	}()
+	return deliveries, nil
+}
+
+func (t *NATSTransport) Close() error {
+	t.mu.Lock()
+	for _, iter := range t.iters {
+		iter.Stop()
+	}
+	t.iters = nil
+	t.mu.Unlock()
+	return t.nc.Drain()
+}
+
+// Ack, Nack and Reject make NATSTransport the amqp.Acknowledger of its own
+// deliveries. Requeued messages are naked for JetStream to redeliver, and
+// discarded ones are copied to the peril_dlx stream before being terminated.
+func (t *NATSTransport) Ack(tag uint64, multiple bool) error {
+	m, err := t.take(tag)
+	if err != nil {
+		return err
+	}
+	return m.Ack()
+}
+
+func (t *NATSTransport) Nack(tag uint64, multiple, requeue bool) error {
+	m, err := t.take(tag)
+	if err != nil {
+		return err
+	}
+	if requeue {
+		return m.Nak()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), natsTimeout)
+	defer cancel()
+	_, err = t.stream(ctx, deadLetterExchange)
+	if err != nil {
+		return err
+	}
+	dead := nats.NewMsg(deadLetterExchange + "." + m.Subject())
+	dead.Header = m.Headers()
+	dead.Data = m.Data()
+	_, err = t.js.PublishMsg(ctx, dead)
+	if err != nil {
+		return err
+	}
+	return m.Term()
+}
+
+func (t *NATSTransport) Reject(tag uint64, requeue bool) error {
+	return t.Nack(tag, false, requeue)
+}
+
+// stream returns the stream backing exchange, creating it the first time.
+// Exchange streams only keep messages some consumer still has to see, like
+// queues do; the dead-letter stream keeps everything for a while.
+func (t *NATSTransport) stream(ctx context.Context, exchange string) (jetstream.Stream, error) {
+	t.mu.Lock()
+	stream, ok := t.streams[exchange]
+	t.mu.Unlock()
+	if ok {
+		return stream, nil
+	}
+
+	cfg := jetstream.StreamConfig{
+		Name:      exchange,
+		Subjects:  []string{exchange, exchange + ".>"},
+		Retention: jetstream.InterestPolicy,
+	}
+	if exchange == deadLetterExchange {
+		cfg.Retention = jetstream.LimitsPolicy
+		cfg.MaxAge = natsDeadLetterMaxAge
+	}
+	stream, err := t.js.CreateOrUpdateStream(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	t.streams[exchange] = stream
+	t.mu.Unlock()
+	return stream, nil
+}
+
+func (t *NATSTransport) delivery(exchange string, m jetstream.Msg) amqp.Delivery {
+	d := amqp.Delivery{
+		Acknowledger:    t,
+		Exchange:        exchange,
+		RoutingKey:      strings.TrimPrefix(strings.TrimPrefix(m.Subject(), exchange), "."),
+		ContentType:     m.Headers().Get("Content-Type"),
+		ContentEncoding: m.Headers().Get("Content-Encoding"),
+		Body:            m.Data(),
+	}
+	for k := range m.Headers() {
+		if k == "Content-Type" || k == "Content-Encoding" {
+			continue
+		}
+		if d.Headers == nil {
+			d.Headers = amqp.Table{}
+		}
+		d.Headers[k] = m.Headers().Get(k)
+	}
+	if meta, err := m.Metadata(); err == nil {
+		d.Redelivered = meta.NumDelivered > 1
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.nextTag++
+	d.DeliveryTag = t.nextTag
+	t.pending[d.DeliveryTag] = m
+	return d
+}
+
+func (t *NATSTransport) take(tag uint64) (jetstream.Msg, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	m, ok := t.pending[tag]
+	if !ok {
+		return nil, fmt.Errorf("unknown delivery tag %d", tag)
+	}
+	delete(t.pending, tag)
+	return m, nil
+}
+
+// natsSubject translates an exchange and AMQP routing key or binding pattern
+// into a JetStream subject or filter. Routing key words are already subject
+// tokens; * means the same thing and a trailing # becomes >.
+func natsSubject(exchange, key string) (string, error) {
+	if key == "" {
+		return exchange, nil
+	}
+
+	words := strings.Split(key, ".")
+	for i, word := range words {
+		switch {
+		case word == "#":
+			if i != len(words)-1 {
+				return "", fmt.Errorf("%s: NATS only allows # as the last word", key)
+			}
+			words[i] = ">"
+		case word == "":
+			return "", fmt.Errorf("%s: routing key has an empty word", key)
+		case strings.ContainsAny(word, " >"):
+			return "", fmt.Errorf("%s: routing key words can't contain spaces or >", key)
+		}
+	}
+	return exchange + "." + strings.Join(words, "."), nil
+}
+
+// natsFilters is the consumer's filter subjects for a binding key. A
+// trailing # also matches zero words but > needs at least one token, so the
+// subject without it is a filter too.
+func natsFilters(exchange, key string) ([]string, error) {
+	subject, err := natsSubject(exchange, key)
+	if err != nil {
+		return nil, err
+	}
+	if prefix, ok := strings.CutSuffix(subject, ".>"); ok {
+		return []string{prefix, subject}, nil
+	}
+	return []string{subject}, nil
+}
+
+// natsConsumerNames escapes queue names into valid durable consumer names,
+// which can't contain dots. _ is escaped too so no two queues share one.
+var natsConsumerNames = strings.NewReplacer("_", "_5f", ".", "_2e")
+
+// natsConsumerName turns a queue name into a durable consumer name
+func natsConsumerName(queueName string) string {
+	return natsConsumerNames.Replace(queueName)
+}
@@ -0,0 +1,165 @@
+package pubsub
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	natsserver "github.com/nats-io/nats-server/v2/server"
+)
+
+// startNATSServer runs an embedded NATS server with JetStream enabled and
+// returns its URL
+func startNATSServer(t *testing.T) string {
+	t.Helper()
+	ns, err := natsserver.NewServer(&natsserver.Options{
+		Host:      "127.0.0.1",
+		Port:      -1,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	if err != nil {
+		t.Fatalf("could not create NATS server: %v", err)
+	}
+	go ns.Start()
+	if !ns.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server never became ready")
+	}
+	t.Cleanup(ns.Shutdown)
+	return ns.ClientURL()
+}
+
+func dialTestNATS(t *testing.T, url string) *NATSTransport {
+	t.Helper()
+	transport, err := Dial(url)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	nt, ok := transport.(*NATSTransport)
+	if !ok {
+		t.Fatalf("Dial returned a %T, want a *NATSTransport", transport)
+	}
+	t.Cleanup(func() { transport.Close() })
+	return nt
+}
+
+func TestNATSConformance(t *testing.T) {
+	testTransport(t, func(t *testing.T) func() Transport {
+		url := startNATSServer(t)
+		return func() Transport { return dialTestNATS(t, url) }
+	})
+}
+
+func TestNATSNackDiscardDeadLetters(t *testing.T) {
+	transport := dialTestNATS(t, startNATSServer(t))
+
+	err := SubscribeJSON(
+		transport,
+		"peril_topic",
+		"moves",
+		"army_moves.*.*",
+		Durable,
+		func(string) Acktype { return NackDiscard },
+	)
+	if err != nil {
+		t.Fatalf("SubscribeJSON: %v", err)
+	}
+	err = PublishJSON(transport, "peril_topic", "army_moves.europe.bob", "move")
+	if err != nil {
+		t.Fatalf("PublishJSON: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	eventually(t, "the move to be dead-lettered", func() bool {
+		stream, err := transport.js.Stream(ctx, deadLetterExchange)
+		if err != nil {
+			return false
+		}
+		info, err := stream.Info(ctx)
+		return err == nil && info.State.Msgs == 1
+	})
+	stream, err := transport.js.Stream(ctx, deadLetterExchange)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := stream.GetLastMsgForSubject(ctx, deadLetterExchange+".>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := deadLetterExchange + ".peril_topic.army_moves.europe.bob"; msg.Subject != want {
+		t.Errorf("dead-lettered to %s, want %s", msg.Subject, want)
+	}
+}
+
+func TestNATSSubject(t *testing.T) {
+	tests := []struct {
+		key     string
+		want    string
+		wantErr bool
+	}{
+		{"army_moves.europe.bob", "peril_topic.army_moves.europe.bob", false},
+		{"army_moves.*.*", "peril_topic.army_moves.*.*", false},
+		{"game_logs.#", "peril_topic.game_logs.>", false},
+		{"", "peril_topic", false},
+		{"a.#.b", "", true},
+		{"a..b", "", true},
+		{"a.b c", "", true},
+	}
+	for _, tt := range tests {
+		got, err := natsSubject("peril_topic", tt.key)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("natsSubject(%q) error = %v, wantErr %v", tt.key, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("natsSubject(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestNATSFilters(t *testing.T) {
+	tests := []struct {
+		key  string
+		want []string
+	}{
+		{"army_moves.*.*", []string{"peril_topic.army_moves.*.*"}},
+		{"game_logs.#", []string{"peril_topic.game_logs", "peril_topic.game_logs.>"}},
+		{"#", []string{"peril_topic", "peril_topic.>"}},
+	}
+	for _, tt := range tests {
+		got, err := natsFilters("peril_topic", tt.key)
+		if err != nil || !slices.Equal(got, tt.want) {
+			t.Errorf("natsFilters(%q) = %q, %v, want %q", tt.key, got, err, tt.want)
+		}
+	}
+}
+
+func TestNATSConsumerName(t *testing.T) {
+	names := map[string]string{}
+	for _, queueName := range []string{"moves", "a.b", "a_b", "a_2eb", "a._b", "a_.b"} {
+		name := natsConsumerName(queueName)
+		if strings.Contains(name, ".") {
+			t.Errorf("natsConsumerName(%q) = %q has a dot", queueName, name)
+		}
+		if other, ok := names[name]; ok {
+			t.Errorf("%q and %q both become %q", queueName, other, name)
+		}
+		names[name] = queueName
+	}
+	if name := natsConsumerName("moves"); name != "moves" {
+		t.Errorf("natsConsumerName(moves) = %q", name)
+	}
+}
+
+func TestNATSRefusesWildcardPublish(t *testing.T) {
+	transport := dialTestNATS(t, startNATSServer(t))
+	err := PublishJSON(transport, "peril_topic", "army_moves.*", "move")
+	if err == nil {
+		t.Error("published to a wildcard key")
+	}
+}
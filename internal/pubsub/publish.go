@@ -9,19 +9,47 @@ import (
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
-func PublishJSON[T any](ch *amqp.Channel, exchange, key string, val T) error {
-	valByte, _ := json.Marshal(val) // struct to json
-	return ch.PublishWithContext(
-		context.Background(),
+// Publisher is anything messages can be published through, such as an
+// *amqp.Channel or a BufferedPublisher
+type Publisher interface {
+	PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+}
+
+type publishConfig struct {
+	compression *compression
+	encryption  *encryption
+	signer      *Credentials
+	limiter     *RateLimiter
+	limiterKey  string
+	version     int
+}
+
+// PublishOption changes how a single message is published
+type PublishOption func(*publishConfig)
+
+// WithSigner signs the published message with the player's credentials
+func WithSigner(creds Credentials) PublishOption {
+	return func(cfg *publishConfig) {
+		cfg.signer = &creds
+	}
+}
+
+func PublishJSON[T any](ch Publisher, exchange, key string, val T, opts ...PublishOption) error {
+	valByte, err := json.Marshal(val) // struct to json
+	if err != nil {
+		return err
+	}
+
+	return publish(
+		ch,
 		exchange,
 		key,
-		false,
-		false,
 		amqp.Publishing{ContentType: "application/json", Body: valByte},
+		opts,
 	)
 }
 
-func PublishGob[T any](ch *amqp.Channel, exchange, key string, val T) error {
+func PublishGob[T any](ch Publisher, exchange, key string, val T, opts ...PublishOption) error {
 	var network bytes.Buffer
 	enc := gob.NewEncoder(&network)
 	err := enc.Encode(val)
@@ -29,12 +57,61 @@ func PublishGob[T any](ch *amqp.Channel, exchange, key string, val T) error {
 		return err
 	}
 
+	return publish(
+		ch,
+		exchange,
+		key,
+		amqp.Publishing{ContentType: "application/gob", Body: network.Bytes()},
+		opts,
+	)
+}
+
+func publish(ch Publisher, exchange, key string, msg amqp.Publishing, opts []PublishOption) error {
+	cfg := publishConfig{}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if cfg.limiter != nil && !cfg.limiter.Allow(cfg.limiterKey) {
+		return ErrRateLimited
+	}
+
+	if cfg.version > 0 {
+		if msg.Headers == nil {
+			msg.Headers = amqp.Table{}
+		}
+		msg.Headers[headerVersion] = int32(cfg.version)
+	}
+
+	// compress before encrypting, ciphertext doesn't compress
+	if cfg.compression != nil {
+		err := cfg.compression.compress(&msg)
+		if err != nil {
+			return err
+		}
+	}
+
+	if cfg.encryption != nil {
+		err := cfg.encryption.encrypt(exchange, key, &msg)
+		if err != nil {
+			return err
+		}
+	}
+
+	// signing goes last so the signature covers the final body
+	if cfg.signer != nil {
+		err := cfg.signer.sign(exchange, key, &msg)
+		if err != nil {
+			return err
+		}
+	}
+
 	return ch.PublishWithContext(
 		context.Background(),
 		exchange,
 		key,
 		false,
 		false,
-		amqp.Publishing{ContentType: "application/gob", Body: network.Bytes()},
+		msg,
 	)
 }
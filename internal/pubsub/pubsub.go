@@ -4,6 +4,21 @@ import (
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
+// Transport is a broker connection that messages can be published to and
+// consumed from. Deliveries are amqp.Delivery values whatever the broker,
+// with an Acknowledger that maps Ack and Nack onto the broker's own acks.
+type Transport interface {
+	Publisher
+	Consume(exchange, queueName, key string, simpleQueueType QueueType) (<-chan amqp.Delivery, error)
+	Close() error
+}
+
+// prefetchCount is how many unacknowledged messages a consumer may hold
+const prefetchCount = 10
+
+// deadLetterExchange receives messages that are nacked without requeueing
+const deadLetterExchange = "peril_dlx"
+
 type QueueType int
 
 const (
@@ -38,7 +53,7 @@ func DeclareAndBind(
 		simpleQueueType == Transient,
 		simpleQueueType == Transient,
 		false,
-		amqp.Table{"x-dead-letter-exchange": "peril_dlx"},
+		amqp.Table{"x-dead-letter-exchange": deadLetterExchange},
 	)
 	if err != nil {
 		return nil, amqp.Queue{}, err
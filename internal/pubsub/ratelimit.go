@@ -0,0 +1,127 @@
+package pubsub
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// RateLimiter keeps a token bucket per key, usually a username. Each bucket
+// holds up to burst tokens and refills at rate tokens per second. Keys that
+// have been idle long enough for their bucket to refill are forgotten, so
+// the limiter doesn't grow with every key it has ever seen.
+type RateLimiter struct {
+	rate      float64
+	burst     float64
+	mu        *sync.Mutex
+	buckets   map[string]*bucket
+	dropped   map[string]int
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	return &RateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		mu:      &sync.Mutex{},
+		buckets: map[string]*bucket{},
+		dropped: map[string]int{},
+		now:     time.Now,
+	}
+}
+
+// Allow takes a token from key's bucket, and reports false if it is empty
+func (rl *RateLimiter) Allow(key string) bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.now()
+	rl.sweep(now)
+
+	b, ok := rl.buckets[key]
+	if !ok {
+		b = &bucket{tokens: rl.burst, last: now}
+		rl.buckets[key] = b
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * rl.rate
+	if b.tokens > rl.burst {
+		b.tokens = rl.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		rl.dropped[key]++
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// sweep forgets keys whose buckets would be full again, since a full bucket
+// is no different from a new one. It runs at most once per refill time so
+// Allow stays cheap. rl.mu must be held.
+func (rl *RateLimiter) sweep(now time.Time) {
+	if rl.rate <= 0 {
+		return
+	}
+	refill := time.Duration(rl.burst / rl.rate * float64(time.Second))
+	if now.Sub(rl.lastSweep) < refill {
+		return
+	}
+	rl.lastSweep = now
+
+	for key, b := range rl.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*rl.rate >= rl.burst {
+			delete(rl.buckets, key)
+			delete(rl.dropped, key)
+		}
+	}
+}
+
+// Offenders returns how many messages each key has had refused. A key's
+// count is forgotten along with its bucket once it has been idle for the
+// time it takes to refill.
+func (rl *RateLimiter) Offenders() map[string]int {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	offenders := map[string]int{}
+	for k, v := range rl.dropped {
+		offenders[k] = v
+	}
+	return offenders
+}
+
+// WithRateLimit refuses to publish with ErrRateLimited once key has used up
+// its quota on limiter
+func WithRateLimit(limiter *RateLimiter, key string) PublishOption {
+	return func(cfg *publishConfig) {
+		cfg.limiter = limiter
+		cfg.limiterKey = key
+	}
+}
+
+// RateLimit wraps handler so messages whose key, as returned by keyOf, has
+// exceeded its quota are answered with reject instead of being handled.
+// Use NackDiscard to dead-letter them, or Ack to drop them.
+func RateLimit[T any](
+	limiter *RateLimiter,
+	keyOf func(T) string,
+	reject Acktype,
+	handler func(T) Acktype,
+) func(T) Acktype {
+	return func(val T) Acktype {
+		if !limiter.Allow(keyOf(val)) {
+			return reject
+		}
+		return handler(val)
+	}
+}
@@ -0,0 +1,143 @@
+package pubsub
+
+import (
+	"testing"
+	"time"
+)
+
+// testClock is a clock that only moves when told to
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newTestLimiter(rate float64, burst int) (*RateLimiter, *testClock) {
+	clock := &testClock{now: time.Unix(0, 0)}
+	rl := NewRateLimiter(rate, burst)
+	rl.now = func() time.Time { return clock.now }
+	return rl, clock
+}
+
+// allowed counts how many of n messages from key get through
+func allowed(rl *RateLimiter, key string, n int) int {
+	count := 0
+	for i := 0; i < n; i++ {
+		if rl.Allow(key) {
+			count++
+		}
+	}
+	return count
+}
+
+func TestRateLimiter(t *testing.T) {
+	tests := []struct {
+		name  string
+		idle  time.Duration
+		tries int
+		want  int
+	}{
+		{"no refill", 0, 10, 0},
+		{"partial refill", 500 * time.Millisecond, 10, 1},
+		{"one token", time.Second, 10, 2},
+		{"refill caps at burst", time.Hour, 10, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// two tokens a second, up to three at once
+			rl, clock := newTestLimiter(2, 3)
+			if got := allowed(rl, "bob", 3); got != 3 {
+				t.Fatalf("allowed %d of a full bucket, want 3", got)
+			}
+
+			clock.advance(tt.idle)
+			if got := allowed(rl, "bob", tt.tries); got != tt.want {
+				t.Errorf("allowed %d after %v, want %d", got, tt.idle, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimiterKeysAreIndependent(t *testing.T) {
+	rl, _ := newTestLimiter(1, 2)
+	if got := allowed(rl, "bob", 5); got != 2 {
+		t.Errorf("allowed %d for bob, want 2", got)
+	}
+	if got := allowed(rl, "alice", 1); got != 1 {
+		t.Errorf("allowed %d for alice after bob ran out, want 1", got)
+	}
+
+	offenders := rl.Offenders()
+	if len(offenders) != 1 || offenders["bob"] != 3 {
+		t.Errorf("offenders = %v, want bob with 3", offenders)
+	}
+}
+
+func TestRateLimiterForgetsIdleKeys(t *testing.T) {
+	rl, clock := newTestLimiter(1, 2)
+	allowed(rl, "bob", 5)
+	allowed(rl, "alice", 2)
+
+	// alice keeps publishing faster than the bucket refills, so it never
+	// fills back up
+	for i := 0; i < 4; i++ {
+		clock.advance(time.Second)
+		allowed(rl, "alice", 2)
+	}
+
+	rl.mu.Lock()
+	_, bob := rl.buckets["bob"]
+	_, alice := rl.buckets["alice"]
+	rl.mu.Unlock()
+	if bob {
+		t.Error("kept bob's bucket after it refilled")
+	}
+	if !alice {
+		t.Error("forgot alice's bucket while it was in use")
+	}
+	offenders := rl.Offenders()
+	if _, ok := offenders["bob"]; ok {
+		t.Errorf("offenders = %v, kept bob's count after going idle", offenders)
+	}
+	if offenders["alice"] != 4 {
+		t.Errorf("offenders = %v, want alice with 4", offenders)
+	}
+
+	// a forgotten key starts again with a full bucket
+	if got := allowed(rl, "bob", 5); got != 2 {
+		t.Errorf("allowed %d for bob after being forgotten, want 2", got)
+	}
+}
+
+func TestWithRateLimit(t *testing.T) {
+	rl, _ := newTestLimiter(1, 1)
+	transport := openTestLog(t, t.TempDir(), 1)
+
+	err := PublishJSON(transport, "peril_topic", "game_logs.bob", "one", WithRateLimit(rl, "bob"))
+	if err != nil {
+		t.Fatalf("PublishJSON: %v", err)
+	}
+	err = PublishJSON(transport, "peril_topic", "game_logs.bob", "two", WithRateLimit(rl, "bob"))
+	if err != ErrRateLimited {
+		t.Errorf("PublishJSON over the limit error = %v, want ErrRateLimited", err)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	rl, _ := newTestLimiter(1, 1)
+	var handled []string
+	handler := RateLimit(rl, func(s string) string { return s }, NackDiscard, func(s string) Acktype {
+		handled = append(handled, s)
+		return Ack
+	})
+
+	acks := []Acktype{handler("bob"), handler("bob"), handler("alice")}
+	if acks[0] != Ack || acks[1] != NackDiscard || acks[2] != Ack {
+		t.Errorf("acktypes = %v, want Ack, NackDiscard, Ack", acks)
+	}
+	if len(handled) != 2 || handled[0] != "bob" || handled[1] != "alice" {
+		t.Errorf("handled %v, want bob then alice", handled)
+	}
+}
@@ -0,0 +1,487 @@
+package pubsub
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing/topic"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// redisGroup is the consumer group every queue stream is read through
+	redisGroup = "peril"
+	// redisMaxLen caps each stream so acked entries don't pile up forever
+	redisMaxLen = 10000
+	// redisBlock is how long a read waits for new entries before checking
+	// for abandoned ones
+	redisBlock = time.Second
+	// redisClaimIdle is how long an entry may sit unacked with a consumer
+	// before another consumer of the queue takes it over
+	redisClaimIdle = 30 * time.Second
+	// redisAliveTTL is how long a transient queue outlives its last
+	// heartbeat, so ones left behind by a crash are swept after it
+	redisAliveTTL = 30 * time.Second
+	redisTimeout  = 10 * time.Second
+)
+
+// redisDeadLetterStream receives discarded messages along with the queue
+// that discarded them
+const redisDeadLetterStream = "peril:dlx"
+
+var errRedisClosed = errors.New("redis transport is closed")
+
+// RedisTransport is a Transport over Redis Streams. Every queue is a stream
+// read through a consumer group, so everyone consuming a Durable queue
+// competes for its entries. Bindings live in a hash per exchange, which
+// publishers read to add each message to the streams of matching queues;
+// binding keys are matched with topic exchange rules. Transient queues are
+// deleted along with their binding when the transport is closed, and keep
+// a heartbeat key alive until then; publishers and new consumers sweep the
+// ones whose heartbeat has expired.
+type RedisTransport struct {
+	client   *redis.Client
+	consumer string
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       *sync.WaitGroup
+
+	mu        *sync.Mutex
+	nextTag   uint64
+	pending   map[uint64]redisPending
+	transient map[string]string
+	consumers []chan amqp.Delivery
+}
+
+type redisPending struct {
+	stream     string
+	msg        redis.XMessage
+	deliveries chan amqp.Delivery
+}
+
+// DialRedis connects to Redis, e.g. "redis://localhost:6379/0"
+func DialRedis(url string) (*RedisTransport, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	client := redis.NewClient(opts)
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	err = client.Ping(ctx).Err()
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	id := make([]byte, 8)
+	_, err = rand.Read(id)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	t := &RedisTransport{
+		client:    client,
+		consumer:  hex.EncodeToString(id),
+		wg:        &sync.WaitGroup{},
+		mu:        &sync.Mutex{},
+		pending:   map[uint64]redisPending{},
+		transient: map[string]string{},
+	}
+	t.ctx, t.cancel = context.WithCancel(context.Background())
+	return t, nil
+}
+
+func (t *RedisTransport) PublishWithContext(
+	ctx context.Context,
+	exchange,
+	key string,
+	mandatory,
+	immediate bool,
+	msg amqp.Publishing,
+) error {
+	bindings, err := t.client.HGetAll(ctx, redisBindingsKey(exchange)).Result()
+	if err != nil {
+		return err
+	}
+	matching := map[string]bool{}
+	for queueName, pattern := range bindings {
+		if topic.Match(pattern, key) {
+			matching[queueName] = true
+		}
+	}
+	dead, err := t.sweep(ctx, exchange, func(queueName string) bool { return matching[queueName] })
+	if err != nil {
+		return err
+	}
+
+	values, err := redisValues(exchange, key, msg)
+	if err != nil {
+		return err
+	}
+
+	pipe := t.client.Pipeline()
+	for queueName := range matching {
+		if dead[queueName] {
+			continue
+		}
+		pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: redisQueueKey(queueName),
+			MaxLen: redisMaxLen,
+			Approx: true,
+			Values: values,
+		})
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (t *RedisTransport) Consume(exchange, queueName, key string, simpleQueueType QueueType) (<-chan amqp.Delivery, error) {
+	ctx, cancel := context.WithTimeout(t.ctx, redisTimeout)
+	defer cancel()
+
+	// a transient queue is alive before it's bound, so it's never swept
+	// while being declared
+	alive := ""
+	if simpleQueueType == Transient {
+		alive = redisAliveKey(queueName)
+		err := t.client.Set(ctx, alive, exchange, redisAliveTTL).Err()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to declare queue %s: %v", queueName, err)
+		}
+		err = t.client.SAdd(ctx, redisTransientKey(exchange), queueName).Err()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to declare queue %s: %v", queueName, err)
+		}
+	}
+	_, err := t.sweep(ctx, exchange, func(string) bool { return true })
+	if err != nil {
+		return nil, fmt.Errorf("could not sweep dead queues of %s: %v", exchange, err)
+	}
+
+	stream := redisQueueKey(queueName)
+	err = t.client.XGroupCreateMkStream(ctx, stream, redisGroup, "$").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil, fmt.Errorf("Failed to declare queue %s: %v", queueName, err)
+	}
+	err = t.client.HSet(ctx, redisBindingsKey(exchange), queueName, key).Err()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to bind queue %s: %v", queueName, err)
+	}
+	if simpleQueueType == Transient {
+		t.mu.Lock()
+		t.transient[queueName] = exchange
+		t.mu.Unlock()
+	}
+
+	deliveries := make(chan amqp.Delivery, prefetchCount)
+	t.mu.Lock()
+	if t.ctx.Err() != nil {
+		t.mu.Unlock()
+		return nil, errRedisClosed
+	}
+	t.consumers = append(t.consumers, deliveries)
+	t.wg.Add(1)
+	t.mu.Unlock()
+	go func() {
+		defer t.wg.Done()
+		t.read(stream, alive, deliveries)
+	}()
+	return deliveries, nil
+}
+
+func (t *RedisTransport) Close() error {
+	// cancelling under mu means nothing adds to wg once Wait has started
+	t.mu.Lock()
+	t.cancel()
+	t.mu.Unlock()
+	// deliveries are only closed once nothing can send on them
+	t.wg.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	t.mu.Lock()
+	for _, deliveries := range t.consumers {
+		close(deliveries)
+	}
+	t.consumers = nil
+	for queueName, exchange := range t.transient {
+		t.client.HDel(ctx, redisBindingsKey(exchange), queueName)
+		t.client.SRem(ctx, redisTransientKey(exchange), queueName)
+		t.client.Del(ctx, redisQueueKey(queueName), redisAliveKey(queueName))
+	}
+	t.mu.Unlock()
+	return t.client.Close()
+}
+
+// Ack, Nack and Reject make RedisTransport the amqp.Acknowledger of its own
+// deliveries. Requeued entries are claimed again with XCLAIM, which bumps
+// their delivery count, and handed back to the subscriber; discarded ones
+// are copied to the dead-letter stream and acked.
+func (t *RedisTransport) Ack(tag uint64, multiple bool) error {
+	p, err := t.take(tag)
+	if err != nil {
+		return err
+	}
+	return t.client.XAck(t.ctx, p.stream, redisGroup, p.msg.ID).Err()
+}
+
+func (t *RedisTransport) Nack(tag uint64, multiple, requeue bool) error {
+	p, err := t.take(tag)
+	if err != nil {
+		return err
+	}
+
+	if requeue {
+		msgs, err := t.client.XClaim(t.ctx, &redis.XClaimArgs{
+			Stream:   p.stream,
+			Group:    redisGroup,
+			Consumer: t.consumer,
+			Messages: []string{p.msg.ID},
+		}).Result()
+		if err != nil {
+			return err
+		}
+		// the subscriber may be the one blocked reading deliveries
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if t.ctx.Err() != nil {
+			// left pending, for another consumer to claim
+			return errRedisClosed
+		}
+		for _, m := range msgs {
+			t.wg.Add(1)
+			go func() {
+				defer t.wg.Done()
+				t.deliver(p.stream, m, true, p.deliveries)
+			}()
+		}
+		return nil
+	}
+
+	values := map[string]any{"queue": strings.TrimPrefix(p.stream, "peril:queue:")}
+	for k, v := range p.msg.Values {
+		values[k] = v
+	}
+	err = t.client.XAdd(t.ctx, &redis.XAddArgs{
+		Stream: redisDeadLetterStream,
+		MaxLen: redisMaxLen,
+		Approx: true,
+		Values: values,
+	}).Err()
+	if err != nil {
+		return err
+	}
+	return t.client.XAck(t.ctx, p.stream, redisGroup, p.msg.ID).Err()
+}
+
+func (t *RedisTransport) Reject(tag uint64, requeue bool) error {
+	return t.Nack(tag, false, requeue)
+}
+
+// read delivers new entries of stream, and takes over entries other
+// consumers have left unacked for too long, until the transport is closed.
+// It also keeps the alive key of a transient queue from expiring.
+func (t *RedisTransport) read(stream, alive string, deliveries chan amqp.Delivery) {
+	lastClaim := time.Now()
+	lastBeat := time.Now()
+	for t.ctx.Err() == nil {
+		if alive != "" && time.Since(lastBeat) >= redisAliveTTL/3 {
+			lastBeat = time.Now()
+			t.client.Expire(t.ctx, alive, redisAliveTTL)
+		}
+		res, err := t.client.XReadGroup(t.ctx, &redis.XReadGroupArgs{
+			Group:    redisGroup,
+			Consumer: t.consumer,
+			Streams:  []string{stream, ">"},
+			Count:    prefetchCount,
+			Block:    redisBlock,
+		}).Result()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			select {
+			case <-t.ctx.Done():
+			case <-time.After(redisBlock):
+			}
+			continue
+		}
+		for _, s := range res {
+			for _, m := range s.Messages {
+				t.deliver(stream, m, false, deliveries)
+			}
+		}
+
+		if time.Since(lastClaim) < redisClaimIdle {
+			continue
+		}
+		lastClaim = time.Now()
+		msgs, _, err := t.client.XAutoClaim(t.ctx, &redis.XAutoClaimArgs{
+			Stream:   stream,
+			Group:    redisGroup,
+			MinIdle:  redisClaimIdle,
+			Start:    "0",
+			Count:    prefetchCount,
+			Consumer: t.consumer,
+		}).Result()
+		if err != nil {
+			continue
+		}
+		for _, m := range msgs {
+			t.deliver(stream, m, true, deliveries)
+		}
+	}
+}
+
+func (t *RedisTransport) deliver(stream string, m redis.XMessage, redelivered bool, deliveries chan amqp.Delivery) {
+	d := redisDelivery(m)
+	d.Acknowledger = t
+	d.Redelivered = redelivered
+
+	t.mu.Lock()
+	t.nextTag++
+	d.DeliveryTag = t.nextTag
+	t.pending[d.DeliveryTag] = redisPending{stream: stream, msg: m, deliveries: deliveries}
+	t.mu.Unlock()
+
+	select {
+	case deliveries <- d:
+	case <-t.ctx.Done():
+	}
+}
+
+// sweep deletes the transient queues of exchange picked by check whose
+// heartbeat has expired, along with their binding, and returns them. The
+// heartbeat is watched so a queue declared again meanwhile is left alone.
+func (t *RedisTransport) sweep(ctx context.Context, exchange string, check func(queueName string) bool) (map[string]bool, error) {
+	transient, err := t.client.SMembers(ctx, redisTransientKey(exchange)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	pipe := t.client.Pipeline()
+	exists := map[string]*redis.IntCmd{}
+	for _, queueName := range transient {
+		if check(queueName) {
+			exists[queueName] = pipe.Exists(ctx, redisAliveKey(queueName))
+		}
+	}
+	if len(exists) == 0 {
+		return nil, nil
+	}
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	dead := map[string]bool{}
+	for queueName, n := range exists {
+		if n.Val() > 0 {
+			continue
+		}
+		alive := redisAliveKey(queueName)
+		err := t.client.Watch(ctx, func(tx *redis.Tx) error {
+			n, err := tx.Exists(ctx, alive).Result()
+			if err != nil || n > 0 {
+				return err
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.HDel(ctx, redisBindingsKey(exchange), queueName)
+				pipe.SRem(ctx, redisTransientKey(exchange), queueName)
+				pipe.Del(ctx, redisQueueKey(queueName))
+				return nil
+			})
+			if err == nil {
+				dead[queueName] = true
+			}
+			return err
+		}, alive)
+		if err != nil && !errors.Is(err, redis.TxFailedErr) {
+			return nil, err
+		}
+	}
+	return dead, nil
+}
+
+func (t *RedisTransport) take(tag uint64) (redisPending, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, ok := t.pending[tag]
+	if !ok {
+		return redisPending{}, fmt.Errorf("unknown delivery tag %d", tag)
+	}
+	delete(t.pending, tag)
+	return p, nil
+}
+
+func redisBindingsKey(exchange string) string {
+	return "peril:bindings:" + exchange
+}
+
+func redisQueueKey(queueName string) string {
+	return "peril:queue:" + queueName
+}
+
+// redisTransientKey is the set of transient queues bound to exchange
+func redisTransientKey(exchange string) string {
+	return "peril:transient:" + exchange
+}
+
+// redisAliveKey is the heartbeat of a transient queue
+func redisAliveKey(queueName string) string {
+	return "peril:alive:" + queueName
+}
+
+// redisValues flattens a publishing into stream entry fields
+func redisValues(exchange, key string, msg amqp.Publishing) (map[string]any, error) {
+	values := map[string]any{
+		"exchange":         exchange,
+		"key":              key,
+		"content_type":     msg.ContentType,
+		"content_encoding": msg.ContentEncoding,
+		"body":             msg.Body,
+	}
+	if len(msg.Headers) > 0 {
+		headers := map[string]string{}
+		for k, v := range msg.Headers {
+			headers[k] = fmt.Sprint(v)
+		}
+		b, err := json.Marshal(headers)
+		if err != nil {
+			return nil, err
+		}
+		values["headers"] = string(b)
+	}
+	return values, nil
+}
+
+func redisDelivery(m redis.XMessage) amqp.Delivery {
+	field := func(name string) string {
+		s, _ := m.Values[name].(string)
+		return s
+	}
+
+	d := amqp.Delivery{
+		Exchange:        field("exchange"),
+		RoutingKey:      field("key"),
+		ContentType:     field("content_type"),
+		ContentEncoding: field("content_encoding"),
+		Body:            []byte(field("body")),
+	}
+	var headers map[string]string
+	if json.Unmarshal([]byte(field("headers")), &headers) == nil && len(headers) > 0 {
+		d.Headers = amqp.Table{}
+		for k, v := range headers {
+			d.Headers[k] = v
+		}
+	}
+	return d
+}
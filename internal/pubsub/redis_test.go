@@ -0,0 +1,230 @@
+package pubsub
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// startRedis runs an in-process Redis and returns it with its URL
+func startRedis(t *testing.T) (*miniredis.Miniredis, string) {
+	t.Helper()
+	m := miniredis.RunT(t)
+	return m, "redis://" + m.Addr()
+}
+
+func dialTestRedis(t *testing.T, url string) *RedisTransport {
+	t.Helper()
+	transport, err := Dial(url)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	rt, ok := transport.(*RedisTransport)
+	if !ok {
+		t.Fatalf("Dial returned a %T, want a *RedisTransport", transport)
+	}
+	t.Cleanup(func() { transport.Close() })
+	return rt
+}
+
+func TestRedisConformance(t *testing.T) {
+	testTransport(t, func(t *testing.T) func() Transport {
+		_, url := startRedis(t)
+		return func() Transport { return dialTestRedis(t, url) }
+	})
+}
+
+func TestRedisNackDiscardDeadLetters(t *testing.T) {
+	m, url := startRedis(t)
+	transport := dialTestRedis(t, url)
+
+	err := SubscribeJSON(
+		transport,
+		"peril_topic",
+		"moves",
+		"army_moves.*.*",
+		Durable,
+		func(string) Acktype { return NackDiscard },
+	)
+	if err != nil {
+		t.Fatalf("SubscribeJSON: %v", err)
+	}
+	err = PublishJSON(transport, "peril_topic", "army_moves.europe.bob", "move")
+	if err != nil {
+		t.Fatalf("PublishJSON: %v", err)
+	}
+
+	// discarded entries are acked once they're copied to the dead letters
+	eventually(t, "the entry to be acked", func() bool {
+		pending, err := transport.client.XPending(transport.ctx, redisQueueKey("moves"), redisGroup).Result()
+		return err == nil && pending.Count == 0 && m.Exists(redisDeadLetterStream)
+	})
+	dead, err := m.Stream(redisDeadLetterStream)
+	if err != nil || len(dead) != 1 {
+		t.Fatalf("%d dead-lettered entries (%v), want 1", len(dead), err)
+	}
+	values := map[string]string{}
+	for i := 0; i+1 < len(dead[0].Values); i += 2 {
+		values[dead[0].Values[i]] = dead[0].Values[i+1]
+	}
+	if values["queue"] != "moves" || values["key"] != "army_moves.europe.bob" {
+		t.Errorf("dead-lettered from queue %q with key %q", values["queue"], values["key"])
+	}
+}
+
+func TestRedisCloseRemovesTransientQueues(t *testing.T) {
+	m, url := startRedis(t)
+	transport, err := DialRedis(url)
+	if err != nil {
+		t.Fatalf("DialRedis: %v", err)
+	}
+
+	deliveries, err := transport.Consume("peril_direct", "pause.bob", "pause", Transient)
+	if err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+	_, err = transport.Consume("peril_topic", "moves", "army_moves.*.*", Durable)
+	if err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+
+	err = transport.Close()
+	if err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, ok := <-deliveries; ok {
+		t.Error("deliveries weren't closed")
+	}
+	if m.Exists(redisQueueKey("pause.bob")) || m.HGet(redisBindingsKey("peril_direct"), "pause.bob") != "" {
+		t.Error("transient queue outlived the transport")
+	}
+	if m.Exists(redisAliveKey("pause.bob")) || m.Exists(redisTransientKey("peril_direct")) {
+		t.Error("transient queue's heartbeat outlived the transport")
+	}
+	if !m.Exists(redisQueueKey("moves")) || m.HGet(redisBindingsKey("peril_topic"), "moves") == "" {
+		t.Error("durable queue went away with the transport")
+	}
+	if _, err := transport.Consume("peril_topic", "moves", "army_moves.*.*", Durable); err == nil {
+		t.Error("consumed from a closed transport")
+	}
+}
+
+// a transport that crashes never deletes its transient queues, so they're
+// swept once their heartbeat expires instead
+func TestRedisSweepsDeadTransientQueues(t *testing.T) {
+	m, url := startRedis(t)
+	crashed, err := DialRedis(url)
+	if err != nil {
+		t.Fatalf("DialRedis: %v", err)
+	}
+	for queueName, key := range map[string]string{"pause.bob": "pause.*", "logs.bob": "game_logs.#"} {
+		_, err := crashed.Consume("peril_topic", queueName, key, Transient)
+		if err != nil {
+			t.Fatalf("Consume: %v", err)
+		}
+	}
+	crashed.cancel()
+	crashed.wg.Wait()
+	crashed.client.Close()
+
+	transport := dialTestRedis(t, url)
+	_, err = transport.Consume("peril_topic", "pause.alice", "pause.*", Transient)
+	if err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+
+	// still alive, so both queues get the pause
+	err = PublishJSON(transport, "peril_topic", "pause.europe", true)
+	if err != nil {
+		t.Fatalf("PublishJSON: %v", err)
+	}
+	for _, queueName := range []string{"pause.bob", "pause.alice"} {
+		if entries, _ := m.Stream(redisQueueKey(queueName)); len(entries) != 1 {
+			t.Errorf("%s has %d entries, want 1", queueName, len(entries))
+		}
+	}
+
+	// the live transport's own heartbeat is refreshed as it reads, but
+	// nothing refreshes the crashed one's
+	m.FastForward(redisAliveTTL)
+	m.Set(redisAliveKey("pause.alice"), "peril_topic")
+
+	err = PublishJSON(transport, "peril_topic", "pause.europe", true)
+	if err != nil {
+		t.Fatalf("PublishJSON: %v", err)
+	}
+	if m.Exists(redisQueueKey("pause.bob")) || m.HGet(redisBindingsKey("peril_topic"), "pause.bob") != "" {
+		t.Error("publishing kept the dead pause.bob queue")
+	}
+	if entries, _ := m.Stream(redisQueueKey("pause.alice")); len(entries) != 2 {
+		t.Errorf("pause.alice has %d entries, want 2", len(entries))
+	}
+	if !m.Exists(redisQueueKey("logs.bob")) {
+		t.Error("publishing swept logs.bob, which it didn't publish to")
+	}
+
+	// consuming sweeps every dead queue of the exchange
+	_, err = transport.Consume("peril_topic", "moves", "army_moves.*.*", Durable)
+	if err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+	if m.Exists(redisQueueKey("logs.bob")) || m.HGet(redisBindingsKey("peril_topic"), "logs.bob") != "" {
+		t.Error("consuming kept the dead logs.bob queue")
+	}
+	if members, _ := m.Members(redisTransientKey("peril_topic")); len(members) != 1 || members[0] != "pause.alice" {
+		t.Errorf("transient queues %v, want pause.alice", members)
+	}
+}
+
+// requeued entries are handed back on their own goroutines, which Close
+// has to wait for without racing new ones being started
+func TestRedisCloseWhileRequeueing(t *testing.T) {
+	_, url := startRedis(t)
+	transport, err := DialRedis(url)
+	if err != nil {
+		t.Fatalf("DialRedis: %v", err)
+	}
+
+	delivered := make(chan struct{}, 100)
+	err = SubscribeJSON(
+		transport,
+		"peril_topic",
+		"moves",
+		"army_moves.*.*",
+		Durable,
+		func(string) Acktype {
+			select {
+			case delivered <- struct{}{}:
+			default:
+			}
+			return NackRequeue
+		},
+	)
+	if err != nil {
+		t.Fatalf("SubscribeJSON: %v", err)
+	}
+	for n := 0; n < 10; n++ {
+		err := PublishJSON(transport, "peril_topic", fmt.Sprintf("army_moves.europe.p%d", n), "move")
+		if err != nil {
+			t.Fatalf("PublishJSON: %v", err)
+		}
+	}
+
+	for i := 0; i < 20; i++ {
+		select {
+		case <-delivered:
+		case <-time.After(5 * time.Second):
+			t.Fatal("messages were never delivered")
+		}
+	}
+
+	closed := make(chan error, 1)
+	go func() { closed <- transport.Close() }()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close never returned")
+	}
+}
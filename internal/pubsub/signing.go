@@ -0,0 +1,170 @@
+package pubsub
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	headerSigner      = "x-peril-signer"
+	headerSignerKey   = "x-peril-signer-key"
+	headerSignerCert  = "x-peril-signer-cert"
+	headerSignature   = "x-peril-signature"
+	certificatePrefix = "peril-cert"
+	versionPrefix     = "peril-version"
+)
+
+// Credentials are issued by the server to a single player. The certificate
+// is the server's signature over the player's username and public key, so
+// subscribers only need the server's public key to verify any player.
+type Credentials struct {
+	Username    string
+	PrivateKey  ed25519.PrivateKey
+	Certificate []byte
+}
+
+// Attributed is implemented by messages that name the player who sent them.
+// Verified subscribers reject messages whose sender is not the signer.
+type Attributed interface {
+	Sender() string
+}
+
+// IssueCredentials creates a new key pair for username and certifies it
+// with the server's authority key
+func IssueCredentials(authority ed25519.PrivateKey, username string) (Credentials, error) {
+	if username == "" {
+		return Credentials{}, errors.New("username is required")
+	}
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	return Credentials{
+		Username:    username,
+		PrivateKey:  priv,
+		Certificate: ed25519.Sign(authority, certificatePayload(username, pub)),
+	}, nil
+}
+
+// String encodes the credentials so they can be handed to a player
+func (c Credentials) String() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// ParseCredentials decodes credentials produced by Credentials.String
+func ParseCredentials(s string) (Credentials, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("could not decode credentials: %v", err)
+	}
+
+	var c Credentials
+	err = json.Unmarshal(b, &c)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("could not decode credentials: %v", err)
+	}
+	if len(c.PrivateKey) != ed25519.PrivateKeySize {
+		return Credentials{}, errors.New("credentials have an invalid private key")
+	}
+	return c, nil
+}
+
+func (c Credentials) sign(exchange, key string, msg *amqp.Publishing) error {
+	if msg.Headers == nil {
+		msg.Headers = amqp.Table{}
+	}
+
+	pub, ok := c.PrivateKey.Public().(ed25519.PublicKey)
+	if !ok {
+		return errors.New("credentials have an invalid private key")
+	}
+
+	// headers are base64 strings rather than byte arrays so they survive
+	// transports whose headers are text, like STOMP
+	msg.Headers[headerSigner] = c.Username
+	msg.Headers[headerSignerKey] = base64.StdEncoding.EncodeToString(pub)
+	msg.Headers[headerSignerCert] = base64.StdEncoding.EncodeToString(c.Certificate)
+	msg.Headers[headerSignature] = base64.StdEncoding.EncodeToString(ed25519.Sign(
+		c.PrivateKey,
+		signaturePayload(exchange, key, msg.ContentType, msg.ContentEncoding, msg.Headers, msg.Body),
+	))
+	return nil
+}
+
+// verifySignature checks that the delivery was signed by a player certified
+// by authority and returns that player's username
+func verifySignature(authority ed25519.PublicKey, d amqp.Delivery) (string, error) {
+	signer, ok := d.Headers[headerSigner].(string)
+	if !ok || signer == "" {
+		return "", errors.New("message is not signed")
+	}
+	pub := headerBytes(d.Headers, headerSignerKey)
+	cert := headerBytes(d.Headers, headerSignerCert)
+	signature := headerBytes(d.Headers, headerSignature)
+
+	if len(pub) != ed25519.PublicKeySize {
+		return "", fmt.Errorf("message from %s has an invalid signer key", signer)
+	}
+	if !ed25519.Verify(authority, certificatePayload(signer, pub), cert) {
+		return "", fmt.Errorf("signer %s is not certified by the server", signer)
+	}
+
+	payload := signaturePayload(d.Exchange, d.RoutingKey, d.ContentType, d.ContentEncoding, d.Headers, d.Body)
+	if !ed25519.Verify(pub, payload, signature) {
+		return "", fmt.Errorf("message from %s has an invalid signature", signer)
+	}
+	return signer, nil
+}
+
+// headerBytes decodes a base64 header, returning nil if it is missing or
+// malformed
+func headerBytes(headers amqp.Table, name string) []byte {
+	s, ok := headers[name].(string)
+	if !ok {
+		return nil
+	}
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+func certificatePayload(username string, pub ed25519.PublicKey) []byte {
+	var b bytes.Buffer
+	b.WriteString(certificatePrefix)
+	b.WriteByte(0)
+	b.WriteString(username)
+	b.WriteByte(0)
+	b.Write(pub)
+	return b.Bytes()
+}
+
+// signaturePayload is what a signature covers: everything that decides how
+// the body is routed and decoded, including its version, since subscribers
+// decode and validate each version differently. Payloads without a version
+// header were signed without one by binaries that predate versions, so
+// theirs still verify; the prefix keeps the two forms from colliding.
+func signaturePayload(exchange, key, contentType, contentEncoding string, headers amqp.Table, body []byte) []byte {
+	var b bytes.Buffer
+	if _, ok := headers[headerVersion]; ok {
+		b.WriteString(versionPrefix + strconv.Itoa(MessageVersion(headers)))
+		b.WriteByte(0)
+	}
+	for _, field := range []string{exchange, key, contentType, contentEncoding} {
+		b.WriteString(field)
+		b.WriteByte(0)
+	}
+	b.Write(body)
+	return b.Bytes()
+}
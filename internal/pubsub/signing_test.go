@@ -0,0 +1,280 @@
+package pubsub
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+type signingTestDelta struct {
+	Username string
+	Reset    bool
+}
+
+type signingTestMove struct {
+	Username string
+	Units    int
+}
+
+func (m signingTestMove) Sender() string {
+	return m.Username
+}
+
+// tamperingPublisher changes messages after they're signed
+type tamperingPublisher struct {
+	Publisher
+	tamper func(*amqp.Publishing)
+}
+
+func (p tamperingPublisher) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	p.tamper(&msg)
+	return p.Publisher.PublishWithContext(ctx, exchange, key, mandatory, immediate, msg)
+}
+
+func TestVerificationDeadLetters(t *testing.T) {
+	_, authority, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherAuthority, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := IssueCredentials(authority, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mallory, err := IssueCredentials(authority, "mallory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged, err := IssueCredentials(otherAuthority, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		signer *Credentials
+		tamper func(*amqp.Publishing)
+		want   bool
+	}{
+		{"signed", &bob, nil, true},
+		{"unsigned", nil, nil, false},
+		{"tampered body", &bob, func(msg *amqp.Publishing) {
+			msg.Body = []byte(`{"Username":"bob","Units":99}`)
+		}, false},
+		{"uncertified key", &bob, func(msg *amqp.Publishing) {
+			// a fresh key signing for itself, with bob's certificate
+			pub, priv, _ := ed25519.GenerateKey(nil)
+			msg.Headers[headerSignerKey] = base64.StdEncoding.EncodeToString(pub)
+			msg.Headers[headerSignature] = base64.StdEncoding.EncodeToString(ed25519.Sign(priv, signaturePayload(
+				"peril_topic", "army_moves.europe.bob", msg.ContentType, msg.ContentEncoding, msg.Headers, msg.Body,
+			)))
+		}, false},
+		{"another authority", &forged, nil, false},
+		{"another sender", &mallory, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport := openTestLog(t, t.TempDir(), 1)
+			dead, err := transport.Consume(deadLetterExchange, "dlq", "#", Durable)
+			if err != nil {
+				t.Fatalf("Consume: %v", err)
+			}
+
+			received := make(chan signingTestMove, 1)
+			err = SubscribeJSON(transport, "peril_topic", "moves", "army_moves.*.*", Durable, func(m signingTestMove) Acktype {
+				received <- m
+				return Ack
+			}, WithVerification(authority.Public().(ed25519.PublicKey)))
+			if err != nil {
+				t.Fatalf("SubscribeJSON: %v", err)
+			}
+
+			var pub Publisher = transport
+			if tt.tamper != nil {
+				pub = tamperingPublisher{Publisher: transport, tamper: tt.tamper}
+			}
+			var opts []PublishOption
+			if tt.signer != nil {
+				opts = append(opts, WithSigner(*tt.signer))
+			}
+			err = PublishJSON(pub, "peril_topic", "army_moves.europe.bob", signingTestMove{Username: "bob", Units: 1}, opts...)
+			if err != nil {
+				t.Fatalf("PublishJSON: %v", err)
+			}
+
+			select {
+			case m := <-received:
+				if !tt.want {
+					t.Errorf("delivered %+v", m)
+				}
+			case d := <-dead:
+				if tt.want {
+					t.Errorf("dead-lettered %s", d.Body)
+				}
+				if d.RoutingKey != "army_moves.europe.bob" {
+					t.Errorf("dead-lettered with key %s", d.RoutingKey)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("neither delivered nor dead-lettered")
+			}
+		})
+	}
+}
+
+// a player's credentials are as good as the server's for the signature
+// check alone, so routes only the server publishes to pin it as the signer
+func TestWithSignedBy(t *testing.T) {
+	_, authority, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify := WithVerification(authority.Public().(ed25519.PublicKey))
+
+	tests := []struct {
+		name   string
+		signer string
+		opts   []SubscribeOption
+		want   bool
+	}{
+		{"server", "server", []SubscribeOption{verify, WithSignedBy("server")}, true},
+		{"another player", "mallory", []SubscribeOption{verify, WithSignedBy("server")}, false},
+		{"without verification", "server", []SubscribeOption{WithSignedBy("server")}, false},
+		{"unpinned", "mallory", []SubscribeOption{verify}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport := openTestLog(t, t.TempDir(), 1)
+			creds, err := IssueCredentials(authority, tt.signer)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			received := make(chan signingTestDelta, 1)
+			err = SubscribeJSON(transport, "peril_topic", "deltas", "deltas.*.*", Durable, func(d signingTestDelta) Acktype {
+				received <- d
+				return Ack
+			}, tt.opts...)
+			if err != nil {
+				t.Fatalf("SubscribeJSON: %v", err)
+			}
+			err = PublishJSON(transport, "peril_topic", "deltas.europe.alice", signingTestDelta{Username: "alice", Reset: true}, WithSigner(creds))
+			if err != nil {
+				t.Fatalf("PublishJSON: %v", err)
+			}
+
+			select {
+			case <-received:
+				if !tt.want {
+					t.Errorf("delta signed by %s was delivered", tt.signer)
+				}
+			case <-time.After(3 * logPollInterval):
+				if tt.want {
+					t.Errorf("delta signed by %s was never delivered", tt.signer)
+				}
+			}
+		})
+	}
+}
+
+// the version decides how a body is decoded and validated, so changing it
+// must break the signature
+func TestSignatureCoversVersion(t *testing.T) {
+	_, authority, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds, err := IssueCredentials(authority, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := amqp.Publishing{
+		ContentType: "application/json",
+		Headers:     amqp.Table{headerVersion: int32(2)},
+		Body:        []byte(`{"IsPaused":true}`),
+	}
+	err = creds.sign("peril_topic", "pause.europe", &msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		version any
+		wantErr bool
+	}{
+		{"as signed", int32(2), false},
+		// text transports carry the header as a string
+		{"as a string", "2", false},
+		{"bumped", int32(99), true},
+		{"removed", nil, true},
+	}
+
+	for _, tt := range tests {
+		headers := amqp.Table{}
+		for k, v := range msg.Headers {
+			headers[k] = v
+		}
+		delete(headers, headerVersion)
+		if tt.version != nil {
+			headers[headerVersion] = tt.version
+		}
+		d := amqp.Delivery{
+			Exchange:    "peril_topic",
+			RoutingKey:  "pause.europe",
+			ContentType: msg.ContentType,
+			Headers:     headers,
+			Body:        msg.Body,
+		}
+		_, err := verifySignature(authority.Public().(ed25519.PublicKey), d)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: verifySignature error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+// binaries that predate versions signed without a version header, and
+// their messages still verify
+func TestUnversionedSignaturesVerify(t *testing.T) {
+	_, authority, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds, err := IssueCredentials(authority, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := amqp.Publishing{ContentType: "application/gob", Body: []byte("v1 log")}
+	err = creds.sign("peril_topic", "game_logs.bob", &msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := amqp.Delivery{
+		Exchange:    "peril_topic",
+		RoutingKey:  "game_logs.bob",
+		ContentType: msg.ContentType,
+		Headers:     msg.Headers,
+		Body:        msg.Body,
+	}
+	if _, err := verifySignature(authority.Public().(ed25519.PublicKey), d); err != nil {
+		t.Errorf("verifySignature: %v", err)
+	}
+
+	// claiming a version afterwards breaks the signature
+	d.Headers = amqp.Table{headerVersion: int32(1)}
+	for k, v := range msg.Headers {
+		d.Headers[k] = v
+	}
+	if _, err := verifySignature(authority.Public().(ed25519.PublicKey), d); err == nil {
+		t.Error("verifySignature accepted an added version header")
+	}
+}
@@ -0,0 +1,378 @@
+package pubsub
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// STOMPTransport is a Transport speaking STOMP 1.2, as served by RabbitMQ's
+// rabbitmq_stomp plugin. Exchanges and routing keys map onto
+// /exchange/<exchange>/<key> destinations, and queue names onto the
+// x-queue-name header so Durable queues are shared with AMQP consumers.
+type STOMPTransport struct {
+	conn    net.Conn
+	writeMu *sync.Mutex
+	w       *bufio.Writer
+
+	mu      *sync.Mutex
+	subs    map[string]chan amqp.Delivery
+	nextSub int
+	nextTag uint64
+	ackIDs  map[uint64]string
+	err     error
+	closed  chan struct{}
+}
+
+// defaultSTOMPPort is where RabbitMQ's STOMP plugin listens by default
+const defaultSTOMPPort = "61613"
+
+type stompFrame struct {
+	command string
+	headers map[string]string
+	body    []byte
+}
+
+// DialSTOMP connects to a STOMP broker at addr, e.g. "localhost:61613"
+func DialSTOMP(addr, login, passcode, vhost string) (*STOMPTransport, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &STOMPTransport{
+		conn:    conn,
+		writeMu: &sync.Mutex{},
+		w:       bufio.NewWriter(conn),
+		mu:      &sync.Mutex{},
+		subs:    map[string]chan amqp.Delivery{},
+		ackIDs:  map[uint64]string{},
+		closed:  make(chan struct{}),
+	}
+
+	err = t.writeFrame(stompFrame{
+		command: "CONNECT",
+		headers: map[string]string{
+			"accept-version": "1.2",
+			"host":           vhost,
+			"login":          login,
+			"passcode":       passcode,
+			"heart-beat":     "0,0",
+		},
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	r := bufio.NewReader(conn)
+	f, err := readSTOMPFrame(r)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("could not connect to STOMP broker: %v", err)
+	}
+	if f.command != "CONNECTED" {
+		conn.Close()
+		return nil, fmt.Errorf("could not connect to STOMP broker: %s", f.headers["message"])
+	}
+
+	go t.readLoop(r)
+	return t, nil
+}
+
+func (t *STOMPTransport) PublishWithContext(
+	ctx context.Context,
+	exchange,
+	key string,
+	mandatory,
+	immediate bool,
+	msg amqp.Publishing,
+) error {
+	headers := map[string]string{}
+	for k, v := range msg.Headers {
+		headers[k] = fmt.Sprint(v)
+	}
+	headers["destination"] = stompDestination(exchange, key)
+	if msg.ContentType != "" {
+		headers["content-type"] = msg.ContentType
+	}
+	if msg.ContentEncoding != "" {
+		headers["content-encoding"] = msg.ContentEncoding
+	}
+	if msg.DeliveryMode == amqp.Persistent {
+		headers["persistent"] = "true"
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		t.conn.SetWriteDeadline(deadline)
+		defer t.conn.SetWriteDeadline(time.Time{})
+	}
+
+	return t.writeFrame(stompFrame{
+		command: "SEND",
+		headers: headers,
+		body:    msg.Body,
+	})
+}
+
+func (t *STOMPTransport) Consume(exchange, queueName, key string, simpleQueueType QueueType) (<-chan amqp.Delivery, error) {
+	t.mu.Lock()
+	if t.err != nil {
+		t.mu.Unlock()
+		return nil, t.err
+	}
+	t.nextSub++
+	id := strconv.Itoa(t.nextSub)
+	deliveries := make(chan amqp.Delivery, prefetchCount)
+	t.subs[id] = deliveries
+	t.mu.Unlock()
+
+	err := t.writeFrame(stompFrame{
+		command: "SUBSCRIBE",
+		headers: map[string]string{
+			"id":                     id,
+			"destination":            stompDestination(exchange, key),
+			"ack":                    "client-individual",
+			"prefetch-count":         strconv.Itoa(prefetchCount),
+			"x-queue-name":           queueName,
+			"durable":                strconv.FormatBool(simpleQueueType == Durable),
+			"auto-delete":            strconv.FormatBool(simpleQueueType == Transient),
+			"exclusive":              strconv.FormatBool(simpleQueueType == Transient),
+			"x-dead-letter-exchange": deadLetterExchange,
+		},
+	})
+	if err != nil {
+		t.mu.Lock()
+		delete(t.subs, id)
+		t.mu.Unlock()
+		return nil, fmt.Errorf("Failed to consume from %s: %v", queueName, err)
+	}
+	return deliveries, nil
+}
+
+func (t *STOMPTransport) Close() error {
+	t.writeFrame(stompFrame{command: "DISCONNECT", headers: map[string]string{}})
+	err := t.conn.Close()
+	<-t.closed
+	return err
+}
+
+// Ack, Nack and Reject make STOMPTransport the amqp.Acknowledger of its own
+// deliveries. Delivery tags are mapped back to the ack IDs sent by the broker.
+func (t *STOMPTransport) Ack(tag uint64, multiple bool) error {
+	return t.ack("ACK", tag, nil)
+}
+
+func (t *STOMPTransport) Nack(tag uint64, multiple, requeue bool) error {
+	return t.ack("NACK", tag, map[string]string{"requeue": strconv.FormatBool(requeue)})
+}
+
+func (t *STOMPTransport) Reject(tag uint64, requeue bool) error {
+	return t.Nack(tag, false, requeue)
+}
+
+func (t *STOMPTransport) ack(command string, tag uint64, headers map[string]string) error {
+	t.mu.Lock()
+	id, ok := t.ackIDs[tag]
+	delete(t.ackIDs, tag)
+	t.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("unknown delivery tag %d", tag)
+	}
+
+	if headers == nil {
+		headers = map[string]string{}
+	}
+	headers["id"] = id
+	return t.writeFrame(stompFrame{command: command, headers: headers})
+}
+
+func (t *STOMPTransport) readLoop(r *bufio.Reader) {
+	defer close(t.closed)
+
+	var err error
+	for {
+		var f stompFrame
+		f, err = readSTOMPFrame(r)
+		if err != nil {
+			break
+		}
+
+		switch f.command {
+		case "MESSAGE":
+			t.deliver(f)
+		case "ERROR":
+			err = fmt.Errorf("STOMP broker error: %s", f.headers["message"])
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.err = err
+	for id, deliveries := range t.subs {
+		close(deliveries)
+		delete(t.subs, id)
+	}
+}
+
+func (t *STOMPTransport) deliver(f stompFrame) {
+	t.mu.Lock()
+	deliveries, ok := t.subs[f.headers["subscription"]]
+	if !ok {
+		t.mu.Unlock()
+		return
+	}
+	t.nextTag++
+	tag := t.nextTag
+	t.ackIDs[tag] = f.headers["ack"]
+	t.mu.Unlock()
+
+	exchange, key := parseSTOMPDestination(f.headers["destination"])
+	headers := amqp.Table{}
+	for k, v := range f.headers {
+		switch k {
+		case "destination", "subscription", "ack", "message-id", "content-type", "content-encoding", "content-length", "redelivered", "persistent":
+		default:
+			headers[k] = v
+		}
+	}
+
+	deliveries <- amqp.Delivery{
+		Acknowledger:    t,
+		Headers:         headers,
+		ContentType:     f.headers["content-type"],
+		ContentEncoding: f.headers["content-encoding"],
+		MessageId:       f.headers["message-id"],
+		DeliveryTag:     tag,
+		Redelivered:     f.headers["redelivered"] == "true",
+		Exchange:        exchange,
+		RoutingKey:      key,
+		Body:            f.body,
+	}
+}
+
+func (t *STOMPTransport) writeFrame(f stompFrame) error {
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+
+	t.w.WriteString(f.command)
+	t.w.WriteByte('\n')
+	for k, v := range f.headers {
+		// CONNECT headers are not escaped in STOMP 1.2
+		if f.command != "CONNECT" {
+			k, v = escapeSTOMPHeader(k), escapeSTOMPHeader(v)
+		}
+		t.w.WriteString(k)
+		t.w.WriteByte(':')
+		t.w.WriteString(v)
+		t.w.WriteByte('\n')
+	}
+	if len(f.body) > 0 {
+		fmt.Fprintf(t.w, "content-length:%d\n", len(f.body))
+	}
+	t.w.WriteByte('\n')
+	t.w.Write(f.body)
+	t.w.WriteByte(0)
+	return t.w.Flush()
+}
+
+func readSTOMPFrame(r *bufio.Reader) (stompFrame, error) {
+	// skip heart-beat newlines between frames
+	var command string
+	for command == "" {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return stompFrame{}, err
+		}
+		command = strings.TrimRight(line, "\r\n")
+	}
+
+	f := stompFrame{command: command, headers: map[string]string{}}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return stompFrame{}, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		k, v, ok := strings.Cut(line, ":")
+		if !ok {
+			return stompFrame{}, fmt.Errorf("malformed STOMP header %q", line)
+		}
+		k, v = unescapeSTOMPHeader(k), unescapeSTOMPHeader(v)
+		// the first occurrence of a repeated header wins
+		if _, seen := f.headers[k]; !seen {
+			f.headers[k] = v
+		}
+	}
+
+	if lengthHeader, ok := f.headers["content-length"]; ok {
+		n, err := strconv.Atoi(lengthHeader)
+		if err != nil || n < 0 {
+			return stompFrame{}, errors.New("malformed STOMP content-length")
+		}
+		f.body = make([]byte, n)
+		_, err = io.ReadFull(r, f.body)
+		if err != nil {
+			return stompFrame{}, err
+		}
+		nul, err := r.ReadByte()
+		if err != nil {
+			return stompFrame{}, err
+		}
+		if nul != 0 {
+			return stompFrame{}, errors.New("STOMP frame is not NUL terminated")
+		}
+		return f, nil
+	}
+
+	body, err := r.ReadBytes(0)
+	if err != nil {
+		return stompFrame{}, err
+	}
+	f.body = body[:len(body)-1]
+	return f, nil
+}
+
+var (
+	stompEscaper   = strings.NewReplacer("\\", "\\\\", "\r", "\\r", "\n", "\\n", ":", "\\c")
+	stompUnescaper = strings.NewReplacer("\\\\", "\\", "\\r", "\r", "\\n", "\n", "\\c", ":")
+)
+
+func escapeSTOMPHeader(s string) string {
+	return stompEscaper.Replace(s)
+}
+
+func unescapeSTOMPHeader(s string) string {
+	return stompUnescaper.Replace(s)
+}
+
+func stompDestination(exchange, key string) string {
+	if exchange == "" {
+		return "/queue/" + key
+	}
+	return "/exchange/" + exchange + "/" + key
+}
+
+func parseSTOMPDestination(destination string) (exchange, key string) {
+	rest, ok := strings.CutPrefix(destination, "/exchange/")
+	if !ok {
+		return "", strings.TrimPrefix(destination, "/queue/")
+	}
+	exchange, key, _ = strings.Cut(rest, "/")
+	return exchange, key
+}
@@ -0,0 +1,297 @@
+package pubsub
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing/topic"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// stompStandIn is just enough of a STOMP broker to test STOMPTransport
+// against: it routes SENDs to matching subscriptions on every connection
+// and reports every frame clients send it.
+type stompStandIn struct {
+	ln     net.Listener
+	frames chan stompFrame
+
+	mu      *sync.Mutex
+	subs    []stompStandInSub
+	nextMsg int
+}
+
+type stompStandInSub struct {
+	conn        net.Conn
+	id          string
+	destination string
+}
+
+func newSTOMPStandIn(t *testing.T) *stompStandIn {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	b := &stompStandIn{
+		ln:     ln,
+		frames: make(chan stompFrame, 100),
+		mu:     &sync.Mutex{},
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(conn)
+		}
+	}()
+	return b
+}
+
+func (b *stompStandIn) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		f, err := readSTOMPFrame(r)
+		if err != nil {
+			return
+		}
+		b.frames <- f
+
+		switch f.command {
+		case "CONNECT", "STOMP":
+			writeStandInFrame(conn, stompFrame{
+				command: "CONNECTED",
+				headers: map[string]string{"version": "1.2"},
+			})
+		case "SUBSCRIBE":
+			b.mu.Lock()
+			b.subs = append(b.subs, stompStandInSub{conn: conn, id: f.headers["id"], destination: f.headers["destination"]})
+			b.mu.Unlock()
+		case "SEND":
+			b.route(f)
+		case "DISCONNECT":
+			return
+		}
+	}
+}
+
+func (b *stompStandIn) route(f stompFrame) {
+	exchange, key := parseSTOMPDestination(f.headers["destination"])
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, sub := range b.subs {
+		subExchange, pattern := parseSTOMPDestination(sub.destination)
+		if subExchange != exchange || !topic.Match(pattern, key) {
+			continue
+		}
+		b.nextMsg++
+		headers := map[string]string{}
+		for k, v := range f.headers {
+			headers[k] = v
+		}
+		headers["subscription"] = sub.id
+		headers["message-id"] = fmt.Sprintf("msg-%d", b.nextMsg)
+		headers["ack"] = fmt.Sprintf("ack-%d", b.nextMsg)
+		writeStandInFrame(sub.conn, stompFrame{command: "MESSAGE", headers: headers, body: f.body})
+	}
+}
+
+func writeStandInFrame(conn net.Conn, f stompFrame) {
+	var sb strings.Builder
+	sb.WriteString(f.command + "\n")
+	for k, v := range f.headers {
+		if k == "content-length" {
+			continue
+		}
+		sb.WriteString(escapeSTOMPHeader(k) + ":" + escapeSTOMPHeader(v) + "\n")
+	}
+	fmt.Fprintf(&sb, "content-length:%d\n\n", len(f.body))
+	sb.Write(f.body)
+	sb.WriteByte(0)
+	conn.Write([]byte(sb.String()))
+}
+
+// expect returns the next frame the client sent with command, skipping
+// others
+func (b *stompStandIn) expect(t *testing.T, command string) stompFrame {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case f := <-b.frames:
+			if f.command == command {
+				return f
+			}
+		case <-timeout:
+			t.Fatalf("no %s frame arrived", command)
+			return stompFrame{}
+		}
+	}
+}
+
+func TestDialSTOMP(t *testing.T) {
+	b := newSTOMPStandIn(t)
+
+	transport, err := Dial(fmt.Sprintf("stomp://guest:secret@%s/peril", b.ln.Addr()))
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer transport.Close()
+
+	if _, ok := transport.(*STOMPTransport); !ok {
+		t.Fatalf("Dial returned a %T, want a *STOMPTransport", transport)
+	}
+	connect := b.expect(t, "CONNECT")
+	for header, want := range map[string]string{
+		"login":          "guest",
+		"passcode":       "secret",
+		"host":           "peril",
+		"accept-version": "1.2",
+	} {
+		if got := connect.headers[header]; got != want {
+			t.Errorf("CONNECT %s = %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestDialSTOMPDefaultVhost(t *testing.T) {
+	b := newSTOMPStandIn(t)
+
+	transport, err := Dial(fmt.Sprintf("stomp://%s", b.ln.Addr()))
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer transport.Close()
+
+	if got := b.expect(t, "CONNECT").headers["host"]; got != "/" {
+		t.Errorf("CONNECT host = %q, want %q", got, "/")
+	}
+}
+
+type stompTestMove struct {
+	Player string
+	To     string
+}
+
+func TestSTOMPSubscribeAcks(t *testing.T) {
+	tests := []struct {
+		name        string
+		acktype     Acktype
+		wantCommand string
+		wantRequeue string
+	}{
+		{"ack", Ack, "ACK", ""},
+		{"nack requeue", NackRequeue, "NACK", "true"},
+		{"nack discard", NackDiscard, "NACK", "false"},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newSTOMPStandIn(t)
+			transport, err := Dial(fmt.Sprintf("stomp://guest:guest@%s/", b.ln.Addr()))
+			if err != nil {
+				t.Fatalf("Dial: %v", err)
+			}
+			defer transport.Close()
+
+			received := make(chan stompTestMove, 1)
+			err = SubscribeJSON(
+				transport,
+				"peril_topic",
+				fmt.Sprintf("army_moves_%d", i),
+				"army_moves.*",
+				Durable,
+				func(m stompTestMove) Acktype {
+					received <- m
+					return tt.acktype
+				},
+			)
+			if err != nil {
+				t.Fatalf("SubscribeJSON: %v", err)
+			}
+			sub := b.expect(t, "SUBSCRIBE")
+			if got := sub.headers["x-queue-name"]; got != fmt.Sprintf("army_moves_%d", i) {
+				t.Errorf("SUBSCRIBE x-queue-name = %q", got)
+			}
+			if got := sub.headers["durable"]; got != "true" {
+				t.Errorf("SUBSCRIBE durable = %q, want true", got)
+			}
+
+			sent := stompTestMove{Player: "bob", To: "asia"}
+			err = PublishJSON(transport, "peril_topic", "army_moves.bob", sent)
+			if err != nil {
+				t.Fatalf("PublishJSON: %v", err)
+			}
+
+			select {
+			case got := <-received:
+				if got != sent {
+					t.Errorf("handler got %+v, want %+v", got, sent)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("handler was never called")
+			}
+
+			ack := b.expect(t, tt.wantCommand)
+			if got := ack.headers["id"]; got != "ack-1" {
+				t.Errorf("%s id = %q, want ack-1", tt.wantCommand, got)
+			}
+			if got := ack.headers["requeue"]; got != tt.wantRequeue {
+				t.Errorf("%s requeue = %q, want %q", tt.wantCommand, got, tt.wantRequeue)
+			}
+		})
+	}
+}
+
+func TestSTOMPHeadersRoundTrip(t *testing.T) {
+	b := newSTOMPStandIn(t)
+	transport, err := Dial(fmt.Sprintf("stomp://guest:guest@%s/", b.ln.Addr()))
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer transport.Close()
+
+	versions := make(chan int, 1)
+	err = Subscribe(
+		transport,
+		"peril_topic",
+		"logs",
+		"game_logs.*",
+		Transient,
+		func(v int) Acktype {
+			versions <- v
+			return Ack
+		},
+		func(d amqp.Delivery) (int, error) {
+			return MessageVersion(d.Headers), nil
+		},
+	)
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	b.expect(t, "SUBSCRIBE")
+
+	// STOMP headers are text, so the int32 version arrives as a string
+	err = PublishJSON(transport, "peril_topic", "game_logs.bob", "hello", WithVersion(3))
+	if err != nil {
+		t.Fatalf("PublishJSON: %v", err)
+	}
+	select {
+	case v := <-versions:
+		if v != 3 {
+			t.Errorf("MessageVersion = %d, want 3", v)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler was never called")
+	}
+}
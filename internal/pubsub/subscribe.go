@@ -2,20 +2,57 @@ package pubsub
 
 import (
 	"bytes"
+	"crypto/ed25519"
 	"encoding/gob"
 	"encoding/json"
-	"fmt"
+	"log"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
+type subscribeConfig struct {
+	authority ed25519.PublicKey
+	signedBy  string
+	keyring   Keyring
+	validate  func(amqp.Delivery) error
+}
+
+// SubscribeOption changes how deliveries are checked before the handler runs
+type SubscribeOption func(*subscribeConfig)
+
+// WithVerification rejects messages that are not signed by a player
+// certified by the server's authority key
+func WithVerification(authority ed25519.PublicKey) SubscribeOption {
+	return func(cfg *subscribeConfig) {
+		cfg.authority = authority
+	}
+}
+
+// WithSignedBy rejects messages not signed by username, for routes only
+// one party publishes to, like the server's. Signers are only known with
+// WithVerification, so without it every message is rejected.
+func WithSignedBy(username string) SubscribeOption {
+	return func(cfg *subscribeConfig) {
+		cfg.signedBy = username
+	}
+}
+
+// WithValidation rejects JSON messages validate returns an error for, such
+// as ones that don't conform to their type's schema
+func WithValidation(validate func(d amqp.Delivery) error) SubscribeOption {
+	return func(cfg *subscribeConfig) {
+		cfg.validate = validate
+	}
+}
+
 func SubscribeJSON[T any](
-	conn *amqp.Connection,
+	transport Transport,
 	exchange,
 	queueName,
 	key string,
 	simpleQueueType QueueType,
 	handler func(T) Acktype,
+	opts ...SubscribeOption,
 ) error {
 	jsonUnmarshaller := func(b []byte) (T, error) {
 		var g T
@@ -24,23 +61,25 @@ func SubscribeJSON[T any](
 	}
 
 	return subscribe(
-		conn,
+		transport,
 		exchange,
 		queueName,
 		key,
 		simpleQueueType,
 		handler,
-		jsonUnmarshaller,
+		func(d amqp.Delivery) (T, error) { return jsonUnmarshaller(d.Body) },
+		opts,
 	)
 }
 
 func SubscribeGob[T any](
-	conn *amqp.Connection,
+	transport Transport,
 	exchange,
 	queueName,
 	key string,
 	simpleQueueType QueueType,
 	handler func(T) Acktype,
+	opts ...SubscribeOption,
 ) error {
 	gobDecoder := func(b []byte) (T, error) {
 		buf := bytes.NewBuffer(b)
@@ -51,63 +90,66 @@ func SubscribeGob[T any](
 	}
 
 	return subscribe(
-		conn,
+		transport,
 		exchange,
 		queueName,
 		key,
 		simpleQueueType,
 		handler,
-		gobDecoder,
+		func(d amqp.Delivery) (T, error) { return gobDecoder(d.Body) },
+		opts,
 	)
 }
 
+// Subscribe is SubscribeJSON or SubscribeGob for callers that decode
+// deliveries themselves, for instance to look at their headers first.
+// decode sees the body after it has been verified, decrypted and
+// decompressed.
+func Subscribe[T any](
+	transport Transport,
+	exchange,
+	queueName,
+	key string,
+	simpleQueueType QueueType,
+	handler func(T) Acktype,
+	decode func(amqp.Delivery) (T, error),
+	opts ...SubscribeOption,
+) error {
+	return subscribe(transport, exchange, queueName, key, simpleQueueType, handler, decode, opts)
+}
+
 func subscribe[T any](
-	conn *amqp.Connection,
+	transport Transport,
 	exchange,
 	queueName,
 	key string,
 	simpleQueueType QueueType,
 	handler func(T) Acktype,
-	unmarshaller func([]byte) (T, error),
+	decode func(amqp.Delivery) (T, error),
+	opts []SubscribeOption,
 ) error {
-	// declare a queue and bind it to an exchange
-	newChan, _, err := DeclareAndBind(
-		conn,
+	cfg := subscribeConfig{}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	// deliver queued messages
+	deliveryChan, err := transport.Consume(
 		exchange,
 		queueName,
 		key,
 		simpleQueueType,
 	)
 	if err != nil {
-		return fmt.Errorf("Failed to declare and bind a queue: %v", err)
+		return err
 	}
 
-	err = newChan.Qos(10, 0, false)
-	if err != nil {
-		return fmt.Errorf("Failed to set prefetch size")
-	}
-
-	// deliver queued messages
-	deliveryChan, err := newChan.Consume(
-		queueName,
-		"",
-		false,
-		false,
-		false,
-		false,
-		nil,
-	)
-
 	// Ack all the delivered messages
 	go func() {
 		for d := range deliveryChan {
-			g, err := unmarshaller(d.Body)
-			if err != nil {
-				break
-			}
-
-			acktype := handler(g)
+			acktype := handleDelivery(d, cfg, handler, decode)
 
+			var err error
 			switch acktype {
 			case NackRequeue:
 				err = d.Nack(false, true)
@@ -125,5 +167,63 @@ func subscribe[T any](
 		}
 	}()
 
-	return err
+	return nil
+}
+
+// handleDelivery checks and decodes a delivery, then passes it to handler.
+// Anything that fails a check is discarded to the dead-letter exchange.
+func handleDelivery[T any](
+	d amqp.Delivery,
+	cfg subscribeConfig,
+	handler func(T) Acktype,
+	decode func(amqp.Delivery) (T, error),
+) Acktype {
+	var signer string
+	if cfg.authority != nil {
+		var err error
+		signer, err = verifySignature(cfg.authority, d)
+		if err != nil {
+			log.Printf("rejected message on %s: %v", d.RoutingKey, err)
+			return NackDiscard
+		}
+	}
+	if cfg.signedBy != "" && signer != cfg.signedBy {
+		log.Printf("rejected message on %s: signed by %q, not %s", d.RoutingKey, signer, cfg.signedBy)
+		return NackDiscard
+	}
+
+	if cfg.keyring != nil {
+		err := decrypt(cfg.keyring, &d)
+		if err != nil {
+			log.Printf("rejected message on %s: %v", d.RoutingKey, err)
+			return NackDiscard
+		}
+	}
+
+	err := decompress(&d)
+	if err != nil {
+		log.Printf("could not decompress message on %s: %v", d.RoutingKey, err)
+		return NackDiscard
+	}
+
+	if cfg.validate != nil && d.ContentType == "application/json" {
+		err := cfg.validate(d)
+		if err != nil {
+			log.Printf("rejected message on %s: %v", d.RoutingKey, err)
+			return NackDiscard
+		}
+	}
+
+	g, err := decode(d)
+	if err != nil {
+		log.Printf("could not decode message on %s: %v", d.RoutingKey, err)
+		return NackDiscard
+	}
+
+	if a, ok := any(g).(Attributed); ok && signer != "" && a.Sender() != signer {
+		log.Printf("rejected message on %s: %s signed a message from %s", d.RoutingKey, signer, a.Sender())
+		return NackDiscard
+	}
+
+	return handler(g)
 }
@@ -0,0 +1,38 @@
+package pubsub
+
+import (
+	"strconv"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// headerVersion carries the version of the message type a body was
+// encoded from
+const headerVersion = "x-peril-version"
+
+// WithVersion marks the message as encoded from version v of its type
+func WithVersion(v int) PublishOption {
+	return func(cfg *publishConfig) {
+		cfg.version = v
+	}
+}
+
+// MessageVersion returns the version a delivery was published with.
+// Messages from publishers that predate versioning are version 1.
+func MessageVersion(headers amqp.Table) int {
+	switch v := headers[headerVersion].(type) {
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case int:
+		return v
+	case string:
+		// transports that only carry string headers
+		n, err := strconv.Atoi(v)
+		if err == nil {
+			return n
+		}
+	}
+	return 1
+}
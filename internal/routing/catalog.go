@@ -0,0 +1,285 @@
+package routing
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing/schema"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing/topic"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Codec is how a message type is encoded on the wire
+type Codec int
+
+const (
+	JSON Codec = iota
+	Gob
+)
+
+func (c Codec) String() string {
+	switch c {
+	case JSON:
+		return "json"
+	case Gob:
+		return "gob"
+	}
+	return fmt.Sprintf("Codec(%d)", int(c))
+}
+
+// Route is where a message type travels and how. Pattern is the binding
+// key subscribers use; every key a value is published with matches it.
+// Version is the version of the type values are published as.
+type Route struct {
+	Type      reflect.Type
+	Exchange  string
+	Pattern   string
+	Codec     Codec
+	QueueType pubsub.QueueType
+	Version   int
+
+	key       func(any) (string, error)
+	upcasters map[int]func([]byte) (any, error)
+	legacy    []legacyRoute
+}
+
+// Key returns the routing key val is published with
+func (r Route) Key(val any) (string, error) {
+	return r.key(val)
+}
+
+// Schema returns the JSON Schema of the route's type
+func (r Route) Schema() (*schema.Schema, error) {
+	s, err := schema.For(r.Type)
+	if err != nil {
+		return nil, err
+	}
+	s.ID = r.Type.Name() + ".json"
+	s.Description = fmt.Sprintf(
+		"Version %d, published to %s with routing keys matching %s, %s encoded",
+		r.Version, r.Exchange, r.Pattern, r.Codec,
+	)
+	return s, nil
+}
+
+// catalog is filled by Register calls from init functions and only read
+// afterwards, so it needs no lock
+var catalog = map[reflect.Type]Route{}
+
+// Register adds T to the catalog. key derives the routing key from a value.
+// Registering a type twice panics, since it means two packages disagree
+// about where it goes.
+func Register[T any](exchange, pattern string, codec Codec, queueType pubsub.QueueType, key func(T) (string, error)) {
+	t := reflect.TypeFor[T]()
+	if _, ok := catalog[t]; ok {
+		panic(fmt.Sprintf("routing: %s registered twice", t))
+	}
+	catalog[t] = Route{
+		Type:      t,
+		Exchange:  exchange,
+		Pattern:   pattern,
+		Codec:     codec,
+		QueueType: queueType,
+		Version:   1,
+		key: func(val any) (string, error) {
+			return key(val.(T))
+		},
+		upcasters: map[int]func([]byte) (any, error){},
+	}
+}
+
+// RouteOf returns T's catalog entry
+func RouteOf[T any]() (Route, error) {
+	t := reflect.TypeFor[T]()
+	r, ok := catalog[t]
+	if !ok {
+		return Route{}, fmt.Errorf("%s is not in the message catalog", t)
+	}
+	return r, nil
+}
+
+// Routes returns every catalog entry
+func Routes() []Route {
+	routes := make([]Route, 0, len(catalog))
+	for _, r := range catalog {
+		routes = append(routes, r)
+	}
+	return routes
+}
+
+// Publish sends val to its type's exchange, with the key derived from val,
+// in its type's encoding
+func Publish[T any](pub pubsub.Publisher, val T, opts ...pubsub.PublishOption) error {
+	r, err := RouteOf[T]()
+	if err != nil {
+		return err
+	}
+	key, err := r.Key(val)
+	if err != nil {
+		return err
+	}
+	if !topic.Match(r.Pattern, key) {
+		return fmt.Errorf("%s key %s doesn't match %s", r.Type, key, r.Pattern)
+	}
+
+	opts = append(opts, pubsub.WithVersion(r.Version))
+	switch r.Codec {
+	case Gob:
+		return pubsub.PublishGob(pub, r.Exchange, key, val, opts...)
+	default:
+		return pubsub.PublishJSON(pub, r.Exchange, key, val, opts...)
+	}
+}
+
+// Subscribe binds queueName to T's exchange and pattern, with T's queue
+// type, and decodes deliveries in T's encoding
+func Subscribe[T any](
+	transport pubsub.Transport,
+	queueName string,
+	handler func(T) pubsub.Acktype,
+	opts ...pubsub.SubscribeOption,
+) error {
+	r, err := RouteOf[T]()
+	if err != nil {
+		return err
+	}
+	return SubscribeAs(transport, queueName, r.QueueType, handler, opts...)
+}
+
+// SubscribeAs is Subscribe with another queue type, for observers that
+// shouldn't share T's usual queue. Deliveries published as an older
+// version of T are upcast before the handler sees them, and T's legacy
+// routes are bound to queues named by LegacyQueue.
+func SubscribeAs[T any](
+	transport pubsub.Transport,
+	queueName string,
+	queueType pubsub.QueueType,
+	handler func(T) pubsub.Acktype,
+	opts ...pubsub.SubscribeOption,
+) error {
+	r, err := RouteOf[T]()
+	if err != nil {
+		return err
+	}
+	err = SubscribeTo(transport, queueName, r.Pattern, queueType, handler, opts...)
+	if err != nil {
+		return err
+	}
+	return subscribeLegacy(transport, r, queueName, queueType, handler, versionDecoder[T](r), opts...)
+}
+
+// SubscribeTo is SubscribeAs bound with a narrower key than T's pattern,
+// for subscribers that only want some of T's messages, like a player's own.
+// Legacy routes aren't bound, since their keys can't be narrowed alike.
+func SubscribeTo[T any](
+	transport pubsub.Transport,
+	queueName string,
+	key string,
+	queueType pubsub.QueueType,
+	handler func(T) pubsub.Acktype,
+	opts ...pubsub.SubscribeOption,
+) error {
+	r, err := RouteOf[T]()
+	if err != nil {
+		return err
+	}
+	if !topic.Covers(r.Pattern, key) {
+		return fmt.Errorf("%s binding key %s isn't narrower than %s", r.Type, key, r.Pattern)
+	}
+	return pubsub.Subscribe(transport, r.Exchange, queueName, key, queueType, handler, versionDecoder[T](r), opts...)
+}
+
+// Keyed is a delivered value along with the routing key it came with, for
+// handlers that check the key agrees with what the value says
+type Keyed[T pubsub.Attributed] struct {
+	Key string
+	Val T
+}
+
+// Sender is the value's sender, so signatures are still checked against it
+func (k Keyed[T]) Sender() string {
+	return k.Val.Sender()
+}
+
+// SubscribeKeyed is Subscribe for handlers that need each delivery's
+// routing key as well as its value. Deliveries from legacy routes carry
+// their legacy keys.
+func SubscribeKeyed[T pubsub.Attributed](
+	transport pubsub.Transport,
+	queueName string,
+	handler func(Keyed[T]) pubsub.Acktype,
+	opts ...pubsub.SubscribeOption,
+) error {
+	r, err := RouteOf[T]()
+	if err != nil {
+		return err
+	}
+	decode := versionDecoder[T](r)
+	keyed := func(d amqp.Delivery) (Keyed[T], error) {
+		val, err := decode(d)
+		return Keyed[T]{Key: d.RoutingKey, Val: val}, err
+	}
+	err = pubsub.Subscribe(transport, r.Exchange, queueName, r.Pattern, r.QueueType, handler, keyed, opts...)
+	if err != nil {
+		return err
+	}
+	return subscribeLegacy(transport, r, queueName, r.QueueType, handler, keyed, opts...)
+}
+
+// ValidateSchema returns a subscribe option that dead-letters JSON
+// deliveries not conforming to T's schema. The schema only describes the
+// current version, so older deliveries are left to their upcasters; newer
+// ones are decoded as the current version, so they're checked like it.
+func ValidateSchema[T any]() (pubsub.SubscribeOption, error) {
+	r, err := RouteOf[T]()
+	if err != nil {
+		return nil, err
+	}
+	s, err := r.Schema()
+	if err != nil {
+		return nil, err
+	}
+	return pubsub.WithValidation(func(d amqp.Delivery) error {
+		if pubsub.MessageVersion(d.Headers) < r.Version {
+			return nil
+		}
+		return s.Validate(d.Body)
+	}), nil
+}
+
+// gameLogV1 is GameLog as it was before the server hosted several games
+type gameLogV1 struct {
+	CurrentTime time.Time
+	Message     string
+	Username    string
+}
+
+func init() {
+	// version 2 added Game. Only the server pauses games, and there's only
+	// one, so no version 1 payloads are left once it's upgraded.
+	Register(ExchangePerilTopic, PausePattern, JSON, pubsub.Transient, func(ps PlayingState) (string, error) {
+		return GamePauseKey(ps.Game)
+	})
+	RegisterVersion[PlayingState](2)
+
+	Register(ExchangePerilDirect, BroadcastKey, JSON, pubsub.Transient, func(Broadcast) (string, error) {
+		return BroadcastKey, nil
+	})
+
+	Register(ExchangePerilTopic, GameLogPattern, Gob, pubsub.Durable, func(gl GameLog) (string, error) {
+		return GameLogKey(gl.Game, gl.Username)
+	})
+	// version 2 added Game. Clients that haven't upgraded still publish
+	// version 1 logs to game_logs.username, so they're upcast without one.
+	RegisterVersion[GameLog](2)
+	RegisterUpcaster(1, func(old gameLogV1) (GameLog, error) {
+		return GameLog{CurrentTime: old.CurrentTime, Message: old.Message, Username: old.Username}, nil
+	})
+	RegisterLegacyRoute[GameLog](1, ExchangePerilTopic, GameLogSlug+".*")
+
+	Register(ExchangePerilTopic, PresencePattern, JSON, pubsub.Transient, func(p Presence) (string, error) {
+		return PresenceKey(p.Username)
+	})
+}
@@ -0,0 +1,185 @@
+package routing_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// catalogTestMsg is published with whatever key it carries, so tests can
+// try keys its pattern doesn't match
+type catalogTestMsg struct {
+	Key string
+}
+
+func init() {
+	routing.Register(routing.ExchangePerilTopic, "catalog_test.*", routing.JSON, pubsub.Transient, func(m catalogTestMsg) (string, error) {
+		if m.Key == "" {
+			return "", errors.New("no key")
+		}
+		return m.Key, nil
+	})
+}
+
+// recordingPublisher keeps what's published through it
+type recordingPublisher struct {
+	mu       sync.Mutex
+	exchange []string
+	keys     []string
+	msgs     []amqp.Publishing
+}
+
+func (p *recordingPublisher) PublishWithContext(_ context.Context, exchange, key string, _, _ bool, msg amqp.Publishing) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.exchange = append(p.exchange, exchange)
+	p.keys = append(p.keys, key)
+	p.msgs = append(p.msgs, msg)
+	return nil
+}
+
+func TestPublishChecksKey(t *testing.T) {
+	tests := []struct {
+		key     string
+		wantErr bool
+	}{
+		{"catalog_test.bob", false},
+		{"catalog_test", true},
+		{"catalog_test.a.b", true},
+		{"other.bob", true},
+		{"", true},
+	}
+	for _, tt := range tests {
+		pub := &recordingPublisher{}
+		err := routing.Publish(pub, catalogTestMsg{Key: tt.key})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Publish with key %q error = %v, wantErr %v", tt.key, err, tt.wantErr)
+		}
+		if tt.wantErr && len(pub.keys) != 0 {
+			t.Errorf("Publish with key %q published to %v", tt.key, pub.keys)
+		}
+	}
+}
+
+func TestPublishUsesRoute(t *testing.T) {
+	tests := []struct {
+		name        string
+		publish     func(pubsub.Publisher) error
+		exchange    string
+		key         string
+		contentType string
+		version     int
+	}{
+		{
+			"pause",
+			func(pub pubsub.Publisher) error {
+				return routing.Publish(pub, routing.PlayingState{Game: "europe", IsPaused: true})
+			},
+			routing.ExchangePerilTopic, "pause.europe", "application/json", 2,
+		},
+		{
+			"broadcast",
+			func(pub pubsub.Publisher) error {
+				return routing.Publish(pub, routing.Broadcast{Message: "hello"})
+			},
+			routing.ExchangePerilDirect, routing.BroadcastKey, "application/json", 1,
+		},
+		{
+			"game log",
+			func(pub pubsub.Publisher) error {
+				return routing.Publish(pub, routing.GameLog{Game: "europe", Username: "bob", Message: "hello"})
+			},
+			routing.ExchangePerilTopic, "game_logs.europe.bob", "application/gob", 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pub := &recordingPublisher{}
+			err := tt.publish(pub)
+			if err != nil {
+				t.Fatalf("Publish: %v", err)
+			}
+			if len(pub.msgs) != 1 {
+				t.Fatalf("published %d messages, want 1", len(pub.msgs))
+			}
+			if pub.exchange[0] != tt.exchange || pub.keys[0] != tt.key {
+				t.Errorf("published to %s %s, want %s %s", pub.exchange[0], pub.keys[0], tt.exchange, tt.key)
+			}
+			msg := pub.msgs[0]
+			if msg.ContentType != tt.contentType {
+				t.Errorf("content type = %s, want %s", msg.ContentType, tt.contentType)
+			}
+			if v := pubsub.MessageVersion(msg.Headers); v != tt.version {
+				t.Errorf("version = %d, want %d", v, tt.version)
+			}
+		})
+	}
+}
+
+func TestPublishRefusesInvalidKeys(t *testing.T) {
+	// the key builders refuse what would widen or split a key
+	pub := &recordingPublisher{}
+	err := routing.Publish(pub, routing.GameLog{Game: "europe", Username: "bob.*"})
+	if !errors.Is(err, routing.ErrInvalidUsername) {
+		t.Errorf("Publish error = %v, want ErrInvalidUsername", err)
+	}
+	err = routing.Publish(pub, routing.PlayingState{Game: "#"})
+	if !errors.Is(err, routing.ErrInvalidGameID) {
+		t.Errorf("Publish error = %v, want ErrInvalidGameID", err)
+	}
+	if len(pub.keys) != 0 {
+		t.Errorf("published to %v", pub.keys)
+	}
+}
+
+func TestUnregisteredTypes(t *testing.T) {
+	type unregistered struct{}
+	if _, err := routing.RouteOf[unregistered](); err == nil {
+		t.Error("RouteOf found an unregistered type")
+	}
+	pub := &recordingPublisher{}
+	if err := routing.Publish(pub, unregistered{}); err == nil {
+		t.Error("published an unregistered type")
+	}
+	err := routing.Subscribe(openLog(t), "test", func(unregistered) pubsub.Acktype { return pubsub.Ack })
+	if err == nil {
+		t.Error("subscribed to an unregistered type")
+	}
+}
+
+func TestRegisterTwicePanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a type twice didn't panic")
+		}
+	}()
+	routing.Register(routing.ExchangePerilTopic, "other.*", routing.JSON, pubsub.Transient, func(catalogTestMsg) (string, error) {
+		return "other.bob", nil
+	})
+}
+
+func TestSubscribeToChecksKey(t *testing.T) {
+	tests := []struct {
+		key     string
+		wantErr bool
+	}{
+		{"catalog_test.*", false},
+		{"catalog_test.bob", false},
+		{"catalog_test.#", true},
+		{"other.*", true},
+		{"#", true},
+	}
+	for _, tt := range tests {
+		err := routing.SubscribeTo(openLog(t), "test", tt.key, pubsub.Transient, func(catalogTestMsg) pubsub.Acktype {
+			return pubsub.Ack
+		})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("SubscribeTo(%q) error = %v, wantErr %v", tt.key, err, tt.wantErr)
+		}
+	}
+}
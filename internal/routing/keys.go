@@ -0,0 +1,261 @@
+package routing
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// binding patterns matching every player's key in every game. Keys of
+// in-game messages are prefix.game.username.
+const (
+	ArmyMovesPattern       = ArmyMovesPrefix + ".*.*"
+	WarRecognitionsPattern = WarRecognitionsPrefix + ".*.*"
+	GameLogPattern         = GameLogSlug + ".*.*"
+	SpawnsPattern          = SpawnsPrefix + ".*.*"
+	DeltasPattern          = DeltasPrefix + ".*.*"
+	RejectionsPattern      = RejectionsPrefix + ".*.*"
+	PausePattern           = PauseKey + ".*"
+	LobbyPattern           = LobbyPrefix + ".*"
+	LobbyRepliesPattern    = LobbyRepliesPrefix + ".*"
+	PresencePattern        = PresencePrefix + ".*"
+)
+
+// ServerUsername is who the server signs its messages as. No player can
+// have it, so no player can be issued credentials that pass for the
+// server's.
+const ServerUsername = "server"
+
+// ErrInvalidUsername is returned for usernames that can't be a routing key
+// word
+var ErrInvalidUsername = errors.New("invalid username")
+
+// ErrInvalidGameID is returned for game IDs that can't be a routing key
+// word
+var ErrInvalidGameID = errors.New("invalid game ID")
+
+// ValidateUsername checks that username is a single routing key word
+// without wildcards, so it can't widen or split the keys built from it.
+// Slashes, + and > are rejected too, since MQTT and NATS treat them
+// specially, and so is ServerUsername.
+func ValidateUsername(username string) error {
+	if username == ServerUsername {
+		return fmt.Errorf("%w: %q is reserved", ErrInvalidUsername, username)
+	}
+	return validateWord(ErrInvalidUsername, "username", username)
+}
+
+// ValidateGameID checks a game ID by the same rules as usernames
+func ValidateGameID(game string) error {
+	return validateWord(ErrInvalidGameID, "game ID", game)
+}
+
+func validateWord(errInvalid error, what, word string) error {
+	if word == "" {
+		return fmt.Errorf("%w: %s is empty", errInvalid, what)
+	}
+	if strings.ContainsAny(word, ".*#/+> \t\r\n") {
+		return fmt.Errorf("%w: %q can't contain dots, wildcards, slashes or spaces", errInvalid, word)
+	}
+	return nil
+}
+
+// ArmyMoveKey is the key a player's moves in game are published with
+func ArmyMoveKey(game, username string) (string, error) {
+	return gameKey(ArmyMovesPrefix, game, username)
+}
+
+// ParseArmyMoveKey returns the game and player an army move key belongs to
+func ParseArmyMoveKey(key string) (game, username string, err error) {
+	return parseGameKey(ArmyMovesPrefix, key)
+}
+
+// WarKey is the key a war in game against username is published with
+func WarKey(game, username string) (string, error) {
+	return gameKey(WarRecognitionsPrefix, game, username)
+}
+
+// ParseWarKey returns the game and defending player of a war
+func ParseWarKey(key string) (game, username string, err error) {
+	return parseGameKey(WarRecognitionsPrefix, key)
+}
+
+// GameLogKey is the key a player's game logs in game are published with
+func GameLogKey(game, username string) (string, error) {
+	return gameKey(GameLogSlug, game, username)
+}
+
+// ParseGameLogKey returns the game and player a game log came from
+func ParseGameLogKey(key string) (game, username string, err error) {
+	return parseGameKey(GameLogSlug, key)
+}
+
+// ParseGameLogKeyV1 returns the player a version 1 game log came from.
+// Those predate games, so their keys were just game_logs.username.
+func ParseGameLogKeyV1(key string) (string, error) {
+	return parsePlayerKey(GameLogSlug, key)
+}
+
+// SpawnKey is the key a player's spawn requests in game are published with
+func SpawnKey(game, username string) (string, error) {
+	return gameKey(SpawnsPrefix, game, username)
+}
+
+// ParseSpawnKey returns the game and player a spawn request came from
+func ParseSpawnKey(key string) (game, username string, err error) {
+	return parseGameKey(SpawnsPrefix, key)
+}
+
+// DeltaKey is the key changes to a player's units in game are published
+// with. It also names the player's queue of the game's deltas.
+func DeltaKey(game, username string) (string, error) {
+	return gameKey(DeltasPrefix, game, username)
+}
+
+// ParseDeltaKey returns the game and player whose units a delta changes
+func ParseDeltaKey(key string) (game, username string, err error) {
+	return parseGameKey(DeltasPrefix, key)
+}
+
+// GameDeltasPattern binds every player's deltas in game
+func GameDeltasPattern(game string) (string, error) {
+	err := ValidateGameID(game)
+	if err != nil {
+		return "", err
+	}
+	return DeltasPrefix + "." + game + ".*", nil
+}
+
+// RejectionKey is the key requests the server refused from a player in
+// game are published with
+func RejectionKey(game, username string) (string, error) {
+	return gameKey(RejectionsPrefix, game, username)
+}
+
+// ParseRejectionKey returns the game and player whose request was refused
+func ParseRejectionKey(key string) (game, username string, err error) {
+	return parseGameKey(RejectionsPrefix, key)
+}
+
+// PlayerRejectionsPattern binds a player's rejections in every game. It
+// also names the player's queue of them.
+func PlayerRejectionsPattern(username string) (string, error) {
+	err := ValidateUsername(username)
+	if err != nil {
+		return "", err
+	}
+	return RejectionsPrefix + ".*." + username, nil
+}
+
+// GamePauseKey is the key game is paused and resumed with
+func GamePauseKey(game string) (string, error) {
+	err := ValidateGameID(game)
+	if err != nil {
+		return "", err
+	}
+	return PauseKey + "." + game, nil
+}
+
+// ParseGamePauseKey returns the game a pause key belongs to
+func ParseGamePauseKey(key string) (string, error) {
+	game, ok := strings.CutPrefix(key, PauseKey+".")
+	if !ok {
+		return "", fmt.Errorf("%s is not a %s key", key, PauseKey)
+	}
+	err := ValidateGameID(game)
+	if err != nil {
+		return "", err
+	}
+	return game, nil
+}
+
+// PauseQueue is the name of a player's queue of game's pause messages
+func PauseQueue(game, username string) (string, error) {
+	return gameKey(PauseKey, game, username)
+}
+
+// ParsePauseQueue returns the game and player a pause queue belongs to
+func ParsePauseQueue(queueName string) (game, username string, err error) {
+	return parseGameKey(PauseKey, queueName)
+}
+
+// LobbyKey is the key a player's lobby requests are published with
+func LobbyKey(username string) (string, error) {
+	return playerKey(LobbyPrefix, username)
+}
+
+// ParseLobbyKey returns the player a lobby request came from
+func ParseLobbyKey(key string) (string, error) {
+	return parsePlayerKey(LobbyPrefix, key)
+}
+
+// LobbyReplyKey is the key the lobby's replies to a player are published
+// with. It also names the player's queue of them.
+func LobbyReplyKey(username string) (string, error) {
+	return playerKey(LobbyRepliesPrefix, username)
+}
+
+// PresenceKey is the key a player's heartbeats and join and leave events
+// are published with
+func PresenceKey(username string) (string, error) {
+	return playerKey(PresencePrefix, username)
+}
+
+// ParsePresenceKey returns the player a presence key belongs to
+func ParsePresenceKey(key string) (string, error) {
+	return parsePlayerKey(PresencePrefix, key)
+}
+
+// BroadcastQueue is the name of a player's queue of server broadcasts,
+// which are all published with BroadcastKey to every game
+func BroadcastQueue(username string) (string, error) {
+	return playerKey(BroadcastKey, username)
+}
+
+func playerKey(prefix, username string) (string, error) {
+	err := ValidateUsername(username)
+	if err != nil {
+		return "", err
+	}
+	return prefix + "." + username, nil
+}
+
+func parsePlayerKey(prefix, key string) (string, error) {
+	username, ok := strings.CutPrefix(key, prefix+".")
+	if !ok {
+		return "", fmt.Errorf("%s is not a %s key", key, prefix)
+	}
+	err := ValidateUsername(username)
+	if err != nil {
+		return "", err
+	}
+	return username, nil
+}
+
+func gameKey(prefix, game, username string) (string, error) {
+	err := ValidateGameID(game)
+	if err != nil {
+		return "", err
+	}
+	return playerKey(prefix+"."+game, username)
+}
+
+func parseGameKey(prefix, key string) (game, username string, err error) {
+	rest, ok := strings.CutPrefix(key, prefix+".")
+	if !ok {
+		return "", "", fmt.Errorf("%s is not a %s key", key, prefix)
+	}
+	game, username, ok = strings.Cut(rest, ".")
+	if !ok {
+		return "", "", fmt.Errorf("%s is missing a game ID", key)
+	}
+	err = ValidateGameID(game)
+	if err != nil {
+		return "", "", err
+	}
+	err = ValidateUsername(username)
+	if err != nil {
+		return "", "", err
+	}
+	return game, username, nil
+}
@@ -0,0 +1,218 @@
+package routing_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing/topic"
+)
+
+// badWords can't be a routing key word, as a game ID or a username
+var badWords = []string{"", "a.b", "*", "#", "a*", "a#b", "a/b", "a+b", ">", "a b", "a\tb", "a\nb"}
+
+func TestValidateUsername(t *testing.T) {
+	for _, username := range append(badWords, routing.ServerUsername) {
+		err := routing.ValidateUsername(username)
+		if !errors.Is(err, routing.ErrInvalidUsername) {
+			t.Errorf("ValidateUsername(%q) = %v, want ErrInvalidUsername", username, err)
+		}
+	}
+	for _, username := range []string{"bob", "Bob_2", "server2", "élan"} {
+		if err := routing.ValidateUsername(username); err != nil {
+			t.Errorf("ValidateUsername(%q) = %v", username, err)
+		}
+	}
+}
+
+func TestValidateGameID(t *testing.T) {
+	for _, game := range badWords {
+		err := routing.ValidateGameID(game)
+		if !errors.Is(err, routing.ErrInvalidGameID) {
+			t.Errorf("ValidateGameID(%q) = %v, want ErrInvalidGameID", game, err)
+		}
+	}
+	// only usernames reserve the server's
+	if err := routing.ValidateGameID(routing.ServerUsername); err != nil {
+		t.Errorf("ValidateGameID(%q) = %v", routing.ServerUsername, err)
+	}
+}
+
+// gameKeys are the keys naming a game and a player, with the pattern that
+// binds them all where there is one
+var gameKeys = []struct {
+	name    string
+	build   func(game, username string) (string, error)
+	parse   func(key string) (game, username string, err error)
+	pattern string
+	want    string
+}{
+	{"army move", routing.ArmyMoveKey, routing.ParseArmyMoveKey, routing.ArmyMovesPattern, "army_moves.europe.bob"},
+	{"war", routing.WarKey, routing.ParseWarKey, routing.WarRecognitionsPattern, "war.europe.bob"},
+	{"game log", routing.GameLogKey, routing.ParseGameLogKey, routing.GameLogPattern, "game_logs.europe.bob"},
+	{"spawn", routing.SpawnKey, routing.ParseSpawnKey, routing.SpawnsPattern, "spawns.europe.bob"},
+	{"delta", routing.DeltaKey, routing.ParseDeltaKey, routing.DeltasPattern, "deltas.europe.bob"},
+	{"rejection", routing.RejectionKey, routing.ParseRejectionKey, routing.RejectionsPattern, "rejections.europe.bob"},
+	{"pause queue", routing.PauseQueue, routing.ParsePauseQueue, "", "pause.europe.bob"},
+}
+
+func TestGameKeys(t *testing.T) {
+	for _, tt := range gameKeys {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := tt.build("europe", "bob")
+			if err != nil || key != tt.want {
+				t.Fatalf("build = %q, %v, want %q", key, err, tt.want)
+			}
+			if tt.pattern != "" && !topic.Match(tt.pattern, key) {
+				t.Errorf("%s doesn't match %s", key, tt.pattern)
+			}
+			game, username, err := tt.parse(key)
+			if err != nil || game != "europe" || username != "bob" {
+				t.Errorf("parse(%q) = %q, %q, %v, want europe, bob", key, game, username, err)
+			}
+
+			for _, word := range badWords {
+				if key, err := tt.build(word, "bob"); err == nil {
+					t.Errorf("build(%q, bob) = %q", word, key)
+				}
+				if key, err := tt.build("europe", word); err == nil {
+					t.Errorf("build(europe, %q) = %q", word, key)
+				}
+			}
+			if key, err := tt.build("europe", routing.ServerUsername); err == nil {
+				t.Errorf("build(europe, %s) = %q", routing.ServerUsername, key)
+			}
+		})
+	}
+}
+
+func TestParseGameKeysRejects(t *testing.T) {
+	for _, tt := range gameKeys {
+		t.Run(tt.name, func(t *testing.T) {
+			prefix := tt.want[:len(tt.want)-len(".europe.bob")]
+			for _, key := range []string{
+				"",
+				prefix,
+				prefix + ".bob",
+				prefix + ".europe.bob.extra",
+				prefix + ".*.bob",
+				prefix + ".europe.*",
+				prefix + ".europe.#",
+				prefix + "..bob",
+				prefix + ".europe.",
+				prefix + ".europe." + routing.ServerUsername,
+				"other.europe.bob",
+				prefix + "x.europe.bob",
+			} {
+				game, username, err := tt.parse(key)
+				if err == nil {
+					t.Errorf("parse(%q) = %q, %q", key, game, username)
+				}
+			}
+		})
+	}
+}
+
+func TestPlayerKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		build   func(username string) (string, error)
+		parse   func(key string) (string, error)
+		pattern string
+		want    string
+	}{
+		{"lobby", routing.LobbyKey, routing.ParseLobbyKey, routing.LobbyPattern, "lobby.bob"},
+		{"lobby reply", routing.LobbyReplyKey, nil, routing.LobbyRepliesPattern, "lobby_replies.bob"},
+		{"presence", routing.PresenceKey, routing.ParsePresenceKey, routing.PresencePattern, "presence.bob"},
+		{"broadcast queue", routing.BroadcastQueue, nil, "", "broadcast.bob"},
+		{"rejections pattern", routing.PlayerRejectionsPattern, nil, "", "rejections.*.bob"},
+		{"v1 game log", nil, routing.ParseGameLogKeyV1, "", "game_logs.bob"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.build != nil {
+				key, err := tt.build("bob")
+				if err != nil || key != tt.want {
+					t.Fatalf("build = %q, %v, want %q", key, err, tt.want)
+				}
+				for _, word := range append(badWords, routing.ServerUsername) {
+					if key, err := tt.build(word); err == nil {
+						t.Errorf("build(%q) = %q", word, key)
+					}
+				}
+			}
+			if tt.pattern != "" && !topic.Match(tt.pattern, tt.want) {
+				t.Errorf("%s doesn't match %s", tt.want, tt.pattern)
+			}
+
+			if tt.parse == nil {
+				return
+			}
+			username, err := tt.parse(tt.want)
+			if err != nil || username != "bob" {
+				t.Errorf("parse(%q) = %q, %v, want bob", tt.want, username, err)
+			}
+			prefix := tt.want[:len(tt.want)-len(".bob")]
+			for _, key := range []string{
+				"",
+				prefix,
+				prefix + ".",
+				prefix + ".europe.bob",
+				prefix + ".*",
+				prefix + ".#",
+				prefix + "." + routing.ServerUsername,
+				"other.bob",
+			} {
+				if username, err := tt.parse(key); err == nil {
+					t.Errorf("parse(%q) = %q", key, username)
+				}
+			}
+		})
+	}
+}
+
+func TestGamePauseKey(t *testing.T) {
+	key, err := routing.GamePauseKey("europe")
+	if err != nil || key != "pause.europe" {
+		t.Fatalf("GamePauseKey(europe) = %q, %v, want pause.europe", key, err)
+	}
+	if !topic.Match(routing.PausePattern, key) {
+		t.Errorf("%s doesn't match %s", key, routing.PausePattern)
+	}
+	game, err := routing.ParseGamePauseKey(key)
+	if err != nil || game != "europe" {
+		t.Errorf("ParseGamePauseKey(%q) = %q, %v, want europe", key, game, err)
+	}
+
+	for _, word := range badWords {
+		if key, err := routing.GamePauseKey(word); err == nil {
+			t.Errorf("GamePauseKey(%q) = %q", word, key)
+		}
+	}
+	for _, key := range []string{"", "pause", "pause.", "pause.europe.bob", "pause.*", "paused.europe"} {
+		if game, err := routing.ParseGamePauseKey(key); err == nil {
+			t.Errorf("ParseGamePauseKey(%q) = %q", key, game)
+		}
+	}
+}
+
+func TestGameDeltasPattern(t *testing.T) {
+	pattern, err := routing.GameDeltasPattern("europe")
+	if err != nil || pattern != "deltas.europe.*" {
+		t.Fatalf("GameDeltasPattern(europe) = %q, %v, want deltas.europe.*", pattern, err)
+	}
+	key, _ := routing.DeltaKey("europe", "bob")
+	if !topic.Match(pattern, key) {
+		t.Errorf("%s doesn't match %s", key, pattern)
+	}
+	key, _ = routing.DeltaKey("africa", "bob")
+	if topic.Match(pattern, key) {
+		t.Errorf("%s matches %s", key, pattern)
+	}
+
+	for _, word := range badWords {
+		if pattern, err := routing.GameDeltasPattern(word); err == nil {
+			t.Errorf("GameDeltasPattern(%q) = %q", word, pattern)
+		}
+	}
+}
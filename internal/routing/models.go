@@ -2,12 +2,54 @@ package routing
 
 import "time"
 
+// PlayingState pauses or resumes one game
 type PlayingState struct {
+	Game     string
 	IsPaused bool
 }
 
+// Broadcast is a message from the server to every player
+type Broadcast struct {
+	CurrentTime time.Time
+	Message     string
+}
+
 type GameLog struct {
 	CurrentTime time.Time
 	Message     string
 	Username    string
+	Game        string
+}
+
+// Sender is the player who published the log
+func (gl GameLog) Sender() string {
+	return gl.Username
+}
+
+// clients send a heartbeat every HeartbeatInterval, and are considered gone
+// once PresenceTimeout passes without hearing from them
+const (
+	HeartbeatInterval = 5 * time.Second
+	PresenceTimeout   = 3 * HeartbeatInterval
+)
+
+// PresenceStatus is what a presence message announces
+type PresenceStatus string
+
+const (
+	PresenceJoined    PresenceStatus = "joined"
+	PresenceHeartbeat PresenceStatus = "heartbeat"
+	PresenceLeft      PresenceStatus = "left"
+)
+
+// Presence tells the server a player's client is running, or has quit
+type Presence struct {
+	CurrentTime time.Time
+	Username    string
+	Status      PresenceStatus
+}
+
+// Sender is the player whose client sent the message
+func (p Presence) Sender() string {
+	return p.Username
 }
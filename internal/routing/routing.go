@@ -7,7 +7,21 @@ const (
 
 	PauseKey = "pause"
 
+	BroadcastKey = "broadcast"
+
 	GameLogSlug = "game_logs"
+
+	SpawnsPrefix = "spawns"
+
+	DeltasPrefix = "deltas"
+
+	RejectionsPrefix = "rejections"
+
+	LobbyPrefix = "lobby"
+
+	LobbyRepliesPrefix = "lobby_replies"
+
+	PresencePrefix = "presence"
 )
 
 const (
@@ -0,0 +1,386 @@
+// Package schema generates JSON Schemas (draft 2020-12) from Go message
+// types, following encoding/json's rules for field names, and validates
+// JSON documents against them. Only the keywords it generates are
+// understood by Validate.
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const draft = "https://json-schema.org/draft/2020-12/schema"
+
+// Schema is a JSON Schema document or subschema
+type Schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	ID                   string             `json:"$id,omitempty"`
+	Ref                  string             `json:"$ref,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Type                 Types              `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	ContentEncoding      string             `json:"contentEncoding,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	PropertyNames        *Schema            `json:"propertyNames,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AnyOf                []*Schema          `json:"anyOf,omitempty"`
+	Defs                 map[string]*Schema `json:"$defs,omitempty"`
+}
+
+// Types is the type keyword, written as a string when there is only one
+type Types []string
+
+func (t Types) MarshalJSON() ([]byte, error) {
+	if len(t) == 1 {
+		return json.Marshal(t[0])
+	}
+	return json.Marshal([]string(t))
+}
+
+func (t *Types) UnmarshalJSON(b []byte) error {
+	var one string
+	if json.Unmarshal(b, &one) == nil {
+		*t = Types{one}
+		return nil
+	}
+	return json.Unmarshal(b, (*[]string)(t))
+}
+
+var (
+	timeType      = reflect.TypeFor[time.Time]()
+	marshalerType = reflect.TypeFor[json.Marshaler]()
+)
+
+// Of generates the schema of T
+func Of[T any]() (*Schema, error) {
+	return For(reflect.TypeFor[T]())
+}
+
+// For generates the schema of t. Named struct types go in $defs so they
+// are described once however often they appear.
+func For(t reflect.Type) (*Schema, error) {
+	g := &generator{defs: map[string]*Schema{}, refs: map[string]int{}}
+	s, err := g.schema(t)
+	if err != nil {
+		return nil, err
+	}
+
+	// a top-level struct is the document itself, not a reference to it,
+	// though recursive ones stay in $defs for their fields to refer to
+	if s.Ref != "" {
+		name := strings.TrimPrefix(s.Ref, "#/$defs/")
+		top := *g.defs[name]
+		if g.refs[name] == 1 {
+			delete(g.defs, name)
+		}
+		s = &top
+	}
+	s.Schema = draft
+	s.Title = t.Name()
+	if len(g.defs) > 0 {
+		s.Defs = g.defs
+	}
+	return s, nil
+}
+
+type generator struct {
+	defs map[string]*Schema
+	refs map[string]int
+}
+
+func (g *generator) schema(t reflect.Type) (*Schema, error) {
+	switch {
+	case t == timeType:
+		return &Schema{Type: Types{"string"}, Format: "date-time"}, nil
+	case t.Kind() != reflect.Pointer && t.Implements(marshalerType):
+		// encodes itself, so it could be anything
+		return &Schema{}, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: Types{"boolean"}}, nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Schema{Type: Types{"integer"}}, nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		zero := 0.0
+		return &Schema{Type: Types{"integer"}, Minimum: &zero}, nil
+
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: Types{"number"}}, nil
+
+	case reflect.String:
+		return &Schema{Type: Types{"string"}}, nil
+
+	case reflect.Interface:
+		return &Schema{}, nil
+
+	case reflect.Pointer:
+		s, err := g.schema(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return nullable(s), nil
+
+	case reflect.Slice, reflect.Array:
+		if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: Types{"string", "null"}, ContentEncoding: "base64"}, nil
+		}
+		items, err := g.schema(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		s := &Schema{Type: Types{"array"}, Items: items}
+		if t.Kind() == reflect.Slice {
+			s.Type = Types{"array", "null"}
+		}
+		return s, nil
+
+	case reflect.Map:
+		values, err := g.schema(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		s := &Schema{Type: Types{"object", "null"}, AdditionalProperties: values}
+		switch t.Key().Kind() {
+		case reflect.String:
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			s.PropertyNames = &Schema{Pattern: "^-?[0-9]+$"}
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			s.PropertyNames = &Schema{Pattern: "^[0-9]+$"}
+		default:
+			return nil, fmt.Errorf("map key type %s can't be encoded as JSON", t.Key())
+		}
+		return s, nil
+
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.object(t)
+		}
+		name := t.Name()
+		if _, ok := g.defs[name]; !ok {
+			// placeholder first, so recursive types terminate
+			g.defs[name] = &Schema{}
+			s, err := g.object(t)
+			if err != nil {
+				return nil, err
+			}
+			g.defs[name] = s
+		}
+		g.refs[name]++
+		return &Schema{Ref: "#/$defs/" + name}, nil
+	}
+
+	return nil, fmt.Errorf("%s can't be encoded as JSON", t)
+}
+
+// object describes a struct's fields. Fields without omitempty are always
+// encoded, so they are required; other fields are allowed, so adding one
+// doesn't break consumers of an older schema.
+func (g *generator) object(t reflect.Type) (*Schema, error) {
+	s := &Schema{Type: Types{"object"}, Properties: map[string]*Schema{}}
+	err := g.fields(t, s)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(s.Required)
+	return s, nil
+}
+
+func (g *generator) fields(t reflect.Type, s *Schema) error {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			err := g.fields(f.Type, s)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		fs, err := g.schema(f.Type)
+		if err != nil {
+			return fmt.Errorf("%s.%s: %v", t.Name(), f.Name, err)
+		}
+		s.Properties[name] = fs
+		if !strings.Contains(opts, "omitempty") {
+			s.Required = append(s.Required, name)
+		}
+	}
+	return nil
+}
+
+// nullable also accepts null, which is how nil pointers are encoded
+func nullable(s *Schema) *Schema {
+	if s.Ref != "" {
+		return &Schema{AnyOf: []*Schema{s, {Type: Types{"null"}}}}
+	}
+	if len(s.Type) == 0 || s.allows("null") {
+		return s
+	}
+	s.Type = append(s.Type, "null")
+	return s
+}
+
+// Validate checks that data is a JSON document s accepts
+func (s *Schema) Validate(data []byte) error {
+	var doc any
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	err := dec.Decode(&doc)
+	if err != nil {
+		return fmt.Errorf("invalid JSON: %v", err)
+	}
+	return s.validate(s, doc, "")
+}
+
+func (s *Schema) validate(root *Schema, v any, path string) error {
+	if s.Ref != "" {
+		name := strings.TrimPrefix(s.Ref, "#/$defs/")
+		def, ok := root.Defs[name]
+		if !ok {
+			return fmt.Errorf("%s: unknown $ref %s", pathOrRoot(path), s.Ref)
+		}
+		return def.validate(root, v, path)
+	}
+
+	if len(s.AnyOf) > 0 {
+		// the first alternative is the interesting one: nullable adds null
+		// after it
+		var first error
+		for _, alt := range s.AnyOf {
+			err := alt.validate(root, v, path)
+			if err == nil {
+				return nil
+			}
+			if first == nil {
+				first = err
+			}
+		}
+		return first
+	}
+
+	if len(s.Type) > 0 && !s.allows(typeOf(v)) && !(typeOf(v) == "integer" && s.allows("number")) {
+		return fmt.Errorf("%s: expected %s, got %s", pathOrRoot(path), strings.Join(s.Type, " or "), typeOf(v))
+	}
+
+	switch v := v.(type) {
+	case json.Number:
+		if s.Minimum != nil {
+			f, err := v.Float64()
+			if err != nil || f < *s.Minimum {
+				return fmt.Errorf("%s: %s is less than %v", pathOrRoot(path), v, *s.Minimum)
+			}
+		}
+
+	case string:
+		if s.Format == "date-time" {
+			_, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				return fmt.Errorf("%s: %q is not a date-time", pathOrRoot(path), v)
+			}
+		}
+		if s.Pattern != "" && !regexp.MustCompile(s.Pattern).MatchString(v) {
+			return fmt.Errorf("%s: %q doesn't match %s", pathOrRoot(path), v, s.Pattern)
+		}
+
+	case []any:
+		if s.Items != nil {
+			for i, item := range v {
+				err := s.Items.validate(root, item, fmt.Sprintf("%s/%d", path, i))
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				return fmt.Errorf("%s: missing required property %s", pathOrRoot(path), name)
+			}
+		}
+		for name, val := range v {
+			if s.PropertyNames != nil {
+				err := s.PropertyNames.validate(root, name, path+"/"+name)
+				if err != nil {
+					return err
+				}
+			}
+			prop, ok := s.Properties[name]
+			if !ok {
+				prop = s.AdditionalProperties
+			}
+			if prop == nil {
+				continue
+			}
+			err := prop.validate(root, val, path+"/"+name)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Schema) allows(typ string) bool {
+	for _, t := range s.Type {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+func typeOf(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func pathOrRoot(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return path
+}
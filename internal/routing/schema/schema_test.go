@@ -0,0 +1,231 @@
+package schema
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+type schemaTestUnit struct {
+	ID     int
+	Rank   string
+	Tags   []string `json:"tags,omitempty"`
+	Hidden string   `json:"-"`
+	hidden int
+}
+
+type schemaTestEmbedded struct {
+	schemaTestUnit
+	Location string
+}
+
+type schemaTestPlayer struct {
+	Username string `json:"username"`
+	Units    map[int]schemaTestUnit
+	Best     *schemaTestUnit
+	Joined   time.Time
+	Score    uint
+	Ratio    float64
+	Key      []byte
+}
+
+type schemaTestNode struct {
+	Value int
+	Next  *schemaTestNode
+}
+
+func TestFor(t *testing.T) {
+	unit := `{"type":"object","properties":{"ID":{"type":"integer"},"Rank":{"type":"string"},"tags":{"type":["array","null"],"items":{"type":"string"}}},"required":["ID","Rank"]}`
+
+	tests := []struct {
+		name   string
+		schema func() (*Schema, error)
+		want   string
+	}{
+		{
+			"omitempty fields aren't required and - fields are left out",
+			Of[schemaTestUnit],
+			`{"$schema":"` + draft + `","title":"schemaTestUnit",` + unit[1:],
+		},
+		{
+			"embedded fields are promoted",
+			Of[schemaTestEmbedded],
+			`{"$schema":"` + draft + `","title":"schemaTestEmbedded","type":"object","properties":{"ID":{"type":"integer"},"Location":{"type":"string"},"Rank":{"type":"string"},"tags":{"type":["array","null"],"items":{"type":"string"}}},"required":["ID","Location","Rank"]}`,
+		},
+		{
+			"nested structs are defined once",
+			Of[struct{ A, B schemaTestUnit }],
+			`{"$schema":"` + draft + `","type":"object","properties":{"A":{"$ref":"#/$defs/schemaTestUnit"},"B":{"$ref":"#/$defs/schemaTestUnit"}},"required":["A","B"],"$defs":{"schemaTestUnit":` + unit + `}}`,
+		},
+		{
+			"slices may be null",
+			Of[[]schemaTestUnit],
+			`{"$schema":"` + draft + `","type":["array","null"],"items":{"$ref":"#/$defs/schemaTestUnit"},"$defs":{"schemaTestUnit":` + unit + `}}`,
+		},
+		{
+			"arrays may not",
+			Of[[2]bool],
+			`{"$schema":"` + draft + `","type":"array","items":{"type":"boolean"}}`,
+		},
+		{
+			"byte slices are base64",
+			Of[[]byte],
+			`{"$schema":"` + draft + `","type":["string","null"],"contentEncoding":"base64"}`,
+		},
+		{
+			"maps with string keys",
+			Of[map[string]float64],
+			`{"$schema":"` + draft + `","type":["object","null"],"additionalProperties":{"type":"number"}}`,
+		},
+		{
+			"maps with integer keys",
+			Of[map[uint]int],
+			`{"$schema":"` + draft + `","type":["object","null"],"additionalProperties":{"type":"integer"},"propertyNames":{"pattern":"^[0-9]+$"}}`,
+		},
+		{
+			"pointers may be null",
+			Of[*string],
+			`{"$schema":"` + draft + `","type":["string","null"]}`,
+		},
+		{
+			"times are date-times",
+			Of[time.Time],
+			`{"$schema":"` + draft + `","title":"Time","type":"string","format":"date-time"}`,
+		},
+		{
+			"recursive structs stay in $defs",
+			Of[schemaTestNode],
+			`{"$schema":"` + draft + `","title":"schemaTestNode","type":"object","properties":{"Next":{"anyOf":[{"$ref":"#/$defs/schemaTestNode"},{"type":"null"}]},"Value":{"type":"integer"}},"required":["Next","Value"],"$defs":{"schemaTestNode":{"type":"object","properties":{"Next":{"anyOf":[{"$ref":"#/$defs/schemaTestNode"},{"type":"null"}]},"Value":{"type":"integer"}},"required":["Next","Value"]}}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := tt.schema()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := json.Marshal(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestForRefusesUnencodableTypes(t *testing.T) {
+	for name, schema := range map[string]func() (*Schema, error){
+		"channel":      Of[chan int],
+		"function":     Of[func()],
+		"bool map key": Of[map[bool]int],
+		"field":        Of[struct{ C chan int }],
+	} {
+		if _, err := schema(); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	s, err := Of[schemaTestPlayer]()
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := map[string]any{
+		"username": "bob",
+		"Units":    map[string]any{"1": map[string]any{"ID": 1, "Rank": "infantry"}},
+		"Best":     nil,
+		"Joined":   "2024-01-02T03:04:05Z",
+		"Score":    3,
+		"Ratio":    0.5,
+		"Key":      nil,
+	}
+
+	tests := []struct {
+		name    string
+		change  func(doc map[string]any)
+		wantErr string
+	}{
+		{"valid", func(map[string]any) {}, ""},
+		{"unknown properties are allowed", func(doc map[string]any) { doc["Extra"] = true }, ""},
+		{"integers are numbers", func(doc map[string]any) { doc["Ratio"] = 1 }, ""},
+		{"pointer to a struct", func(doc map[string]any) { doc["Best"] = map[string]any{"ID": 2, "Rank": "cavalry"} }, ""},
+		{"null map", func(doc map[string]any) { doc["Units"] = nil }, ""},
+		{"missing property", func(doc map[string]any) { delete(doc, "username") }, "/: missing required property username"},
+		{"missing nested property", func(doc map[string]any) { doc["Best"] = map[string]any{"ID": 2} }, "/Best: missing required property Rank"},
+		{"string for an integer", func(doc map[string]any) { doc["Score"] = "3" }, "/Score: expected integer, got string"},
+		{"fraction for an integer", func(doc map[string]any) { doc["Score"] = 1.5 }, "/Score: expected integer, got number"},
+		{"negative unsigned", func(doc map[string]any) { doc["Score"] = -1 }, "/Score: -1 is less than 0"},
+		{"null for a string", func(doc map[string]any) { doc["username"] = nil }, "/username: expected string, got null"},
+		{"bad date-time", func(doc map[string]any) { doc["Joined"] = "yesterday" }, `/Joined: "yesterday" is not a date-time`},
+		{"non-integer map key", func(doc map[string]any) {
+			doc["Units"] = map[string]any{"one": map[string]any{"ID": 1, "Rank": "infantry"}}
+		}, `/Units/one: "one" doesn't match`},
+		{"wrong type in a map value", func(doc map[string]any) {
+			doc["Units"] = map[string]any{"1": map[string]any{"ID": "1", "Rank": "infantry"}}
+		}, "/Units/1/ID: expected integer, got string"},
+		{"wrong type in a slice", func(doc map[string]any) {
+			doc["Units"] = map[string]any{"1": map[string]any{"ID": 1, "Rank": "infantry", "tags": []any{"a", 2}}}
+		}, "/Units/1/tags/1: expected string, got integer"},
+		{"empty object", func(doc map[string]any) { clear(doc) }, "missing required property"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := map[string]any{}
+			for k, v := range valid {
+				doc[k] = v
+			}
+			tt.change(doc)
+			b, err := json.Marshal(doc)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = s.Validate(b)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate(%s): %v", b, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate(%s) = %v, want %q", b, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateRecursive(t *testing.T) {
+	s, err := Of[schemaTestNode]()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Validate([]byte(`{"Value":1,"Next":{"Value":2,"Next":null}}`))
+	if err != nil {
+		t.Errorf("Validate: %v", err)
+	}
+	err = s.Validate([]byte(`{"Value":1,"Next":{"Value":"2","Next":null}}`))
+	if err == nil {
+		t.Error("Validate accepted a string deep in a list")
+	}
+}
+
+func TestValidateRefusesInvalidJSON(t *testing.T) {
+	s, err := Of[schemaTestUnit]()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, doc := range []string{``, `{"ID":`, `[1,2`} {
+		if err := s.Validate([]byte(doc)); err == nil {
+			t.Errorf("Validate(%q) accepted invalid JSON", doc)
+		}
+	}
+	if err := s.Validate([]byte(`[]`)); err == nil {
+		t.Error("Validate accepted an array for an object")
+	}
+}
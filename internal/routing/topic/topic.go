@@ -0,0 +1,169 @@
+// Package topic matches routing keys against topic exchange binding
+// patterns the way RabbitMQ does. Keys and patterns are dot separated words;
+// in a pattern * matches exactly one word and # matches zero or more. The
+// empty key has no words at all, so only "" and "#" match it, while
+// "a..b" has an empty middle word that * will match.
+package topic
+
+import "strings"
+
+// Match reports whether key matches pattern
+func Match(pattern, key string) bool {
+	p, w := Split(pattern), Split(key)
+
+	// matched[j] is whether the pattern words seen so far, taken from the
+	// end, match w[j:]
+	matched := make([]bool, len(w)+1)
+	matched[len(w)] = true
+	for i := len(p) - 1; i >= 0; i-- {
+		next := make([]bool, len(w)+1)
+		for j := len(w); j >= 0; j-- {
+			switch {
+			case p[i] == "#":
+				next[j] = matched[j] || (j < len(w) && next[j+1])
+			case j < len(w):
+				next[j] = (p[i] == "*" || p[i] == w[j]) && matched[j+1]
+			}
+		}
+		matched = next
+	}
+	return matched[0]
+}
+
+// Covers reports whether every key binding matches also matches pattern,
+// so a queue bound with binding only gets messages pattern would. Wildcards
+// in binding are wildcards here, unlike in Match. It errs on the side of
+// false: some equivalent arrangements, like *.# and #.*, aren't covered.
+func Covers(pattern, binding string) bool {
+	p, b := Split(pattern), Split(binding)
+
+	// covered[j] is whether the pattern words seen so far, taken from the
+	// end, cover b[j:]
+	covered := make([]bool, len(b)+1)
+	covered[len(b)] = true
+	for i := len(p) - 1; i >= 0; i-- {
+		next := make([]bool, len(b)+1)
+		for j := len(b); j >= 0; j-- {
+			switch {
+			case p[i] == "#":
+				next[j] = covered[j] || (j < len(b) && next[j+1])
+			case j < len(b) && b[j] != "#":
+				next[j] = (p[i] == "*" || p[i] == b[j]) && covered[j+1]
+			}
+		}
+		covered = next
+	}
+	return covered[0]
+}
+
+// Split breaks a key or pattern into its words
+func Split(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ".")
+}
+
+// Trie holds many bindings and finds those a key matches in time that
+// depends on the key and the shape of the patterns rather than their
+// number. It is not safe for concurrent use.
+type Trie[T comparable] struct {
+	root *node[T]
+}
+
+type node[T comparable] struct {
+	children map[string]*node[T]
+	values   map[T]struct{}
+}
+
+func newNode[T comparable]() *node[T] {
+	return &node[T]{
+		children: map[string]*node[T]{},
+		values:   map[T]struct{}{},
+	}
+}
+
+func NewTrie[T comparable]() *Trie[T] {
+	return &Trie[T]{root: newNode[T]()}
+}
+
+// Add binds v to pattern. Adding the same binding twice has no effect.
+func (t *Trie[T]) Add(pattern string, v T) {
+	n := t.root
+	for _, word := range Split(pattern) {
+		child, ok := n.children[word]
+		if !ok {
+			child = newNode[T]()
+			n.children[word] = child
+		}
+		n = child
+	}
+	n.values[v] = struct{}{}
+}
+
+// Remove unbinds v from pattern, pruning nodes nothing is bound under
+func (t *Trie[T]) Remove(pattern string, v T) {
+	t.remove(t.root, Split(pattern), v)
+}
+
+// remove reports whether n is left empty
+func (t *Trie[T]) remove(n *node[T], words []string, v T) bool {
+	if len(words) == 0 {
+		delete(n.values, v)
+	} else if child, ok := n.children[words[0]]; ok && t.remove(child, words[1:], v) {
+		delete(n.children, words[0])
+	}
+	return len(n.values) == 0 && len(n.children) == 0
+}
+
+// Match returns every value bound to a pattern key matches, each once
+func (t *Trie[T]) Match(key string) []T {
+	m := &matcher[T]{
+		seen:    map[T]struct{}{},
+		visited: map[visit[T]]struct{}{},
+	}
+	m.walk(t.root, Split(key))
+	return m.out
+}
+
+type visit[T comparable] struct {
+	n    *node[T]
+	left int
+}
+
+type matcher[T comparable] struct {
+	out  []T
+	seen map[T]struct{}
+	// visited stops patterns with several #s from exploring the same
+	// node and remaining words more than once
+	visited map[visit[T]]struct{}
+}
+
+func (m *matcher[T]) walk(n *node[T], words []string) {
+	v := visit[T]{n, len(words)}
+	if _, ok := m.visited[v]; ok {
+		return
+	}
+	m.visited[v] = struct{}{}
+
+	if hash, ok := n.children["#"]; ok {
+		for i := 0; i <= len(words); i++ {
+			m.walk(hash, words[i:])
+		}
+	}
+	if len(words) == 0 {
+		for v := range n.values {
+			if _, ok := m.seen[v]; !ok {
+				m.seen[v] = struct{}{}
+				m.out = append(m.out, v)
+			}
+		}
+		return
+	}
+	if child, ok := n.children[words[0]]; ok {
+		m.walk(child, words[1:])
+	}
+	if child, ok := n.children["*"]; ok && words[0] != "*" {
+		m.walk(child, words[1:])
+	}
+}
@@ -0,0 +1,183 @@
+package topic
+
+import (
+	"slices"
+	"testing"
+)
+
+var matchTests = []struct {
+	pattern string
+	key     string
+	want    bool
+}{
+	// the empty key has no words
+	{"", "", true},
+	{"#", "", true},
+	{"*", "", false},
+	{"#.#", "", true},
+	{"a", "", false},
+	{"", "a", false},
+
+	// # matches zero or more words
+	{"#", "a", true},
+	{"#", "a.b.c", true},
+	{"a.#", "a", true},
+	{"a.#", "a.b.c", true},
+	{"#.c", "a.b.c", true},
+	{"a.#.c", "a.c", true},
+	{"a.#.c", "a.b.b.c", true},
+	{"a.#.c", "a.b.b", false},
+	{"#.b.#", "a.b.c", true},
+	{"#.b.#", "a.c", false},
+
+	// * matches exactly one word
+	{"*", "a", true},
+	{"*", "a.b", false},
+	{"a.*", "a", false},
+	{"army_moves.*.*", "army_moves.europe.bob", true},
+	{"army_moves.*.*", "army_moves.europe", false},
+	{"*.#", "", false},
+	{"*.#", "a", true},
+
+	// "a..b" has an empty middle word
+	{"a..b", "a..b", true},
+	{"a.*.b", "a..b", true},
+	{"a.#", "a..b", true},
+	{"a.b", "a..b", false},
+	{"a..b", "a.b", false},
+	{"*.*", ".", true},
+	{"*", ".", false},
+
+	// wildcards in keys are plain words
+	{"a.*", "a.*", true},
+	{"a.b", "a.*", false},
+	{"a.#", "a.#", true},
+}
+
+func TestMatch(t *testing.T) {
+	for _, tt := range matchTests {
+		if got := Match(tt.pattern, tt.key); got != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.pattern, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestCovers(t *testing.T) {
+	tests := []struct {
+		pattern string
+		binding string
+		want    bool
+	}{
+		{"a.b", "a.b", true},
+		{"a.b", "a.c", false},
+		{"a.*", "a.b", true},
+		{"a.*", "a.*", true},
+		{"a.*", "a.#", false},
+		{"a.b", "a.*", false},
+		{"a.*.*", "a.b.*", true},
+		{"a.*.*", "a.*", false},
+		{"a.#", "a", true},
+		{"a.#", "a.#", true},
+		{"a.#", "a.b.#", true},
+		{"a.#", "a.*.*", true},
+		{"a.#", "#", false},
+		{"#", "#", true},
+		{"#", "a.#.b", true},
+		{"#", "", true},
+		{"", "", true},
+		{"*", "", false},
+		{"*.#", "a.#", true},
+		{"#.b", "a.*.b", true},
+		{"#.b", "a.#", false},
+		// equivalent, but not worked out
+		{"*.#", "#.*", false},
+	}
+	for _, tt := range tests {
+		if got := Covers(tt.pattern, tt.binding); got != tt.want {
+			t.Errorf("Covers(%q, %q) = %v, want %v", tt.pattern, tt.binding, got, tt.want)
+		}
+	}
+}
+
+// a binding a pattern covers never matches a key the pattern doesn't
+func TestCoversIsSound(t *testing.T) {
+	for _, p := range matchTests {
+		for _, b := range matchTests {
+			if !Covers(p.pattern, b.pattern) {
+				continue
+			}
+			for _, k := range matchTests {
+				if Match(b.pattern, k.key) && !Match(p.pattern, k.key) {
+					t.Errorf("Covers(%q, %q), but only %q matches %q", p.pattern, b.pattern, b.pattern, k.key)
+				}
+			}
+		}
+	}
+}
+
+func TestTrieMatch(t *testing.T) {
+	for _, tt := range matchTests {
+		trie := NewTrie[string]()
+		trie.Add(tt.pattern, tt.pattern)
+		got := len(trie.Match(tt.key)) == 1
+		if got != tt.want {
+			t.Errorf("trie with %q matching %q = %v, want %v", tt.pattern, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestTrieRemove(t *testing.T) {
+	trie := NewTrie[int]()
+	trie.Add("a.*", 1)
+	trie.Add("a.#", 2)
+	trie.Add("a.#", 3)
+
+	trie.Remove("a.#", 2)
+	// removing what was never bound is a no-op
+	trie.Remove("a.b", 1)
+	trie.Remove("a.*", 4)
+
+	got := trie.Match("a.b")
+	slices.Sort(got)
+	if want := []int{1, 3}; !slices.Equal(got, want) {
+		t.Errorf("Match(a.b) = %v, want %v", got, want)
+	}
+
+	trie.Remove("a.*", 1)
+	trie.Remove("a.#", 3)
+	if len(trie.root.children) != 0 {
+		t.Errorf("empty trie still has %d children", len(trie.root.children))
+	}
+}
+
+// FuzzMatch checks that a trie of several patterns returns exactly the
+// patterns Match says a key matches, each once
+func FuzzMatch(f *testing.F) {
+	for _, tt := range matchTests {
+		f.Add(tt.pattern, "#.b", "*.*", tt.key)
+	}
+	f.Add("a.#.#.b", "#.#", "a.*.#", "a.x.y.b")
+
+	f.Fuzz(func(t *testing.T, p1, p2, p3, key string) {
+		patterns := []string{p1, p2, p3}
+		trie := NewTrie[int]()
+		for i, p := range patterns {
+			trie.Add(p, i)
+		}
+
+		got := trie.Match(key)
+		slices.Sort(got)
+		if len(slices.Compact(slices.Clone(got))) != len(got) {
+			t.Fatalf("trie matched %q to %v, with repeats", key, got)
+		}
+		var want []int
+		for i, p := range patterns {
+			if Match(p, key) {
+				want = append(want, i)
+			}
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("patterns %q: trie matched %q to %v, Match to %v", patterns, key, got, want)
+		}
+	})
+}
@@ -0,0 +1,163 @@
+package routing
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Changing a message type goes like this: copy the struct as it was to an
+// unexported type such as armyMoveV1, change the struct, then register the
+// new version and an upcaster from the old one, e.g.
+//
+//	RegisterVersion[ArmyMove](2)
+//	RegisterUpcaster(1, func(old armyMoveV1) (ArmyMove, error) { ... })
+//
+// Subscribers then upcast v1 payloads from players who haven't upgraded
+// yet. Binaries that predate the change still decode v2 payloads as best
+// their codec can, since both JSON and gob skip fields they don't know.
+//
+// If the change also moved the route, old publishers still use the old
+// keys, so the old route is registered too:
+//
+//	RegisterLegacyRoute[ArmyMove](1, ExchangePerilTopic, "army_moves.*")
+//
+// Only old publishers are covered: old subscribers stay bound to the old
+// route, which current publishers no longer use.
+
+// RegisterVersion sets the version values of T are published as. T must
+// already be registered.
+func RegisterVersion[T any](version int) {
+	t := reflect.TypeFor[T]()
+	r, ok := catalog[t]
+	if !ok {
+		panic(fmt.Sprintf("routing: %s is not registered", t))
+	}
+	if version < r.Version {
+		panic(fmt.Sprintf("routing: %s version can't go back from %d to %d", t, r.Version, version))
+	}
+	r.Version = version
+	catalog[t] = r
+}
+
+// RegisterUpcaster converts payloads published as version from of T, whose
+// shape is Old, into the current T. Each old version needs its own
+// upcaster; there is no chaining.
+func RegisterUpcaster[Old, T any](from int, upcast func(Old) (T, error)) {
+	t := reflect.TypeFor[T]()
+	r, ok := catalog[t]
+	if !ok {
+		panic(fmt.Sprintf("routing: %s is not registered", t))
+	}
+	if from >= r.Version {
+		panic(fmt.Sprintf("routing: %s upcaster from version %d isn't older than version %d", t, from, r.Version))
+	}
+	if _, ok := r.upcasters[from]; ok {
+		panic(fmt.Sprintf("routing: %s upcaster from version %d registered twice", t, from))
+	}
+	codec := r.Codec
+	r.upcasters[from] = func(body []byte) (any, error) {
+		old, err := decode[Old](codec, body)
+		if err != nil {
+			return nil, err
+		}
+		return upcast(old)
+	}
+}
+
+// legacyRoute is where a version of a type was published before its route
+// changed
+type legacyRoute struct {
+	version  int
+	exchange string
+	pattern  string
+}
+
+// RegisterLegacyRoute records that version of T was published to exchange
+// with keys matching pattern. Subscribers bound to T's whole pattern bind
+// the legacy one as well, so publishers that haven't upgraded still reach
+// them. T must already have an upcaster from version.
+func RegisterLegacyRoute[T any](version int, exchange, pattern string) {
+	t := reflect.TypeFor[T]()
+	r, ok := catalog[t]
+	if !ok {
+		panic(fmt.Sprintf("routing: %s is not registered", t))
+	}
+	if _, ok := r.upcasters[version]; !ok {
+		panic(fmt.Sprintf("routing: %s has no upcaster from version %d", t, version))
+	}
+	r.legacy = append(r.legacy, legacyRoute{version: version, exchange: exchange, pattern: pattern})
+	catalog[t] = r
+}
+
+// LegacyQueue is the name of the queue a subscriber to queueName binds to
+// the legacy route of version
+func LegacyQueue(queueName string, version int) string {
+	return fmt.Sprintf("%s.v%d", queueName, version)
+}
+
+// subscribeLegacy binds a queue to each of r's legacy routes. They only
+// take payloads of their own version, so current payloads can't be
+// published around the keys subscribers check.
+func subscribeLegacy[T any](
+	transport pubsub.Transport,
+	r Route,
+	queueName string,
+	queueType pubsub.QueueType,
+	handler func(T) pubsub.Acktype,
+	decode func(amqp.Delivery) (T, error),
+	opts ...pubsub.SubscribeOption,
+) error {
+	for _, l := range r.legacy {
+		err := pubsub.Subscribe(transport, l.exchange, LegacyQueue(queueName, l.version), l.pattern, queueType, handler, func(d amqp.Delivery) (T, error) {
+			if v := pubsub.MessageVersion(d.Headers); v != l.version {
+				var zero T
+				return zero, fmt.Errorf("%s version %d published to version %d's route %s", r.Type, v, l.version, l.pattern)
+			}
+			return decode(d)
+		}, opts...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// versionDecoder decodes deliveries of any version of r's type into T
+func versionDecoder[T any](r Route) func(amqp.Delivery) (T, error) {
+	return func(d amqp.Delivery) (T, error) {
+		v := pubsub.MessageVersion(d.Headers)
+		if v >= r.Version {
+			return decode[T](r.Codec, d.Body)
+		}
+
+		upcast, ok := r.upcasters[v]
+		if !ok {
+			var zero T
+			return zero, fmt.Errorf("no upcaster from %s version %d to %d", r.Type, v, r.Version)
+		}
+		val, err := upcast(d.Body)
+		if err != nil {
+			var zero T
+			return zero, fmt.Errorf("could not upcast %s version %d: %v", r.Type, v, err)
+		}
+		return val.(T), nil
+	}
+}
+
+func decode[T any](codec Codec, body []byte) (T, error) {
+	var val T
+	var err error
+	switch codec {
+	case Gob:
+		err = gob.NewDecoder(bytes.NewReader(body)).Decode(&val)
+	default:
+		err = json.Unmarshal(body, &val)
+	}
+	return val, err
+}
@@ -0,0 +1,257 @@
+package routing_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// openLog returns a file log transport, which needs no broker
+func openLog(t *testing.T) *pubsub.FileLogTransport {
+	t.Helper()
+	transport, err := pubsub.OpenFileLog(t.TempDir(), 1, pubsub.StartEarliest)
+	if err != nil {
+		t.Fatalf("OpenFileLog: %v", err)
+	}
+	t.Cleanup(func() { transport.Close() })
+	return transport
+}
+
+// receive subscribes to T's route and returns the first value handled
+func receive[T any](t *testing.T, transport pubsub.Transport, publish func() error) T {
+	t.Helper()
+	received := make(chan T, 1)
+	err := routing.Subscribe(transport, "test", func(val T) pubsub.Acktype {
+		received <- val
+		return pubsub.Ack
+	})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	err = publish()
+	if err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+
+	select {
+	case val := <-received:
+		return val
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler was never called")
+	}
+	var zero T
+	return zero
+}
+
+func TestVersionsBumpedForGames(t *testing.T) {
+	for _, r := range []struct {
+		route func() (routing.Route, error)
+		want  int
+	}{
+		{routing.RouteOf[gamelogic.ArmyMove], 2},
+		{routing.RouteOf[gamelogic.RecognitionOfWar], 2},
+		{routing.RouteOf[routing.GameLog], 2},
+		{routing.RouteOf[routing.PlayingState], 2},
+	} {
+		route, err := r.route()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if route.Version != r.want {
+			t.Errorf("%s is version %d, want %d", route.Type, route.Version, r.want)
+		}
+	}
+}
+
+// v1 clients published without a version header, and to keys that predate
+// games, so these publish exactly as they did
+
+func TestUpcastArmyMoveV1(t *testing.T) {
+	transport := openLog(t)
+
+	v1 := struct {
+		Player     gamelogic.Player
+		Units      []gamelogic.Unit
+		ToLocation gamelogic.Location
+	}{
+		Player:     gamelogic.Player{Username: "bob", Units: map[int]gamelogic.Unit{1: {ID: 1, Rank: gamelogic.RankInfantry, Location: "europe"}}},
+		Units:      []gamelogic.Unit{{ID: 1, Rank: gamelogic.RankInfantry, Location: "europe"}},
+		ToLocation: "asia",
+	}
+	got := receive[gamelogic.ArmyMove](t, transport, func() error {
+		return pubsub.PublishJSON(transport, routing.ExchangePerilTopic, "army_moves.*", v1)
+	})
+
+	want := gamelogic.ArmyMove{Player: v1.Player, Units: v1.Units, ToLocation: v1.ToLocation}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("upcast to %+v, want %+v", got, want)
+	}
+}
+
+func TestUpcastGameLogV1(t *testing.T) {
+	transport := openLog(t)
+
+	// game logs are gob encoded, which matches fields by name
+	v1 := struct {
+		CurrentTime time.Time
+		Message     string
+		Username    string
+	}{
+		CurrentTime: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Message:     "bob won a war",
+		Username:    "bob",
+	}
+	got := receive[routing.GameLog](t, transport, func() error {
+		return pubsub.PublishGob(transport, routing.ExchangePerilTopic, "game_logs.bob", v1)
+	})
+
+	want := routing.GameLog{CurrentTime: v1.CurrentTime, Message: v1.Message, Username: v1.Username}
+	if !got.CurrentTime.Equal(want.CurrentTime) || got.Message != want.Message || got.Username != want.Username || got.Game != "" {
+		t.Errorf("upcast to %+v, want %+v", got, want)
+	}
+}
+
+func TestSubscribeKeyedBindsLegacyRoutes(t *testing.T) {
+	transport := openLog(t)
+
+	received := make(chan routing.Keyed[routing.GameLog], 1)
+	err := routing.SubscribeKeyed(transport, "test", func(k routing.Keyed[routing.GameLog]) pubsub.Acktype {
+		received <- k
+		return pubsub.Ack
+	})
+	if err != nil {
+		t.Fatalf("SubscribeKeyed: %v", err)
+	}
+	err = pubsub.PublishGob(transport, routing.ExchangePerilTopic, "game_logs.bob", routing.GameLog{Username: "bob", Message: "hello"})
+	if err != nil {
+		t.Fatalf("PublishGob: %v", err)
+	}
+
+	select {
+	case k := <-received:
+		if k.Key != "game_logs.bob" || k.Val.Username != "bob" {
+			t.Errorf("got %+v", k)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler was never called")
+	}
+}
+
+// current payloads published to a legacy route would skip the key checks
+// made against their game, so only the legacy version is taken from it
+func TestLegacyRoutesOnlyTakeTheirVersion(t *testing.T) {
+	transport := openLog(t)
+
+	received := make(chan gamelogic.ArmyMove, 1)
+	err := routing.Subscribe(transport, "test", func(m gamelogic.ArmyMove) pubsub.Acktype {
+		received <- m
+		return pubsub.Ack
+	})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	err = pubsub.PublishJSON(transport, routing.ExchangePerilTopic, "army_moves.bob", gamelogic.ArmyMove{Game: "europe"}, pubsub.WithVersion(2))
+	if err != nil {
+		t.Fatalf("PublishJSON: %v", err)
+	}
+
+	select {
+	case m := <-received:
+		t.Errorf("version 2 move on a legacy route was delivered: %+v", m)
+	case <-time.After(500 * time.Millisecond):
+	}
+}
+
+// wars and pauses only come from the server, so they have no legacy routes
+// or upcasters, and unversioned ones are dead-lettered
+func TestServerMessagesHaveNoV1(t *testing.T) {
+	transport := openLog(t)
+
+	received := make(chan routing.PlayingState, 1)
+	err := routing.Subscribe(transport, "test", func(ps routing.PlayingState) pubsub.Acktype {
+		received <- ps
+		return pubsub.Ack
+	})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	err = pubsub.PublishJSON(transport, routing.ExchangePerilTopic, "pause.europe", routing.PlayingState{Game: "europe", IsPaused: true})
+	if err != nil {
+		t.Fatalf("PublishJSON: %v", err)
+	}
+
+	select {
+	case ps := <-received:
+		t.Errorf("unversioned pause was delivered: %+v", ps)
+	case <-time.After(500 * time.Millisecond):
+	}
+}
+
+func TestCurrentVersionIsNotUpcast(t *testing.T) {
+	transport := openLog(t)
+
+	sent := gamelogic.RecognitionOfWar{
+		Game:     "europe",
+		Attacker: gamelogic.Player{Username: "alice", Units: map[int]gamelogic.Unit{}},
+		Defender: gamelogic.Player{Username: "bob", Units: map[int]gamelogic.Unit{}},
+	}
+	got := receive[gamelogic.RecognitionOfWar](t, transport, func() error {
+		return routing.Publish(transport, sent)
+	})
+	if !reflect.DeepEqual(got, sent) {
+		t.Errorf("got %+v, want %+v", got, sent)
+	}
+}
+
+// newer versions are decoded as the current one, so they must conform to
+// its schema; only older ones are left to their upcasters
+func TestValidateSchemaChecksNewerVersions(t *testing.T) {
+	validate, err := routing.ValidateSchema[gamelogic.ArmyMove]()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		version int
+		want    bool
+	}{
+		{"older", 1, true},
+		{"current", 2, false},
+		{"newer", 99, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport := openLog(t)
+			received := make(chan gamelogic.ArmyMove, 1)
+			err := routing.Subscribe(transport, "test", func(m gamelogic.ArmyMove) pubsub.Acktype {
+				received <- m
+				return pubsub.Ack
+			}, validate)
+			if err != nil {
+				t.Fatalf("Subscribe: %v", err)
+			}
+			// missing Game, which every version since 2 requires
+			err = pubsub.PublishJSON(transport, routing.ExchangePerilTopic, "army_moves.europe.bob", map[string]any{"ToLocation": "asia"}, pubsub.WithVersion(tt.version))
+			if err != nil {
+				t.Fatalf("PublishJSON: %v", err)
+			}
+
+			select {
+			case <-received:
+				if !tt.want {
+					t.Errorf("version %d without a game was delivered", tt.version)
+				}
+			case <-time.After(500 * time.Millisecond):
+				if tt.want {
+					t.Errorf("version %d without a game was never delivered", tt.version)
+				}
+			}
+		})
+	}
+}